@@ -0,0 +1,224 @@
+package net
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Every request starts with a frame header. All numbers are little endian
+// magic (4 bytes), protocol version (1 byte), flags (1 byte), request ID (4 bytes), command (CommandLength bytes),
+// payload length (4 bytes), extra data length (4 bytes), checksum of payload and extra data (4 bytes)
+const NetworkMagic = uint32(0xD3C01B4E)
+const FrameVersion = 2
+const FrameChecksumLength = 4
+const FrameHeaderLength = 4 + 1 + 1 + 4 + CommandLength + 4 + 4 + FrameChecksumLength
+
+// position of flags in the header
+const frameFlagsOffset = 5
+
+// Frame is sent in a peer session. The connection stays opened after it
+const FrameFlagSession = uint8(1)
+
+// Frame is a response to a request with same ID. Payload starts with success flag byte
+const FrameFlagResponse = uint8(2)
+
+// Extra data is only auth string now
+const MaxExtraDataSize = 64
+
+// No any command can be bigger. Responses are limited with this size too
+const MaxPayloadSize = 32 * 1024 * 1024
+
+// Limit for commands that are not listed in commandPayloadLimits
+const DefaultPayloadLimit = 64 * 1024
+
+// Max size of payload for commands that can have big data
+var commandPayloadLimits = map[string]uint32{
+	"block":       MaxPayloadSize,
+	"cmpctblock":  MaxPayloadSize,
+	"getblocktxn": 1024 * 1024,
+	"inv":         4 * 1024 * 1024,
+	"mempool":     4 * 1024 * 1024,
+	"addr":        1024 * 1024,
+	"tx":          1024 * 1024,
+	"txfull":      1024 * 1024,
+	"txdata":      1024 * 1024,
+	"txrequest":   1024 * 1024,
+	"gethistory":  1024 * 1024,
+	"getunspent":  1024 * 1024,
+}
+
+type FrameHeader struct {
+	Magic         uint32
+	Version       uint8
+	Flags         uint8
+	ID            uint32
+	Command       string
+	PayloadLength uint32
+	ExtraLength   uint32
+	Checksum      []byte
+}
+
+// Returns max allowed size of payload for a command
+func GetCommandPayloadLimit(command string) uint32 {
+	if limit, ok := commandPayloadLimits[command]; ok {
+		return limit
+	}
+	return DefaultPayloadLimit
+}
+
+// Builds full request data: frame header, payload and extra data
+func BuildFrame(command string, payload []byte, extra []byte) ([]byte, error) {
+	return BuildSessionFrame(command, 0, 0, payload, extra)
+}
+
+// Builds frame with flags and request ID. ID is used to find a response in a peer session
+func BuildSessionFrame(command string, flags uint8, id uint32, payload []byte, extra []byte) ([]byte, error) {
+	if len(command) > CommandLength {
+		return nil, errors.New(fmt.Sprintf("Command %s is too long", command))
+	}
+
+	err := checkFrameSizes(command, flags, uint32(len(payload)), uint32(len(extra)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+
+	binary.Write(&buff, binary.LittleEndian, NetworkMagic)
+	buff.WriteByte(FrameVersion)
+	buff.WriteByte(flags)
+	binary.Write(&buff, binary.LittleEndian, id)
+	buff.Write(CommandToBytes(command))
+	binary.Write(&buff, binary.LittleEndian, uint32(len(payload)))
+	binary.Write(&buff, binary.LittleEndian, uint32(len(extra)))
+	buff.Write(FrameChecksum(payload, extra))
+	buff.Write(payload)
+	buff.Write(extra)
+
+	return buff.Bytes(), nil
+}
+
+// Parses frame header. Returns error if the frame is from other network or sizes are over limits.
+// Data must be FrameHeaderLength bytes
+func ParseFrameHeader(data []byte) (FrameHeader, error) {
+	h := FrameHeader{}
+
+	if len(data) != FrameHeaderLength {
+		return h, errors.New("Wrong length of frame header")
+	}
+
+	r := bytes.NewReader(data)
+
+	binary.Read(r, binary.LittleEndian, &h.Magic)
+
+	if h.Magic != NetworkMagic {
+		return h, errors.New(fmt.Sprintf("Wrong network magic %x", h.Magic))
+	}
+
+	binary.Read(r, binary.LittleEndian, &h.Version)
+
+	if h.Version != FrameVersion {
+		return h, errors.New(fmt.Sprintf("Protocol version %d is not supported", h.Version))
+	}
+
+	binary.Read(r, binary.LittleEndian, &h.Flags)
+	binary.Read(r, binary.LittleEndian, &h.ID)
+
+	commandbuffer := make([]byte, CommandLength)
+	r.Read(commandbuffer)
+
+	h.Command = BytesToCommand(commandbuffer)
+
+	binary.Read(r, binary.LittleEndian, &h.PayloadLength)
+	binary.Read(r, binary.LittleEndian, &h.ExtraLength)
+
+	h.Checksum = make([]byte, FrameChecksumLength)
+	r.Read(h.Checksum)
+
+	err := checkFrameSizes(h.Command, h.Flags, h.PayloadLength, h.ExtraLength)
+
+	if err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+// Sets flags and request ID in a built frame. Checksum doesn't depend on them, so the frame stays valid
+func SetFrameSessionData(data []byte, flags uint8, id uint32) error {
+	if len(data) < FrameHeaderLength {
+		return errors.New("Wrong length of frame")
+	}
+
+	data[frameFlagsOffset] = flags
+	binary.LittleEndian.PutUint32(data[frameFlagsOffset+1:], id)
+
+	return nil
+}
+
+// Returns command of a built frame
+func GetFrameCommand(data []byte) string {
+	if len(data) < FrameHeaderLength {
+		return ""
+	}
+	return BytesToCommand(data[frameFlagsOffset+5 : frameFlagsOffset+5+CommandLength])
+}
+
+// Returns length of extra data of a built frame. Extra data is auth string of management commands
+func GetFrameExtraLength(data []byte) uint32 {
+	offset := frameFlagsOffset + 5 + CommandLength + 4
+
+	if len(data) < FrameHeaderLength {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data[offset : offset+4])
+}
+
+func (h FrameHeader) IsSession() bool {
+	return h.Flags&FrameFlagSession > 0
+}
+
+func (h FrameHeader) IsResponse() bool {
+	return h.Flags&FrameFlagResponse > 0
+}
+
+// Checks that payload and extra data are same as were sent
+func (h FrameHeader) VerifyChecksum(payload []byte, extra []byte) error {
+	if bytes.Compare(h.Checksum, FrameChecksum(payload, extra)) != 0 {
+		return errors.New(fmt.Sprintf("Wrong checksum of %s command data", h.Command))
+	}
+	return nil
+}
+
+// First bytes of double sha256 of payload and extra data
+func FrameChecksum(payload []byte, extra []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write(payload)
+	hasher.Write(extra)
+
+	hash := sha256.Sum256(hasher.Sum(nil))
+
+	return hash[:FrameChecksumLength]
+}
+
+func checkFrameSizes(command string, flags uint8, payloadLength uint32, extraLength uint32) error {
+	limit := GetCommandPayloadLimit(command)
+
+	if flags&FrameFlagResponse > 0 {
+		// responses can be big for any command
+		limit = MaxPayloadSize + 1
+	}
+
+	if payloadLength > limit {
+		return errors.New(fmt.Sprintf("Payload of %s command is too big: %d bytes, limit is %d", command, payloadLength, limit))
+	}
+
+	if extraLength > MaxExtraDataSize {
+		return errors.New(fmt.Sprintf("Extra data of %s command is too big: %d bytes", command, extraLength))
+	}
+	return nil
+}
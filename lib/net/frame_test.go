@@ -0,0 +1,44 @@
+package net
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	payload := []byte("some payload")
+	extra := []byte("auth")
+
+	data, err := BuildFrame("version", payload, extra)
+
+	assert.NoError(t, err, "Frame not built")
+	assert.Equal(t, FrameHeaderLength+len(payload)+len(extra), len(data), "Wrong frame length")
+
+	header, err := ParseFrameHeader(data[:FrameHeaderLength])
+
+	assert.NoError(t, err, "Frame header not parsed")
+	assert.Equal(t, "version", header.Command, "Wrong command")
+	assert.Equal(t, uint32(len(payload)), header.PayloadLength, "Wrong payload length")
+	assert.Equal(t, uint32(len(extra)), header.ExtraLength, "Wrong extra length")
+	assert.Equal(t, uint32(len(extra)), GetFrameExtraLength(data), "Wrong extra length in built frame")
+
+	assert.NoError(t, header.VerifyChecksum(payload, extra), "Checksum must be correct")
+	assert.Error(t, header.VerifyChecksum([]byte("other payload"), extra), "Checksum of other data must be wrong")
+
+	// other network
+	data[0] ^= 0xFF
+
+	_, err = ParseFrameHeader(data[:FrameHeaderLength])
+
+	assert.Error(t, err, "Frame of other network must not be parsed")
+
+	// limits
+	_, err = BuildFrame("version", make([]byte, DefaultPayloadLimit+1), nil)
+
+	assert.Error(t, err, "Too big payload must not be accepted")
+
+	_, err = BuildFrame("block", make([]byte, DefaultPayloadLimit+1), nil)
+
+	assert.NoError(t, err, "Block can be bigger than default limit")
+}
@@ -5,59 +5,116 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
 
 const Protocol = "tcp"
-const NodeVersion = 1
+const NodeVersion = 5          // protocol version. it is sent in version command
+const MinNodeVersion = 2       // peers with older protocol are disconnected
+const HeadersSyncVersion = 3   // peers with this version return headers and full blocks on request, sync uses them
+const CompactBlocksVersion = 4 // peers with this version send compact blocks on request
+const MempoolVersion = 5       // peers with this version return IDs of transactions in the pool
 const CommandLength = 12
 const AuthStringLength = 20
 
+// Services that a node provides. Bitfield is sent in version command
+const ServiceFullNode = uint64(1)    // has full data of all blocks
+const ServicePruned = uint64(2)      // has full data only of blocks after pruned height
+const ServiceLiteServing = uint64(4) // answers requests of wallets: history, balance, new transactions
+
 // Represents a node address
 type NodeAddr struct {
 	Host string
 	Port int
 }
 
-// Convert to string in format host:port
+// Convert to string in format host:port. IPv6 address is in brackets, [::1]:20000
 func (n NodeAddr) NodeAddrToString() string {
-	return n.Host + ":" + strconv.Itoa(n.Port)
+	return net.JoinHostPort(strings.Trim(n.Host, " []"), strconv.Itoa(n.Port))
 }
 
 // Compare to other node address if is same
 func (n NodeAddr) CompareToAddress(addr NodeAddr) bool {
-	h1 := strings.Trim(addr.Host, " ")
-	h2 := strings.Trim(n.Host, " ")
+	return NormalizeHost(n.Host) == NormalizeHost(addr.Host) && addr.Port == n.Port
+}
+
+// Returns the address with normalized host. Same node can be known as localhost and 127.0.0.1
+// or with different forms of IPv6 address
+func (n NodeAddr) Normalized() NodeAddr {
+	return NodeAddr{Host: NormalizeHost(n.Host), Port: n.Port}
+}
+
+// Checks if other nodes can use the host to connect. Empty, loopback and "any" addresses are not usable
+func (n NodeAddr) HasExternalHost() bool {
+	host := NormalizeHost(n.Host)
 
-	if h1 == "localhost" {
-		h1 = "127.0.0.1"
+	if host == "" {
+		return false
 	}
-	if h2 == "localhost" {
-		h2 = "127.0.0.1"
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		// host name
+		return true
 	}
+	return !ip.IsLoopback() && !ip.IsUnspecified()
+}
 
-	return (h1 == h2 && addr.Port == n.Port)
+// Replaces the host with IP of a connection if the node doesn't know own external address.
+// Used for addresses that nodes send about themselves
+func (n NodeAddr) ResolveWithIP(ip string) NodeAddr {
+	if ip != "" && !n.HasExternalHost() {
+		n.Host = ip
+	}
+	return n
 }
 
-// Parse from string
+// Parse from string. IPv6 address must be in brackets, but old format without brackets
+// is accepted too, port is after the last colon then
 func (n *NodeAddr) LoadFromString(addr string) error {
-	parts := strings.SplitN(addr, ":", 2)
+	addr = strings.Trim(addr, " ")
+
+	host, port, err := net.SplitHostPort(addr)
 
-	if len(parts) < 2 {
-		return errors.New("Wrong address")
+	if err != nil {
+		i := strings.LastIndex(addr, ":")
+
+		if i < 0 {
+			return errors.New("Wrong address")
+		}
+		host = addr[:i]
+		port = addr[i+1:]
 	}
-	n.Host = parts[0]
 
-	port, err := strconv.Atoi(parts[1])
+	portNum, err := strconv.Atoi(port)
 
 	if err != nil {
 		return err
 	}
-	n.Port = port
+	n.Host = host
+	n.Port = portNum
 	return nil
 }
 
+// Returns host in one form. localhost is 127.0.0.1, IP address is in canonical form without brackets
+func NormalizeHost(host string) string {
+	host = strings.Trim(host, " []")
+
+	if host == "localhost" {
+		return "127.0.0.1"
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(host)
+}
+
 // Converts a command to bytes in fixed length
 func CommandToBytes(command string) []byte {
 	var bytes [CommandLength]byte
@@ -0,0 +1,35 @@
+package net
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNodeAddr(t *testing.T) {
+	addr := NodeAddr{}
+
+	assert.NoError(t, addr.LoadFromString("[2001:db8::1]:20000"), "IPv6 address must be parsed")
+	assert.Equal(t, NodeAddr{"2001:db8::1", 20000}, addr, "Wrong IPv6 address")
+	assert.Equal(t, "[2001:db8::1]:20000", addr.NodeAddrToString(), "IPv6 address must be in brackets")
+
+	// old format without brackets
+	assert.NoError(t, addr.LoadFromString("::1:20000"), "IPv6 address without brackets must be parsed")
+	assert.Equal(t, NodeAddr{"::1", 20000}, addr, "Wrong IPv6 address")
+
+	assert.NoError(t, addr.LoadFromString("example.com:20001"), "Host name must be parsed")
+	assert.Equal(t, NodeAddr{"example.com", 20001}, addr, "Wrong host address")
+	assert.Equal(t, "example.com:20001", addr.NodeAddrToString(), "Wrong host address string")
+
+	assert.Error(t, addr.LoadFromString("example.com"), "Port is required")
+
+	assert.True(t, NodeAddr{"localhost", 20000}.CompareToAddress(NodeAddr{"127.0.0.1", 20000}), "localhost is 127.0.0.1")
+	assert.True(t, NodeAddr{"[2001:DB8:0::1]", 20000}.CompareToAddress(NodeAddr{"2001:db8::1", 20000}), "Same IPv6 address")
+
+	assert.False(t, NodeAddr{"::1", 20000}.HasExternalHost(), "Loopback is not external")
+	assert.False(t, NodeAddr{"0.0.0.0", 20000}.HasExternalHost(), "Any address is not external")
+	assert.True(t, NodeAddr{"example.com", 20000}.HasExternalHost(), "Host name is external")
+
+	assert.Equal(t, NodeAddr{"10.0.0.1", 20000}, NodeAddr{"localhost", 20000}.ResolveWithIP("10.0.0.1"), "Local host must be replaced")
+	assert.Equal(t, NodeAddr{"10.0.0.2", 20000}, NodeAddr{"10.0.0.2", 20000}.ResolveWithIP("10.0.0.1"), "External host must stay")
+}
@@ -1,44 +1,93 @@
 package net
 
 import (
-	"encoding/hex"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-	"strings"
+	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/gelembjuk/democoin/lib"
 	"github.com/gelembjuk/democoin/lib/utils"
 )
 
+// Where an address came from. For addresses received in addr command it is a host of the node that sent it
+const NodeSourceSeed = "seed"     // loaded from the list of initial nodes
+const NodeSourceManual = "manual" // added with addnode. such nodes are never removed as stale
+const NodeSourceDirect = "direct" // the node connected to us or we connected to it
+const NodeSourceLAN = "lan"       // the node announced itself in local network
+
+// Max number of nodes to connect when a node server starts
+const MaxOutboundNodes = 8
+
+// Failed node is not used for this time multiplied by number of failures
+const nodeRetryDelay = 10 * 60
+
+// Max time between attempts to connect to a failed node
+const nodeMaxRetryDelay = 24 * 3600
+
+// Node that never was connected is removed after this number of failures
+const nodeMaxNewFailures = 3
+
+// Node that was connected before is removed after this number of failures if last success is older than nodeStaleTime
+const nodeMaxFailures = 10
+const nodeStaleTime = 7 * 24 * 3600
+
+// Address that was never tried is removed after this time
+const nodeNewMaxAge = 30 * 24 * 3600
+
+// Stats are saved in the storage not more often than this if nothing else changed
+const nodeStatsSaveInterval = 10 * 60
+
 // INterface for extra storage for a nodes.
-// TODO
-// This is not used yet
 type NodeNetworkStorage interface {
 	GetNodes() ([]NodeAddr, error)
 	AddNodeToKnown(addr NodeAddr)
 	RemoveNodeFromKnown(addr NodeAddr)
 	GetCountOfKnownNodes() (int, error)
+	GetNodeStats(addr NodeAddr) (*NodeStats, error) // nil if there are no stats
+	SaveNodeStats(stats NodeStats)
 }
 
 // This manages list of known nodes by a node
 type NodeNetwork struct {
-	Logger  *utils.LoggerMan
-	Nodes   []NodeAddr
-	Storage NodeNetworkStorage
-	lock    *sync.Mutex
+	Logger      *utils.LoggerMan
+	Nodes       []NodeAddr
+	Storage     NodeNetworkStorage
+	SeedSources []string       // URLs and files with lists of initial nodes
+	SeedList    *NodesListJSON // list of initial nodes from the config
+	Genesis     []byte         // genesis hash of our chain from the config. nil if it is not set
+	lock        *sync.Mutex
+	stats       *nodesStats
+}
+
+// Quality of a node address. Nodes to connect are picked from good nodes (connected before)
+// and new nodes (never connected). Dead addresses are removed
+type NodeStats struct {
+	Addr        NodeAddr
+	Source      string // seed, manual, direct or host of a node that sent the address. Empty for old records
+	Added       int64
+	LastSeen    int64 // last time we connected to the node or it connected to us
+	LastSuccess int64 // last successful connection
+	LastAttempt int64 // last failed connection
+	Failures    int   // failed connections after last success
+	saved       int64
 }
 
-type NodesListJSON struct {
-	Nodes   []NodeAddr
-	Genesis string
+// Stats of all nodes. Cloned node objects use same stats
+type nodesStats struct {
+	lock  *sync.Mutex
+	nodes map[string]*NodeStats
 }
 
 // Init nodes network object
 func (n *NodeNetwork) Init() {
 	n.lock = &sync.Mutex{}
+	n.stats = &nodesStats{&sync.Mutex{}, map[string]*NodeStats{}}
+}
+
+// Uses stats of nodes of other object. Stats of all node objects in the node server are same
+func (n *NodeNetwork) ShareStats(other *NodeNetwork) {
+	n.stats = other.stats
 }
 
 // Set extra storage for a nodes
@@ -87,61 +136,51 @@ func (n *NodeNetwork) SetNodes(nodes []NodeAddr, replace bool) {
 	}
 }
 
-// If n any known nodes then it will be loaded from the url on a host
-// Accepts genesis block hash. It will be compared to the hash in JSON doc
+// If n any known nodes then they are loaded from seed sources
+// Accepts genesis block hash. It will be compared to the hash in JSON doc of every source
 func (n *NodeNetwork) LoadInitialNodes(geenesisHash []byte) error {
-	timeout := time.Duration(2 * time.Second)
+	var err error
 
-	client := http.Client{Timeout: timeout}
+	loaded := false
 
-	response, err := client.Get(lib.InitialNodesList)
+	for _, result := range n.LoadSeeds(geenesisHash) {
+		if result.Error != "" {
+			if n.Logger != nil {
+				n.Logger.Trace.Printf("Nodes from %s are not loaded: %s", result.Source, result.Error)
+			}
 
-	if err != nil {
-		return err
-	}
+			if err == nil {
+				err = errors.New(fmt.Sprintf("%s: %s", result.Source, result.Error))
+			}
+			continue
+		}
 
-	jsondoc, err := ioutil.ReadAll(response.Body)
+		loaded = true
 
-	if err != nil {
-		return err
+		for _, node := range result.Nodes {
+			n.AddNodeToKnownFrom(node, NodeSourceSeed)
+		}
 	}
 
-	nodes := NodesListJSON{}
-
-	err = json.Unmarshal(jsondoc, &nodes)
-
-	if err != nil {
-		return err
+	if loaded {
+		return nil
 	}
+	return err
+}
 
-	if geenesisHash != nil && nodes.Genesis != "" {
-		gh := hex.EncodeToString(geenesisHash)
-
-		if gh != nodes.Genesis {
-			// don't add
-			return nil
-		}
-	}
+// Returns copy of the list of known nodes. The list can be changed by other routine
+func (n *NodeNetwork) GetNodes() []NodeAddr {
 	n.lock.Lock()
 	defer n.lock.Unlock()
-	n.Nodes = append(n.Nodes, nodes.Nodes...)
 
-	if n.Storage != nil {
-		// remember loaded nodes in local storage
-		for _, node := range nodes.Nodes {
-			node.Host = strings.Trim(node.Host, " ")
-			n.Storage.AddNodeToKnown(node)
-		}
-	}
-
-	return nil
-}
-func (n *NodeNetwork) GetNodes() []NodeAddr {
-	return n.Nodes
+	return append([]NodeAddr{}, n.Nodes...)
 }
 
 // Returns number of known nodes
 func (n *NodeNetwork) GetCountOfKnownNodes() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	l := len(n.Nodes)
 
 	return l
@@ -149,6 +188,9 @@ func (n *NodeNetwork) GetCountOfKnownNodes() int {
 
 // Check if node address is known
 func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	exists := false
 
 	for _, node := range n.Nodes {
@@ -166,8 +208,21 @@ func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
 * Returns true if was added
  */
 func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
+	return n.AddNodeToKnownFrom(addr, NodeSourceDirect)
+}
+
+// Adds a node if it is not known yet. Source is remembered for new nodes. Manual source replaces any other
+func (n *NodeNetwork) AddNodeToKnownFrom(addr NodeAddr, source string) bool {
+	added := n.addNodeToKnown(addr)
+
+	n.setNodeSource(addr, source, source == NodeSourceManual)
+
+	return added
+}
+
+// The storage is updated after the list lock is released. Readers of the list don't wait for the DB
+func (n *NodeNetwork) addNodeToKnown(addr NodeAddr) bool {
 	n.lock.Lock()
-	defer n.lock.Unlock()
 
 	exists := false
 
@@ -181,6 +236,8 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 		n.Nodes = append(n.Nodes, addr)
 	}
 
+	n.lock.Unlock()
+
 	if n.Storage != nil {
 		n.Storage.AddNodeToKnown(addr)
 	}
@@ -191,7 +248,6 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 // Removes a node from known
 func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 	n.lock.Lock()
-	defer n.lock.Unlock()
 
 	updatedlist := []NodeAddr{}
 
@@ -203,7 +259,344 @@ func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 
 	n.Nodes = updatedlist
 
+	n.lock.Unlock()
+
 	if n.Storage != nil {
 		n.Storage.RemoveNodeFromKnown(addr)
 	}
+
+	if n.stats != nil {
+		n.stats.lock.Lock()
+		delete(n.stats.nodes, getNodeStatsKey(addr))
+		n.stats.lock.Unlock()
+	}
+}
+
+// Remembers successful connection to a node or from it
+func (n *NodeNetwork) MarkNodeSuccess(addr NodeAddr, outbound bool) {
+	if n.stats == nil || !n.CheckIsKnown(addr) {
+		return
+	}
+
+	n.loadStats([]NodeAddr{addr})
+
+	n.stats.lock.Lock()
+
+	stats := n.getStats(addr)
+
+	now := time.Now().Unix()
+	changed := stats.Failures > 0
+
+	stats.LastSeen = now
+	stats.Failures = 0
+
+	if outbound {
+		changed = changed || stats.LastSuccess == 0
+		stats.LastSuccess = now
+	}
+
+	if !changed && stats.saved+nodeStatsSaveInterval >= now {
+		n.stats.lock.Unlock()
+		return
+	}
+
+	toSave := stats.copyToSave()
+
+	n.stats.lock.Unlock()
+
+	n.saveStats(toSave)
+}
+
+// Remembers failed connection to a node. The node is removed if it is stale now
+func (n *NodeNetwork) MarkNodeFailure(addr NodeAddr) {
+	if n.stats == nil || !n.CheckIsKnown(addr) {
+		return
+	}
+
+	n.loadStats([]NodeAddr{addr})
+
+	n.stats.lock.Lock()
+
+	stats := n.getStats(addr)
+
+	stats.LastAttempt = time.Now().Unix()
+	stats.Failures++
+
+	toSave := stats.copyToSave()
+
+	n.stats.lock.Unlock()
+
+	if toSave.isStale(toSave.LastAttempt) {
+		if n.Logger != nil {
+			n.Logger.Trace.Printf("Node %s is removed after %d failures", addr.NodeAddrToString(), toSave.Failures)
+		}
+		n.RemoveNodeFromKnown(addr)
+		return
+	}
+
+	n.saveStats(toSave)
+}
+
+// Returns nodes to connect. Good nodes (connected before) and new nodes are taken in turn,
+// nodes that failed recently are skipped. All available nodes are returned if max is 0
+func (n *NodeNetwork) GetNodesToConnect(max int) []NodeAddr {
+	nodes := n.GetNodes()
+
+	n.loadStats(nodes)
+
+	n.stats.lock.Lock()
+	defer n.stats.lock.Unlock()
+
+	now := time.Now().Unix()
+
+	good := []NodeAddr{}
+	fresh := []NodeAddr{}
+
+	for _, node := range nodes {
+		stats := n.getStats(node)
+
+		if stats.Failures > 0 && stats.LastAttempt+stats.retryDelay() > now {
+			continue
+		}
+
+		if stats.LastSuccess > 0 {
+			good = append(good, node)
+		} else {
+			fresh = append(fresh, node)
+		}
+	}
+
+	rand.Shuffle(len(good), func(i, j int) { good[i], good[j] = good[j], good[i] })
+	rand.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+
+	list := []NodeAddr{}
+
+	for len(good) > 0 || len(fresh) > 0 {
+		if max > 0 && len(list) >= max {
+			break
+		}
+
+		if len(good) > 0 && (len(list)%2 == 0 || len(fresh) == 0) {
+			list = append(list, good[0])
+			good = good[1:]
+		} else {
+			list = append(list, fresh[0])
+			fresh = fresh[1:]
+		}
+	}
+
+	return list
+}
+
+// Returns stats of all known nodes
+func (n *NodeNetwork) GetNodesStats() []NodeStats {
+	nodes := n.GetNodes()
+
+	n.loadStats(nodes)
+
+	n.stats.lock.Lock()
+	defer n.stats.lock.Unlock()
+
+	list := []NodeStats{}
+
+	for _, node := range nodes {
+		list = append(list, *n.getStats(node))
+	}
+	return list
+}
+
+// Removes nodes that failed too many times or were never tried for long time
+func (n *NodeNetwork) RemoveStaleNodes() []NodeAddr {
+	nodes := n.GetNodes()
+
+	n.loadStats(nodes)
+
+	n.stats.lock.Lock()
+
+	now := time.Now().Unix()
+
+	stale := []NodeAddr{}
+
+	for _, node := range nodes {
+		if n.getStats(node).isStale(now) {
+			stale = append(stale, node)
+		}
+	}
+
+	n.stats.lock.Unlock()
+
+	for _, node := range stale {
+		n.RemoveNodeFromKnown(node)
+	}
+
+	return stale
+}
+
+// Sets source of a known node
+func (n *NodeNetwork) SetNodeSource(addr NodeAddr, source string) {
+	n.setNodeSource(addr, source, true)
+}
+
+// Sets source of a node if it is not known yet or if replace is true
+func (n *NodeNetwork) setNodeSource(addr NodeAddr, source string, replace bool) {
+	if n.stats == nil {
+		// stats are not used by wallets
+		return
+	}
+
+	n.loadStats([]NodeAddr{addr})
+
+	n.stats.lock.Lock()
+
+	stats := n.getStats(addr)
+
+	if (stats.Source != "" && !replace) || stats.Source == source {
+		n.stats.lock.Unlock()
+		return
+	}
+
+	stats.Source = source
+
+	if source == NodeSourceDirect {
+		stats.LastSeen = time.Now().Unix()
+	}
+
+	toSave := stats.copyToSave()
+
+	n.stats.lock.Unlock()
+
+	n.saveStats(toSave)
+}
+
+// Loads stats of nodes that are not in memory yet. The storage is used without the stats lock,
+// so other routines don't wait for the DB. Stats of new nodes are saved, time of adding is needed
+// to remove addresses that are never tried
+func (n *NodeNetwork) loadStats(nodes []NodeAddr) {
+	n.stats.lock.Lock()
+
+	missed := []NodeAddr{}
+
+	for _, node := range nodes {
+		if _, ok := n.stats.nodes[getNodeStatsKey(node)]; !ok {
+			missed = append(missed, node)
+		}
+	}
+
+	n.stats.lock.Unlock()
+
+	if len(missed) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+
+	loaded := []*NodeStats{}
+	created := []*NodeStats{}
+
+	for _, node := range missed {
+		var stats *NodeStats
+
+		if n.Storage != nil {
+			stats, _ = n.Storage.GetNodeStats(node)
+		}
+
+		if stats != nil {
+			stats.saved = now
+			loaded = append(loaded, stats)
+			continue
+		}
+
+		stats = &NodeStats{}
+		stats.Addr = node
+		stats.Added = now
+
+		created = append(created, stats)
+	}
+
+	toSave := []NodeStats{}
+
+	n.stats.lock.Lock()
+
+	for _, stats := range append(loaded, created...) {
+		key := getNodeStatsKey(stats.Addr)
+
+		if _, ok := n.stats.nodes[key]; ok {
+			// other routine loaded it already
+			continue
+		}
+		n.stats.nodes[key] = stats
+	}
+
+	for _, stats := range created {
+		if n.stats.nodes[getNodeStatsKey(stats.Addr)] == stats {
+			toSave = append(toSave, stats.copyToSave())
+		}
+	}
+
+	n.stats.lock.Unlock()
+
+	for _, stats := range toSave {
+		n.saveStats(stats)
+	}
+}
+
+// Returns stats of a node from memory. Stats must be loaded with loadStats before. Must be called with the stats lock
+func (n *NodeNetwork) getStats(addr NodeAddr) *NodeStats {
+	key := getNodeStatsKey(addr)
+
+	if stats, ok := n.stats.nodes[key]; ok {
+		return stats
+	}
+
+	// the node was removed by other routine after stats were loaded
+	stats := &NodeStats{}
+	stats.Addr = addr
+	stats.Added = time.Now().Unix()
+
+	n.stats.nodes[key] = stats
+
+	return stats
+}
+
+// Saves stats in the storage. Must be called without the stats lock
+func (n *NodeNetwork) saveStats(stats NodeStats) {
+	if n.Storage != nil {
+		n.Storage.SaveNodeStats(stats)
+	}
+}
+
+// Marks stats as saved and returns copy of them to save. Must be called with the stats lock
+func (s *NodeStats) copyToSave() NodeStats {
+	s.saved = time.Now().Unix()
+
+	return *s
+}
+
+// Time to wait before next attempt to connect to failed node
+func (s *NodeStats) retryDelay() int64 {
+	delay := int64(s.Failures) * nodeRetryDelay
+
+	if delay > nodeMaxRetryDelay {
+		delay = nodeMaxRetryDelay
+	}
+	return delay
+}
+
+// Checks if a node must be removed. Manually added nodes are never removed
+func (s *NodeStats) isStale(now int64) bool {
+	if s.Source == NodeSourceManual {
+		return false
+	}
+
+	if s.LastSuccess == 0 {
+		return s.Failures >= nodeMaxNewFailures ||
+			s.LastSeen == 0 && s.Failures == 0 && s.Added+nodeNewMaxAge < now
+	}
+
+	return s.Failures >= nodeMaxFailures && s.LastSuccess+nodeStaleTime < now
+}
+
+// localhost and 127.0.0.1 are same node
+func getNodeStatsKey(addr NodeAddr) string {
+	return addr.Normalized().NodeAddrToString()
 }
@@ -0,0 +1,49 @@
+package net
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNodesStats(t *testing.T) {
+	n := NodeNetwork{}
+	n.Init()
+
+	good := NodeAddr{"10.0.0.1", 20000}
+	fresh := NodeAddr{"10.0.0.2", 20000}
+	manual := NodeAddr{"10.0.0.3", 20000}
+
+	assert.True(t, n.AddNodeToKnownFrom(good, "10.0.0.9"), "Node must be added")
+	assert.True(t, n.AddNodeToKnownFrom(fresh, "10.0.0.9"), "Node must be added")
+	assert.True(t, n.AddNodeToKnownFrom(manual, NodeSourceManual), "Node must be added")
+	assert.False(t, n.AddNodeToKnownFrom(good, NodeSourceSeed), "Node is known already")
+
+	n.MarkNodeSuccess(good, true)
+
+	list := n.GetNodesToConnect(1)
+
+	assert.Equal(t, []NodeAddr{good}, list, "Good node must be first")
+
+	for _, st := range n.GetNodesStats() {
+		if st.Addr == good {
+			assert.Equal(t, "10.0.0.9", st.Source, "Source must not be changed")
+			assert.True(t, st.LastSuccess > 0, "Success is not saved")
+		}
+	}
+
+	// failed node is not used for some time
+	n.MarkNodeFailure(good)
+
+	assert.Equal(t, 2, len(n.GetNodesToConnect(0)), "Failed node must be skipped")
+	assert.True(t, n.CheckIsKnown(good), "Node connected before must not be removed after one failure")
+
+	// new node is removed after few failures
+	for i := 0; i < nodeMaxNewFailures; i++ {
+		n.MarkNodeFailure(fresh)
+		n.MarkNodeFailure(manual)
+	}
+
+	assert.False(t, n.CheckIsKnown(fresh), "Failed new node must be removed")
+	assert.True(t, n.CheckIsKnown(manual), "Manual node must not be removed")
+}
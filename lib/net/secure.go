@@ -0,0 +1,534 @@
+package net
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Encrypted connection starts with the handshake instead of a frame. Every side sends hello:
+// magic (4 bytes), version (1 byte), ephemeral ECDH key (65 bytes), node identity key (64 bytes).
+// Server signs both hellos with its identity key, client sends own signature in the first encrypted record.
+// After this all data go in records: length (4 bytes) and AES-GCM sealed data. Frames are sent inside records
+const SecureMagic = uint32(0xD3C05EC1)
+const SecureVersion = 1
+const SecureHandshakeTimeout = 10 * time.Second
+
+// Identity key of a node is saved in this file in the data directory
+const NodeKeyFileName = "nodekey.dat"
+
+// P256 key. X and Y, 32 bytes each
+const NodeKeyLength = 64
+
+const ephemeralKeyLength = 65
+const secureHelloLength = 4 + 1 + ephemeralKeyLength + NodeKeyLength
+const maxSignatureLength = 128
+
+// Data are split to records of this size
+const maxRecordSize = 64 * 1024
+
+// Identity key of a node. Public key is sent in the handshake, other nodes pin it
+type NodeKey struct {
+	PrivateKey *ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+// Connection with encrypted data. RemoteKey is identity key of other side, it is checked in the handshake
+type SecureConn struct {
+	net.Conn
+	RemoteKey []byte
+
+	reader     cipher.AEAD
+	writer     cipher.AEAD
+	readCount  uint64
+	writeCount uint64
+	readBuffer []byte
+	readLock   *sync.Mutex
+	writeLock  *sync.Mutex
+}
+
+// Connection with first bytes already read. They are returned first
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+// Generates new identity key. Lite clients use a new key for every run
+func NewNodeKey() (*NodeKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return makeNodeKey(private), nil
+}
+
+// Loads identity key of a node from the data directory. New key is created if there is no the file yet
+func LoadNodeKey(dataDir string) (*NodeKey, error) {
+	filepath := dataDir + NodeKeyFileName
+
+	data, err := ioutil.ReadFile(filepath)
+
+	if os.IsNotExist(err) {
+		key, err := NewNodeKey()
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = x509.MarshalECPrivateKey(key.PrivateKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(filepath, data, 0600)
+
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := x509.ParseECPrivateKey(data)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Node key file %s is broken: %s", filepath, err.Error()))
+	}
+
+	return makeNodeKey(private), nil
+}
+
+func makeNodeKey(private *ecdsa.PrivateKey) *NodeKey {
+	key := &NodeKey{}
+	key.PrivateKey = private
+	key.PublicKey = make([]byte, NodeKeyLength)
+
+	private.PublicKey.X.FillBytes(key.PublicKey[:NodeKeyLength/2])
+	private.PublicKey.Y.FillBytes(key.PublicKey[NodeKeyLength/2:])
+
+	return key
+}
+
+// Short form of a public key to display
+func KeyFingerprint(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+
+	return hex.EncodeToString(hash[:8])
+}
+
+// Parses identity key of other side and checks it is a point on the curve
+func parseNodePublicKey(publicKey []byte) (*ecdsa.PublicKey, error) {
+	if len(publicKey) != NodeKeyLength {
+		return nil, errors.New("Wrong length of node key")
+	}
+
+	// this checks that the point is on the curve
+	_, err := ecdh.P256().NewPublicKey(append([]byte{4}, publicKey...))
+
+	if err != nil {
+		return nil, errors.New("Wrong node key: " + err.Error())
+	}
+
+	key := &ecdsa.PublicKey{Curve: elliptic.P256()}
+	key.X = new(big.Int).SetBytes(publicKey[:NodeKeyLength/2])
+	key.Y = new(big.Int).SetBytes(publicKey[NodeKeyLength/2:])
+
+	return key, nil
+}
+
+// Does the handshake as a client. Identity key of the node is in RemoteKey of returned connection,
+// caller must check it if the node is known
+func SecureClient(conn net.Conn, key *NodeKey) (*SecureConn, error) {
+	conn.SetDeadline(time.Now().Add(SecureHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	myHello := buildSecureHello(ephemeral, key)
+
+	_, err = conn.Write(myHello)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// hello of the server and its signature
+	serverHello := make([]byte, secureHelloLength)
+
+	_, err = io.ReadFull(conn, serverHello)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := readSignature(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transcript := append(utils.CopyBytes(myHello), serverHello...)
+
+	secure, err := newSecureConn(conn, ephemeral, serverHello, transcript, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyTranscript(secure.RemoteKey, "server", transcript, signature) {
+		return nil, errors.New("Wrong signature of the node in handshake")
+	}
+
+	mySignature, err := signTranscript(key, "client", transcript)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = secure.Write(mySignature)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secure, nil
+}
+
+// Checks first bytes of a connection accepted by a server. If it is the handshake then it is done and
+// encrypted connection is returned. Other connections are returned as is, it can be a plain frame
+func SecureServer(conn net.Conn, key *NodeKey) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(SecureHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	magic := make([]byte, 4)
+
+	_, err := io.ReadFull(conn, magic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(magic) != SecureMagic {
+		return &prefixedConn{conn, magic}, nil
+	}
+
+	clientHello := make([]byte, secureHelloLength)
+	copy(clientHello, magic)
+
+	_, err = io.ReadFull(conn, clientHello[4:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	myHello := buildSecureHello(ephemeral, key)
+
+	transcript := append(utils.CopyBytes(clientHello), myHello...)
+
+	secure, err := newSecureConn(conn, ephemeral, clientHello, transcript, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signTranscript(key, "server", transcript)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+	buff.Write(myHello)
+	buff.WriteByte(byte(len(signature)))
+	buff.Write(signature)
+
+	_, err = conn.Write(buff.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// client proves own key in the first record
+	clientSignature := make([]byte, maxSignatureLength)
+
+	n, err := secure.Read(clientSignature)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyTranscript(secure.RemoteKey, "client", transcript, clientSignature[:n]) {
+		return nil, errors.New("Wrong signature of the client in handshake")
+	}
+
+	return secure, nil
+}
+
+// Returns identity key of other side if the connection is encrypted. Nil for plain connections
+func GetConnectionKey(conn net.Conn) []byte {
+	if secure, ok := conn.(*SecureConn); ok {
+		return secure.RemoteKey
+	}
+	return nil
+}
+
+func buildSecureHello(ephemeral *ecdh.PrivateKey, key *NodeKey) []byte {
+	var buff bytes.Buffer
+
+	binary.Write(&buff, binary.LittleEndian, SecureMagic)
+	buff.WriteByte(SecureVersion)
+	buff.Write(ephemeral.PublicKey().Bytes())
+	buff.Write(key.PublicKey)
+
+	return buff.Bytes()
+}
+
+// Parses hello of other side and makes ciphers for both directions from the shared secret
+func newSecureConn(conn net.Conn, ephemeral *ecdh.PrivateKey, remoteHello []byte, transcript []byte, isClient bool) (*SecureConn, error) {
+	if binary.LittleEndian.Uint32(remoteHello[:4]) != SecureMagic {
+		return nil, errors.New("Wrong magic of secure handshake")
+	}
+
+	if remoteHello[4] != SecureVersion {
+		return nil, errors.New(fmt.Sprintf("Secure handshake version %d is not supported", remoteHello[4]))
+	}
+
+	remoteEphemeral, err := ecdh.P256().NewPublicKey(remoteHello[5 : 5+ephemeralKeyLength])
+
+	if err != nil {
+		return nil, err
+	}
+
+	remoteKey := utils.CopyBytes(remoteHello[5+ephemeralKeyLength:])
+
+	_, err = parseNodePublicKey(remoteKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(remoteEphemeral)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	hasher.Write(secret)
+	hasher.Write(transcript)
+	master := hasher.Sum(nil)
+
+	clientToServer, err := newRecordCipher(master, "client")
+
+	if err != nil {
+		return nil, err
+	}
+
+	serverToClient, err := newRecordCipher(master, "server")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &SecureConn{}
+	c.Conn = conn
+	c.RemoteKey = remoteKey
+	c.readLock = &sync.Mutex{}
+	c.writeLock = &sync.Mutex{}
+
+	if isClient {
+		c.reader = serverToClient
+		c.writer = clientToServer
+	} else {
+		c.reader = clientToServer
+		c.writer = serverToClient
+	}
+	return c, nil
+}
+
+func newRecordCipher(master []byte, direction string) (cipher.AEAD, error) {
+	key := sha256.Sum256(append(utils.CopyBytes(master), []byte(direction)...))
+
+	block, err := aes.NewCipher(key[:])
+
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func signTranscript(key *NodeKey, role string, transcript []byte) ([]byte, error) {
+	hash := sha256.Sum256(append([]byte(role), transcript...))
+
+	return ecdsa.SignASN1(rand.Reader, key.PrivateKey, hash[:])
+}
+
+func verifyTranscript(publicKey []byte, role string, transcript []byte, signature []byte) bool {
+	key, err := parseNodePublicKey(publicKey)
+
+	if err != nil {
+		return false
+	}
+
+	hash := sha256.Sum256(append([]byte(role), transcript...))
+
+	return ecdsa.VerifyASN1(key, hash[:], signature)
+}
+
+func readSignature(conn net.Conn) ([]byte, error) {
+	length := make([]byte, 1)
+
+	_, err := io.ReadFull(conn, length)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature := make([]byte, int(length[0]))
+
+	_, err = io.ReadFull(conn, signature)
+
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// Nonce is a counter of records. It is never reused with same key
+func recordNonce(counter uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], counter)
+
+	return nonce
+}
+
+// Encrypts data and sends them in records
+func (c *SecureConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	written := 0
+
+	for written < len(b) {
+		end := written + maxRecordSize
+
+		if end > len(b) {
+			end = len(b)
+		}
+
+		sealed := c.writer.Seal(nil, recordNonce(c.writeCount, c.writer.NonceSize()), b[written:end], nil)
+		c.writeCount++
+
+		record := make([]byte, 4, 4+len(sealed))
+		binary.LittleEndian.PutUint32(record, uint32(len(sealed)))
+		record = append(record, sealed...)
+
+		_, err := c.Conn.Write(record)
+
+		if err != nil {
+			return written, err
+		}
+
+		written = end
+	}
+	return written, nil
+}
+
+// Reads next record if previous one is fully read and returns decrypted data
+func (c *SecureConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for len(c.readBuffer) == 0 {
+		err := c.readRecord()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.readBuffer)
+	c.readBuffer = c.readBuffer[n:]
+
+	return n, nil
+}
+
+func (c *SecureConn) readRecord() error {
+	header := make([]byte, 4)
+
+	_, err := io.ReadFull(c.Conn, header)
+
+	if err != nil {
+		// EOF between records is normal end of data
+		return err
+	}
+
+	length := binary.LittleEndian.Uint32(header)
+
+	if length > uint32(maxRecordSize+c.reader.Overhead()) {
+		return errors.New(fmt.Sprintf("Encrypted record is too big: %d bytes", length))
+	}
+
+	sealed := make([]byte, length)
+
+	_, err = io.ReadFull(c.Conn, sealed)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := c.reader.Open(nil, recordNonce(c.readCount, c.reader.NonceSize()), sealed, nil)
+
+	if err != nil {
+		return errors.New("Encrypted record can not be decrypted: " + err.Error())
+	}
+
+	c.readCount++
+	c.readBuffer = data
+
+	return nil
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
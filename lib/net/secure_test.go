@@ -0,0 +1,94 @@
+package net
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSecureConnection(t *testing.T) {
+	serverKey, err := NewNodeKey()
+
+	assert.NoError(t, err, "Server key not created")
+
+	clientKey, err := NewNodeKey()
+
+	assert.NoError(t, err, "Client key not created")
+
+	clientConn, serverConn := net.Pipe()
+
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// big data go in many records
+	data := make([]byte, 3*maxRecordSize+100)
+
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	result := make(chan []byte, 1)
+	remoteKey := make(chan []byte, 1)
+
+	go func() {
+		conn, err := SecureServer(serverConn, serverKey)
+
+		if err != nil {
+			result <- nil
+			return
+		}
+		remoteKey <- GetConnectionKey(conn)
+
+		received := make([]byte, len(data))
+
+		_, err = io.ReadFull(conn, received)
+
+		if err != nil {
+			result <- nil
+			return
+		}
+		result <- received
+	}()
+
+	conn, err := SecureClient(clientConn, clientKey)
+
+	assert.NoError(t, err, "Handshake failed")
+	assert.Equal(t, serverKey.PublicKey, conn.RemoteKey, "Wrong key of server")
+	assert.Equal(t, clientKey.PublicKey, <-remoteKey, "Wrong key of client")
+
+	_, err = conn.Write(data)
+
+	assert.NoError(t, err, "Data not sent")
+	assert.Equal(t, data, <-result, "Wrong data received")
+}
+
+func TestSecureServerPlainConnection(t *testing.T) {
+	serverKey, err := NewNodeKey()
+
+	assert.NoError(t, err, "Server key not created")
+
+	clientConn, serverConn := net.Pipe()
+
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	frame, err := BuildFrame("getnodes", nil, nil)
+
+	assert.NoError(t, err, "Frame not built")
+
+	go clientConn.Write(frame)
+
+	conn, err := SecureServer(serverConn, serverKey)
+
+	assert.NoError(t, err, "Plain connection must be accepted")
+	assert.Nil(t, GetConnectionKey(conn), "Plain connection has no key")
+
+	received := make([]byte, len(frame))
+
+	_, err = io.ReadFull(conn, received)
+
+	assert.NoError(t, err, "Frame not read")
+	assert.Equal(t, frame, received, "Frame must be same")
+}
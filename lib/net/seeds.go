@@ -0,0 +1,163 @@
+package net
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gelembjuk/democoin/lib"
+)
+
+// Source name of the list of initial nodes from the config
+const SeedSourceConfig = "config"
+
+// Time to load a list of initial nodes from URL
+const seedLoadTimeout = 2 * time.Second
+
+// List of initial nodes. Genesis is hash of genesis block of the chain of these nodes
+type NodesListJSON struct {
+	Nodes   []NodeAddr
+	Genesis string
+}
+
+// Result of loading of initial nodes from a source
+type SeedSourceResult struct {
+	Source  string // URL, path to a file or config
+	Genesis string
+	Nodes   []NodeAddr
+	Error   string // reason why the list is not used. Empty if the list is good
+}
+
+// Sets where lists of initial nodes are loaded from. Sources are URLs or paths to local JSON files.
+// Default URL is used if there are no sources and no list from the config.
+// Genesis of the list from the config is the genesis of our chain
+func (n *NodeNetwork) SetSeeds(sources []string, list *NodesListJSON) {
+	n.SeedSources = sources
+	n.Genesis = nil
+
+	if list != nil && list.Genesis != "" {
+		genesis, err := hex.DecodeString(list.Genesis)
+
+		if err == nil {
+			n.Genesis = genesis
+		}
+	}
+
+	if list != nil && len(list.Nodes) > 0 {
+		n.SeedList = list
+	} else {
+		n.SeedList = nil
+	}
+}
+
+// Loads lists of initial nodes from all sources. Nodes are not added to known nodes.
+// List is not used if it has no genesis hash or other genesis hash. If there is no chain yet (genesisHash is nil)
+// then lists are compared to the genesis from the config
+func (n *NodeNetwork) LoadSeeds(genesisHash []byte) []SeedSourceResult {
+	results := []SeedSourceResult{}
+
+	if genesisHash == nil {
+		genesisHash = n.Genesis
+	}
+
+	if n.SeedList != nil {
+		results = append(results, checkSeedList(SeedSourceConfig, *n.SeedList, nil, genesisHash))
+	}
+
+	sources := n.SeedSources
+
+	if len(sources) == 0 && n.SeedList == nil {
+		sources = []string{lib.InitialNodesList}
+	}
+
+	for _, source := range sources {
+		list, err := readSeedSource(source)
+
+		results = append(results, checkSeedList(source, list, err, genesisHash))
+	}
+
+	return results
+}
+
+// Reads list of nodes from URL or local file
+func readSeedSource(source string) (NodesListJSON, error) {
+	list := NodesListJSON{}
+
+	var jsondoc []byte
+	var err error
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := http.Client{Timeout: seedLoadTimeout}
+
+		var response *http.Response
+
+		response, err = client.Get(source)
+
+		if err != nil {
+			return list, err
+		}
+
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return list, errors.New(fmt.Sprintf("Response status %s", response.Status))
+		}
+
+		jsondoc, err = ioutil.ReadAll(response.Body)
+	} else {
+		jsondoc, err = ioutil.ReadFile(strings.TrimPrefix(source, "file://"))
+	}
+
+	if err != nil {
+		return list, err
+	}
+
+	err = json.Unmarshal(jsondoc, &list)
+
+	return list, err
+}
+
+// Checks genesis hash of a list and addresses in it
+func checkSeedList(source string, list NodesListJSON, err error, genesisHash []byte) SeedSourceResult {
+	result := SeedSourceResult{}
+	result.Source = source
+	result.Genesis = list.Genesis
+	result.Nodes = []NodeAddr{}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if list.Genesis == "" {
+		// we can not know what chain these nodes have
+		result.Error = "Genesis hash is not set in the list"
+		return result
+	}
+
+	if genesisHash != nil && strings.ToLower(list.Genesis) != hex.EncodeToString(genesisHash) {
+		result.Error = fmt.Sprintf("Genesis hash %s is different from ours %x", list.Genesis, genesisHash)
+		return result
+	}
+
+	for _, node := range list.Nodes {
+		node.Host = strings.Trim(node.Host, " ")
+
+		if node.Host == "" || node.Port < 1 || node.Port > 65535 {
+			// wrong address is skipped. other nodes are good
+			continue
+		}
+		result.Nodes = append(result.Nodes, node)
+	}
+
+	if len(result.Nodes) == 0 {
+		result.Error = "No nodes in the list"
+	}
+
+	return result
+}
@@ -0,0 +1,57 @@
+package net
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSeedSources(t *testing.T) {
+	file, err := ioutil.TempFile("", "seeds")
+
+	assert.NoError(t, err, "Can not create file")
+
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{"Nodes":[{"Host":" 10.0.0.1 ","Port":20000},{"Host":"","Port":20000}],"Genesis":"00ff"}`)
+
+	assert.NoError(t, err, "Can not write file")
+
+	file.Close()
+
+	n := NodeNetwork{}
+	n.Init()
+	n.SetSeeds([]string{file.Name(), file.Name() + ".missed"}, &NodesListJSON{[]NodeAddr{{"10.0.0.2", 20000}}, "00aa"})
+
+	results := n.LoadSeeds([]byte{0x00, 0xff})
+
+	assert.Equal(t, 3, len(results), "Wrong number of sources")
+
+	assert.Equal(t, SeedSourceConfig, results[0].Source, "Config list must be first")
+	assert.NotEmpty(t, results[0].Error, "List with other genesis must not be used")
+
+	assert.Equal(t, "", results[1].Error, "List from file must be used")
+	assert.Equal(t, []NodeAddr{{"10.0.0.1", 20000}}, results[1].Nodes, "Wrong nodes from file")
+
+	assert.NotEmpty(t, results[2].Error, "Missed file must be an error")
+
+	err = n.LoadInitialNodes([]byte{0x00, 0xff})
+
+	assert.NoError(t, err, "Nodes must be loaded from the file")
+	assert.Equal(t, []NodeAddr{{"10.0.0.1", 20000}}, n.GetNodes(), "Wrong known nodes")
+
+	// without a chain the genesis from the config is used
+	results = n.LoadSeeds(nil)
+
+	assert.Equal(t, "", results[0].Error, "Config list must be used")
+	assert.NotEmpty(t, results[1].Error, "List with other genesis must not be used")
+
+	n.SetSeeds([]string{}, &NodesListJSON{[]NodeAddr{{"10.0.0.2", 20000}}, ""})
+
+	results = n.LoadSeeds([]byte{0x00, 0xff})
+
+	assert.NotEmpty(t, results[0].Error, "List without genesis must not be used")
+	assert.Equal(t, 0, len(results[0].Nodes), "Nodes of list without genesis must not be returned")
+}
@@ -5,7 +5,6 @@ package nodeclient
 
 import (
 	"bytes"
-	"encoding/binary"
 	"time"
 
 	"encoding/gob"
@@ -15,6 +14,7 @@ import (
 	"io/ioutil"
 	"net"
 
+	"github.com/gelembjuk/democoin/lib"
 	netlib "github.com/gelembjuk/democoin/lib/net"
 	"github.com/gelembjuk/democoin/lib/utils"
 )
@@ -26,6 +26,16 @@ type NodeClient struct {
 	Logger      *utils.LoggerMan
 	NodeNet     *netlib.NodeNetwork
 	NodeAuthStr string
+	Transport   PeerTransport
+	Encrypt     bool            // new connections are encrypted
+	NodeKey     *netlib.NodeKey // identity key. It is loaded from DataDir or generated if DataDir is not set
+}
+
+// Keeps opened connections to other nodes. If it is set for a client then requests go through it
+// instead of new connection for every request. Node server sets it
+type PeerTransport interface {
+	Send(addr netlib.NodeAddr, data []byte) error
+	SendWaitResponse(addr netlib.NodeAddr, data []byte) ([]byte, error)
 }
 
 type ComBlock struct {
@@ -33,6 +43,36 @@ type ComBlock struct {
 	Block    []byte
 }
 
+// Block with short IDs of transactions instead of transactions. Receiver finds them in own pool
+type ComCompactBlock struct {
+	AddrFrom  netlib.NodeAddr
+	Header    []byte   // serialised BlockHeader
+	ShortIDs  [][]byte // short ID of every transaction of the block
+	Prefilled [][]byte // serialised transaction if receiver can not have it in the pool, like coinbase. Empty for others
+}
+
+// Request for transactions of a block by indexes. Receiver of compact block requests transactions it doesn't have
+type ComGetBlockTxs struct {
+	AddrFrom netlib.NodeAddr
+	Hash     []byte
+	Indexes  []int
+}
+
+// Response with serialised transactions in order of requested indexes
+type ComBlockTxs struct {
+	Transactions [][]byte
+}
+
+// Request for IDs of transactions in the pool of unapproved transactions
+type ComMempool struct {
+	AddrFrom netlib.NodeAddr
+}
+
+// Response with IDs of transactions in the pool. Oldest first
+type ComMempoolData struct {
+	Transactions [][]byte
+}
+
 // this struct can be used for 2 commands. to get blocks starting from some block to down or to up
 type ComGetBlocks struct {
 	AddrFrom  netlib.NodeAddr
@@ -46,6 +86,28 @@ type ComGetFirstBlocksData struct {
 	Height int
 }
 
+// Request for headers after the first known block from the locator. Locator is a list of hashes from our chain, top first
+type ComGetHeaders struct {
+	AddrFrom netlib.NodeAddr
+	Locator  [][]byte
+}
+
+// Response with serialised headers of blocks. Lowest block first
+type ComHeaders struct {
+	Headers [][]byte
+}
+
+// Request for full blocks by hashes
+type ComGetBodies struct {
+	AddrFrom netlib.NodeAddr
+	Hashes   [][]byte
+}
+
+// Response with serialised blocks. It can have less blocks than requested
+type ComBodies struct {
+	Blocks [][]byte
+}
+
 type ComGetData struct {
 	AddrFrom netlib.NodeAddr
 	Type     string
@@ -144,9 +206,14 @@ type ComTx struct {
 
 // Version mesage to other nodes
 type ComVersion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   netlib.NodeAddr
+	Version      int
+	BestHeight   int
+	AddrFrom     netlib.NodeAddr
+	PrunedHeight int // blocks up to this height have no full data on the node. 0 if nothing is pruned
+	GenesisHash  []byte
+	Services     uint64 // bitfield of netlib.Service* flags
+	UserAgent    string
+	AddrYou      netlib.NodeAddr // address of the receiver as the sender sees it. Empty if unknown
 }
 
 // To send nodes manage command.
@@ -154,6 +221,26 @@ type ComManageNode struct {
 	Node netlib.NodeAddr
 }
 
+// To ban or unban a host
+type ComBanNode struct {
+	Host     string
+	Duration int // seconds. Default ban time of the node is used if 0
+}
+
+// Ban of a host. Connections from it are refused until the time
+type ComBannedNode struct {
+	Host   string
+	Until  int64 // unix time
+	Reason string
+}
+
+// Identity key of a node. Connections to the node are refused if it has other key.
+// Also it is used to pin a key or remove a pin, Key is empty in this case
+type ComPinnedNode struct {
+	Node netlib.NodeAddr
+	Key  []byte
+}
+
 // To get node state
 type ComGetNodeState struct {
 	Host                  string
@@ -161,6 +248,64 @@ type ComGetNodeState struct {
 	ExpectingBlocksHeight int
 	TransactionsCached    int
 	UnspentOutputs        int
+	Syncing               bool // blocks are loaded from other nodes now
+	SyncHeadersHeight     int  // height of last header received during sync
+	SyncPeers             int  // nodes used to load blocks
+	SyncBlocksInFlight    int  // blocks requested but not yet received
+	GenesisHash           []byte
+}
+
+// Request for blocks of the chain from top to genesis. Blocks are returned starting from the block
+// with the hash, from the top block if it is empty
+type ComGetChainBlocks struct {
+	StartFrom []byte
+	Count     int
+}
+
+// Serialised blocks. Next block is parent of previous one
+type ComChainBlocks struct {
+	Blocks [][]byte
+}
+
+// To write blocks of the chain to a file on the node
+type ComExportChain struct {
+	File string
+	From int
+	To   int // -1 for the top
+}
+
+// To write the UTXO set to a file on the node
+type ComDumpUTXOSet struct {
+	File string
+}
+
+// Info about the UTXO set written to a file
+type ComUTXOSetInfo struct {
+	TipHash []byte
+	Count   int
+	Digest  []byte
+}
+
+// To copy DB files and wallets of the node to a directory
+type ComBackup struct {
+	Dest string
+}
+
+// To check consistency of the DB of the node. Nothing is repaired
+type ComVerifyChain struct {
+	Depth int
+}
+
+// Result of a DB check
+type ComVerifyChainResult struct {
+	Count    int
+	Problems []string
+}
+
+// Transaction from the pool as it is printed
+type ComUnapprovedTransaction struct {
+	Hash string
+	Info string
 }
 
 // Check if node address looks fine
@@ -191,9 +336,14 @@ func (c *NodeClient) SetNodeAddress(address netlib.NodeAddr) {
 // It is used by a node to send to itself only when we want to stop a node
 // And unblock port listetining
 func (c *NodeClient) SendVoid(address netlib.NodeAddr) error {
-	request := netlib.CommandToBytes("viod")
+	request, err := c.BuildCommandData("viod", nil)
 
-	return c.SendData(address, request)
+	if err != nil {
+		return err
+	}
+
+	// the command must unblock listening of the port. so, it is always sent in new connection
+	return c.sendDataInNewConnection(address, request)
 }
 
 // Send list of nodes addresses to other node
@@ -219,6 +369,61 @@ func (c *NodeClient) SendBlock(addr netlib.NodeAddr, BlockSerialised []byte) err
 	return c.SendData(addr, request)
 }
 
+// Send compact block. It is sent instead of full block if other node requested it
+func (c *NodeClient) SendCompactBlock(addr netlib.NodeAddr, block ComCompactBlock) error {
+	block.AddrFrom = c.NodeAddress
+
+	request, err := c.BuildCommandData("cmpctblock", &block)
+
+	if err != nil {
+		return err
+	}
+
+	return c.SendData(addr, request)
+}
+
+// Request for transactions of a block that are missed to rebuild it from compact block
+func (c *NodeClient) SendGetBlockTxs(addr netlib.NodeAddr, hash []byte, indexes []int) ([][]byte, error) {
+	data := ComGetBlockTxs{c.NodeAddress, hash, indexes}
+
+	request, err := c.BuildCommandData("getblocktxn", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := ComBlockTxs{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload.Transactions, nil
+}
+
+// Request for IDs of unapproved transactions of other node. Missed transactions are requested with getdata
+func (c *NodeClient) SendMempool(addr netlib.NodeAddr) ([][]byte, error) {
+	data := ComMempool{c.NodeAddress}
+
+	request, err := c.BuildCommandData("mempool", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := ComMempoolData{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload.Transactions, nil
+}
+
 // Send inventory. Blocks hashes or transactions IDs
 func (c *NodeClient) SendInv(address netlib.NodeAddr, kind string, items [][]byte) error {
 	data := ComInv{c.NodeAddress, kind, items}
@@ -278,6 +483,48 @@ func (c *NodeClient) SendGetFirstBlocks(address netlib.NodeAddr) (*ComGetFirstBl
 	return &datapayload, nil
 }
 
+// Request for headers of blocks after a common block. Returns serialised headers
+func (c *NodeClient) SendGetHeaders(address netlib.NodeAddr, locator [][]byte) ([][]byte, error) {
+	data := ComGetHeaders{c.NodeAddress, locator}
+
+	request, err := c.BuildCommandData("getheaders", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := ComHeaders{}
+
+	err = c.SendDataWaitResponse(address, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload.Headers, nil
+}
+
+// Request for full blocks. Returns serialised blocks
+func (c *NodeClient) SendGetBodies(address netlib.NodeAddr, hashes [][]byte) ([][]byte, error) {
+	data := ComGetBodies{c.NodeAddress, hashes}
+
+	request, err := c.BuildCommandData("getbodies", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := ComBodies{}
+
+	err = c.SendDataWaitResponse(address, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload.Blocks, nil
+}
+
 // Request for a transaction or a block to get full info by ID or Hash
 func (c *NodeClient) SendGetData(address netlib.NodeAddr, kind string, id []byte) error {
 
@@ -305,10 +552,9 @@ func (c *NodeClient) SendTx(addr netlib.NodeAddr, tnxserialised []byte) error {
 }
 
 // Send own version and blockchain state to other node
-func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
-	data := ComVersion{netlib.NodeVersion, bestHeight, c.NodeAddress}
-
-	request, err := c.BuildCommandData("version", &data)
+// Pruned node also sends height of last pruned block. Such node can not return blocks below it
+func (c *NodeClient) SendVersion(addr netlib.NodeAddr, state ComVersion) error {
+	request, err := c.BuildVersionCommand(state)
 
 	if err != nil {
 		return err
@@ -317,6 +563,25 @@ func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
 	return c.SendData(addr, request)
 }
 
+// Builds version command data. It is also used as the first message in a peer session.
+// State contains heights, genesis hash and services of this node
+func (c *NodeClient) BuildVersionCommand(state ComVersion) ([]byte, error) {
+	return c.BuildCommandData("version", c.prepareVersionData(state))
+}
+
+// Verack is the answer to the first version command in a peer session. It contains same data as version
+func (c *NodeClient) BuildVerackCommand(state ComVersion) ([]byte, error) {
+	return c.BuildCommandData("verack", c.prepareVersionData(state))
+}
+
+func (c *NodeClient) prepareVersionData(state ComVersion) *ComVersion {
+	state.Version = netlib.NodeVersion
+	state.AddrFrom = c.NodeAddress
+	state.UserAgent = lib.ApplicationTitle + ":" + lib.ApplicationVersion
+
+	return &state
+}
+
 // Request for history of transaction from a wallet
 func (c *NodeClient) SendGetHistory(addr netlib.NodeAddr, address string) ([]ComHistoryTransaction, error) {
 	data := ComGetHistoryTransactions{address}
@@ -464,6 +729,21 @@ func (c *NodeClient) SendGetNodes() ([]netlib.NodeAddr, error) {
 	return datapayload, nil
 }
 
+// Request for stats of known nodes of local node
+func (c *NodeClient) SendGetNodesStats() ([]netlib.NodeStats, error) {
+	request, err := c.BuildCommandDataWithAuth("getnodestats", nil)
+
+	datapayload := []netlib.NodeStats{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Nodes Stats Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
 // Request to add new node to contacts
 func (c *NodeClient) SendAddNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
@@ -492,6 +772,92 @@ func (c *NodeClient) SendRemoveNode(node netlib.NodeAddr) error {
 	return nil
 }
 
+// Request to ban a host on the node
+func (c *NodeClient) SendBanNode(host string, duration int) error {
+	data := ComBanNode{host, duration}
+	request, err := c.BuildCommandDataWithAuth("bannode", &data)
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Ban Node Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Request to remove a ban of a host
+func (c *NodeClient) SendUnbanNode(host string) error {
+	data := ComBanNode{host, 0}
+	request, err := c.BuildCommandDataWithAuth("unbannode", &data)
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unban Node Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Request for list of banned hosts
+func (c *NodeClient) SendGetBanned() ([]ComBannedNode, error) {
+	request, err := c.BuildCommandDataWithAuth("getbanned", nil)
+
+	datapayload := []ComBannedNode{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Banned Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
+// Request to pin identity key of a node
+func (c *NodeClient) SendPinNode(node netlib.NodeAddr, key []byte) error {
+	data := ComPinnedNode{node, key}
+	request, err := c.BuildCommandDataWithAuth("pinnode", &data)
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Pin Node Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Request to remove pinned key of a node
+func (c *NodeClient) SendUnpinNode(node netlib.NodeAddr) error {
+	data := ComPinnedNode{node, nil}
+	request, err := c.BuildCommandDataWithAuth("unpinnode", &data)
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unpin Node Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Request for list of pinned keys of nodes
+func (c *NodeClient) SendGetPinned() ([]ComPinnedNode, error) {
+	request, err := c.BuildCommandDataWithAuth("getpinned", nil)
+
+	datapayload := []ComPinnedNode{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Pinned Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
 // Request to remove a node from contacts
 func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
 	request, err := c.BuildCommandDataWithAuth("getstate", nil)
@@ -507,6 +873,99 @@ func (c *NodeClient) SendGetState() (ComGetNodeState, error) {
 	return data, nil
 }
 
+// Request for blocks of the chain from the top to genesis. Local management command
+func (c *NodeClient) SendGetChainBlocks(startfrom []byte, count int) ([][]byte, error) {
+	data := ComGetChainBlocks{startfrom, count}
+	request, err := c.BuildCommandDataWithAuth("chainblocks", &data)
+
+	datapayload := ComChainBlocks{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Chain Blocks Response Error: %s", err.Error()))
+	}
+
+	return datapayload.Blocks, nil
+}
+
+// Request to write blocks of the chain to a file. Returns number of written blocks
+func (c *NodeClient) SendExportChain(file string, from, to int) (int, error) {
+	data := ComExportChain{file, from, to}
+	request, err := c.BuildCommandDataWithAuth("exportchain", &data)
+
+	count := 0
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &count)
+
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Export Chain Response Error: %s", err.Error()))
+	}
+
+	return count, nil
+}
+
+// Request to write the UTXO set to a file
+func (c *NodeClient) SendDumpUTXOSet(file string) (ComUTXOSetInfo, error) {
+	data := ComDumpUTXOSet{file}
+	request, err := c.BuildCommandDataWithAuth("dumputxoset", &data)
+
+	info := ComUTXOSetInfo{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &info)
+
+	if err != nil {
+		return info, errors.New(fmt.Sprintf("Dump UTXO Set Response Error: %s", err.Error()))
+	}
+
+	return info, nil
+}
+
+// Request to make a backup of the node data to a directory
+func (c *NodeClient) SendBackup(dest string) error {
+	data := ComBackup{dest}
+	request, err := c.BuildCommandDataWithAuth("backup", &data)
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, nil)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Backup Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Request to check the DB of the node
+func (c *NodeClient) SendVerifyChain(depth int) (ComVerifyChainResult, error) {
+	data := ComVerifyChain{depth}
+	request, err := c.BuildCommandDataWithAuth("verifychain", &data)
+
+	result := ComVerifyChainResult{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &result)
+
+	if err != nil {
+		return result, errors.New(fmt.Sprintf("Verify Chain Response Error: %s", err.Error()))
+	}
+
+	return result, nil
+}
+
+// Request for transactions in the pool of the node
+func (c *NodeClient) SendGetUnapproved() ([]ComUnapprovedTransaction, error) {
+	request, err := c.BuildCommandDataWithAuth("unapproved", nil)
+
+	datapayload := []ComUnapprovedTransaction{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Unapproved Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
 // Builds a command data. It prepares a slice of bytes from given data
 func (c *NodeClient) BuildCommandDataWithAuth(command string, data interface{}) ([]byte, error) {
 	authbytes := netlib.CommandToBytes(c.NodeAuthStr)
@@ -533,30 +992,28 @@ func (c *NodeClient) doBuildCommandData(command string, data interface{}, extra
 		payload = []byte{}
 	}
 	c.Logger.Trace.Printf("Build command %s", command)
-	payloadlength := uint32(len(payload))
-	bs := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bs, payloadlength) // convert int to []byte
-
-	request := append(netlib.CommandToBytes(command), bs...)
 
-	// add length of extra data
-	payloadlength = uint32(len(extra))
-	bs = make([]byte, 4)
-	binary.LittleEndian.PutUint32(bs, payloadlength) // convert int to []byte
+	// frame header with network magic, sizes and checksum is added. Too big data is not sent
+	return netlib.BuildFrame(command, payload, extra)
+}
 
-	request = append(request, bs...)
+// Sends prepared command to a node. This doesn't wait any response
+func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
+	err := c.CheckNodeAddress(addr)
 
-	request = append(request, payload...)
+	if err != nil {
+		return err
+	}
 
-	if len(extra) > 0 {
-		request = append(request, extra...)
+	if c.Transport != nil {
+		return c.Transport.Send(addr, data)
 	}
 
-	return request, nil
+	return c.sendDataInNewConnection(addr, data)
 }
 
-// Sends prepared command to a node. This doesn't wait any response
-func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
+// Connects to a node, sends data and closes the connection
+func (c *NodeClient) sendDataInNewConnection(addr netlib.NodeAddr, data []byte) error {
 	err := c.CheckNodeAddress(addr)
 
 	if err != nil {
@@ -564,19 +1021,10 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 	}
 
 	c.Logger.Trace.Printf("Sending %d bytes to %s", len(data), addr.NodeAddrToString())
-	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), 1*time.Second)
+	conn, err := c.connect(addr, data, 1*time.Second)
 
 	if err != nil {
-		c.Logger.Error.Println(err.Error())
-		c.Logger.Trace.Println("Error: ", err.Error())
-
-		// we can not connect.
-		// we could remove this node from known
-		// but this is not always good. we need somethign more smart here
-		// TODO this needs analysis . if removing of a node is good idea
-		//c.NodeNet.RemoveNodeFromKnown(addr)
-
-		return errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+		return err
 	}
 	defer conn.Close()
 
@@ -600,22 +1048,47 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 		return err
 	}
 
-	c.Logger.Trace.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
+	if c.Transport != nil {
+		response, err := c.Transport.SendWaitResponse(addr, data)
 
-	// connect
-	conn, err := net.Dial(netlib.Protocol, addr.NodeAddrToString())
+		if err != nil {
+			return err
+		}
+		return c.parseResponse(response, datapayload)
+	}
+
+	return c.sendDataWaitResponseInNewConnection(addr, data, datapayload)
+}
+
+// Requests list of nodes in new connection, not in a session. Any node answers it, so it shows
+// that a node really listens on the address
+func (c *NodeClient) SendCheckNode(addr netlib.NodeAddr) error {
+	request, err := c.BuildCommandData("getnodes", nil)
 
 	if err != nil {
-		c.Logger.Error.Println(err.Error())
-		c.Logger.Trace.Println("Error: ", err.Error())
+		return err
+	}
+
+	datapayload := []netlib.NodeAddr{}
 
-		// we can not connect.
-		// we could remove this node from known
-		// but this is not always good. we need somethign more smart here
-		// TODO this needs analysis . if removing of a node is good idea
-		//c.NodeNet.RemoveNodeFromKnown(addr)
+	err = c.sendDataWaitResponseInNewConnection(addr, request, &datapayload)
 
-		return errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Check Node Response Error: %s", err.Error()))
+	}
+
+	return nil
+}
+
+// Connects to a node, sends data and waits for response. The connection is closed after this
+func (c *NodeClient) sendDataWaitResponseInNewConnection(addr netlib.NodeAddr, data []byte, datapayload interface{}) error {
+	c.Logger.Trace.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
+
+	// connect
+	conn, err := c.connect(addr, data, 0)
+
+	if err != nil {
+		return err
 	}
 	defer conn.Close()
 
@@ -632,7 +1105,8 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 	// read everything
 	c.Logger.Trace.Println("Start readin response")
 
-	response, err := ioutil.ReadAll(conn)
+	// response can not be bigger than any request. first byte is a success flag
+	response, err := ioutil.ReadAll(io.LimitReader(conn, netlib.MaxPayloadSize+2))
 
 	if err != nil {
 		c.Logger.Error.Println(err.Error())
@@ -647,8 +1121,90 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 		return err
 	}
 
+	if len(response) > netlib.MaxPayloadSize+1 {
+		err := errors.New("Response is too big")
+		c.Logger.Error.Println(err.Error())
+		return err
+	}
+
 	c.Logger.Trace.Printf("Received %d bytes as a response\n", len(response))
 
+	return c.parseResponse(response, datapayload)
+}
+
+// Connects to a node. The connection is encrypted if it is enabled for the client.
+// Requests with auth string are sent only in encrypted connection to a node with same key as ours
+func (c *NodeClient) connect(addr netlib.NodeAddr, data []byte, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+
+	if err != nil {
+		c.Logger.Error.Println(err.Error())
+		c.Logger.Trace.Println("Error: ", err.Error())
+
+		// we can not connect. the node is removed from known if it fails too often
+		if c.NodeNet != nil {
+			c.NodeNet.MarkNodeFailure(addr)
+		}
+
+		return nil, errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+	}
+
+	if c.NodeNet != nil {
+		c.NodeNet.MarkNodeSuccess(addr, true)
+	}
+
+	management := netlib.GetFrameExtraLength(data) > 0
+
+	if !c.Encrypt && !management {
+		return conn, nil
+	}
+
+	key, err := c.getNodeKey()
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	secure, err := netlib.SecureClient(conn, key)
+
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("Encrypted connection with %s failed: %s", addr.NodeAddrToString(), err.Error()))
+	}
+
+	if management && !bytes.Equal(secure.RemoteKey, key.PublicKey) {
+		// auth string must not be sent to other node
+		secure.Close()
+		return nil, errors.New(fmt.Sprintf("Key of %s is not key of this node", addr.NodeAddrToString()))
+	}
+
+	return secure, nil
+}
+
+// Returns identity key of this client
+func (c *NodeClient) getNodeKey() (*netlib.NodeKey, error) {
+	if c.NodeKey != nil {
+		return c.NodeKey, nil
+	}
+
+	var err error
+
+	if c.DataDir != "" {
+		c.NodeKey, err = netlib.LoadNodeKey(c.DataDir)
+	} else {
+		c.NodeKey, err = netlib.NewNodeKey()
+	}
+
+	return c.NodeKey, err
+}
+
+// Parses response data. First byte is success flag. Error message follows if it is not success
+func (c *NodeClient) parseResponse(response []byte, datapayload interface{}) error {
+	if len(response) == 0 {
+		return errors.New("Received 0 bytes as a response. Expected at least 1 byte")
+	}
+
 	// convert response for provided structure
 	var buff bytes.Buffer
 	buff.Write(response[1:])
@@ -669,7 +1225,7 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 	}
 
 	if datapayload != nil {
-		err = dec.Decode(datapayload)
+		err := dec.Decode(datapayload)
 
 		if err != nil {
 			return err
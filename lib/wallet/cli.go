@@ -23,6 +23,7 @@ type AppInput struct {
 	DataDir   string
 	Nodes     []net.NodeAddr
 	LogDest   string
+	Encrypt   bool // encrypt connections to the node
 }
 
 type WalletCLI struct {
@@ -77,6 +78,7 @@ func (wc *WalletCLI) initNodeClient() {
 	client := nodeclient.NodeClient{}
 
 	client.Logger = wc.Logger
+	client.Encrypt = wc.Input.Encrypt
 	nt := net.NodeNetwork{}
 	nt.Init()
 	client.NodeNet = &nt
@@ -93,7 +95,7 @@ func (wc *WalletCLI) checkNodeAddress() {
 		wc.NodeCLI.NodeNet.LoadInitialNodes(nil)
 
 		if wc.NodeCLI.NodeNet.GetCountOfKnownNodes() > 0 {
-			wc.Node = wc.NodeCLI.NodeNet.Nodes[0]
+			wc.Node = wc.NodeCLI.NodeNet.GetNodes()[0]
 		}
 	}
 }
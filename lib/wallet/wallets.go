@@ -64,15 +64,17 @@ func (ws Wallets) GetWallet(address string) (Wallet, error) {
 	return Wallet{}, errors.New("Wallet nout found")
 }
 
-// LoadFromFile loads wallets from the file
-func (ws *Wallets) LoadFromFile() error {
-	var walletsFile string
-
+// Returns path to the wallets file
+func (ws Wallets) GetFilePath() string {
 	if ws.WalletsFile != "" {
-		walletsFile = ws.WalletsFile
-	} else {
-		walletsFile = ws.DataDir + walletFile
+		return ws.WalletsFile
 	}
+	return ws.DataDir + walletFile
+}
+
+// LoadFromFile loads wallets from the file
+func (ws *Wallets) LoadFromFile() error {
+	walletsFile := ws.GetFilePath()
 
 	_, err := os.Stat(walletsFile)
 
@@ -134,6 +134,7 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 
 			if err != nil {
 				bc.Logger.Trace.Printf("Chain replace error %s", err.Error())
+				return BCBAddState_error, err
 			}
 			// other branch becomes main branch now.
 			// it is needed to reindex unspent transactions and non confirmed
@@ -145,9 +146,10 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 
 			if err != nil {
 				bc.Logger.Trace.Printf("Chain add error %s", err.Error())
-			} else {
-				bc.Logger.Trace.Printf("Chain add error. Success")
+				return BCBAddState_error, err
 			}
+			bc.Logger.Trace.Printf("Chain add. Success")
+
 			return BCBAddState_addedToTop, nil
 		}
 	}
@@ -290,7 +292,11 @@ func (bc *Blockchain) DeleteBlock() (*structures.Block, error) {
 		return nil, err
 	}
 
-	bcdb.RemoveFromChain(block.Hash)
+	err = bcdb.RemoveFromChain(block.Hash)
+
+	if err != nil {
+		return nil, err
+	}
 
 	return block, nil
 }
@@ -521,6 +527,102 @@ func (bc *Blockchain) GetNextBlocks(startfrom []byte) ([]*structures.BlockShort,
 	return blocks, nil
 }
 
+// Returns hashes of blocks in the main chain to find a common block with other node.
+// First 10 hashes are from the top, then step is doubled. Genesis block is always the last
+func (bc *Blockchain) GetBlockLocator() ([][]byte, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := bcdb.GetTopHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	locator := [][]byte{}
+	step := 1
+	skip := 0
+
+	for len(hash) > 0 {
+		_, prevHash, _, err := bcdb.GetLocationInChain(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if skip == 0 || len(prevHash) == 0 {
+			locator = append(locator, hash)
+
+			if len(locator) >= 10 {
+				step *= 2
+			}
+			skip = step
+		}
+		skip--
+		hash = prevHash
+	}
+
+	return locator, nil
+}
+
+// Returns headers of blocks in the main chain after first known block from the locator.
+// Pruned blocks keep hash of transactions, so their headers are returned too
+func (bc *Blockchain) GetHeadersAfter(locator [][]byte, maxcount int) ([]*structures.BlockHeader, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hash []byte
+
+	for _, h := range locator {
+		found, _, nextHash, err := bcdb.GetLocationInChain(h)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			hash = nextHash
+			break
+		}
+	}
+
+	if hash == nil {
+		return nil, errors.New("No one block from the locator is found in the chain")
+	}
+
+	headers := []*structures.BlockHeader{}
+
+	for len(hash) > 0 && len(headers) < maxcount {
+		block, err := bc.GetBlock(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// pruned block keeps hash of transactions, so its header can be sent too
+		header, err := block.GetHeader()
+
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, header)
+
+		_, _, hash, err = bcdb.GetLocationInChain(hash)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
+
 // Returns first blocks in block chain
 func (bc *Blockchain) GetFirstBlocks(maxcount int) ([]*structures.Block, int, error) {
 	localError := func(err error) ([]*structures.Block, int, error) {
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Replaces the block body with only header. Transactions of the block are removed, their hash is kept.
+// Blocks must be pruned from the bottom of the chain, the block is saved as last pruned
+func (bc *Blockchain) PruneBlock(block *structures.Block) error {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	header, err := block.GetHeaderCopy()
+
+	if err != nil {
+		return err
+	}
+
+	headerData, err := header.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	err = bcdb.PutBlock(block.Hash, headerData)
+
+	if err != nil {
+		return err
+	}
+
+	return bcdb.SaveLastPrunedHash(block.Hash)
+}
+
+// Returns hash of the last pruned block in the chain. Empty if nothing was pruned
+func (bc *Blockchain) GetLastPrunedHash() ([]byte, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bcdb.GetLastPrunedHash()
+}
+
+// Returns height of the last pruned block. All blocks up to this height have only headers.
+// 0 means nothing is pruned. Genesis block is never pruned
+func (bc *Blockchain) GetPrunedHeight() (int, error) {
+	prunedHash, err := bc.GetLastPrunedHash()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(prunedHash) == 0 {
+		return 0, nil
+	}
+
+	block, err := bc.GetBlock(prunedHash)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return block.Height, nil
+}
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Checks a block of primary chain and its record in the chain index. Proof of work is not checked here.
+// nextHash is a hash of a block above this block in the chain, it is empty for the top block
+// Only chain index problems can be repaired. Other problems are only reported
+func (bc *Blockchain) VerifyBlock(block *structures.Block, storedHash []byte, nextHash []byte, repair bool) ([]string, error) {
+	problems := []string{}
+
+	if bytes.Compare(block.Hash, storedHash) != 0 {
+		problems = append(problems, fmt.Sprintf("Block stored with hash %x has hash %x", storedHash, block.Hash))
+	}
+
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block.PrevBlockHash) > 0 {
+		prevBlock, err := bc.GetBlock(block.PrevBlockHash)
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Previous block %x of block %x is not found", block.PrevBlockHash, block.Hash))
+		} else if prevBlock.Height+1 != block.Height {
+			problems = append(problems,
+				fmt.Sprintf("Block %x has height %d but previous block has height %d", block.Hash, block.Height, prevBlock.Height))
+		}
+	} else {
+		firstHash, err := bcdb.GetFirstHash()
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("First block hash is not saved. Genesis block is %x", block.Hash))
+
+			if repair {
+				err = bcdb.SaveFirstHash(block.Hash)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else if bytes.Compare(firstHash, block.Hash) != 0 {
+			problems = append(problems, fmt.Sprintf("First block hash is %x but genesis block is %x", firstHash, block.Hash))
+		}
+
+		if block.Height != 0 {
+			problems = append(problems, fmt.Sprintf("Genesis block %x has height %d", block.Hash, block.Height))
+		}
+	}
+
+	found, prevHash, recNextHash, err := bcdb.GetLocationInChain(block.Hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if found && bytes.Compare(prevHash, block.PrevBlockHash) == 0 && bytes.Compare(recNextHash, nextHash) == 0 {
+		return problems, nil
+	}
+
+	if !found {
+		problems = append(problems, fmt.Sprintf("Block %x is not in the chain index", block.Hash))
+	} else {
+		problems = append(problems,
+			fmt.Sprintf("Chain index record of block %x is wrong. Prev %x, next %x", block.Hash, prevHash, recNextHash))
+	}
+
+	if repair {
+		err = bcdb.PutChainRecord(block.Hash, block.PrevBlockHash, nextHash)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
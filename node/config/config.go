@@ -3,6 +3,7 @@ package config
 // This code reads command line arguments and config file
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -28,6 +29,13 @@ type AllPossibleArgs struct {
 	Transaction string
 	View        string
 	Clean       bool
+	Depth       int
+	Repair      bool
+	File        string
+	Dest        string
+	Src         string
+	Key         string
+	Digest      string
 }
 
 // Input summary
@@ -37,19 +45,47 @@ type AppInput struct {
 	Logs          string
 	Port          int
 	Host          string
+	Bind          string
 	DataDir       string
 	Nodes         []net.NodeAddr
+	Seeds         []string
+	SeedNodes     net.NodesListJSON
+	Prune         int
+	BanTime       int
+	MaxConn       int
+	Encrypt       bool
+	LanDiscovery  bool
+	RPCPort       int
+	RPCHost       string
+	RPCUser       string
+	RPCPassword   string
+	RESTPort      int
+	RESTHost      string
 	Args          AllPossibleArgs
 	Database      database.DatabaseConfig
 }
 
 type AppConfig struct {
-	Minter   string
-	Port     int
-	Host     string
-	Nodes    []net.NodeAddr
-	Logs     []string
-	Database database.DatabaseConfig
+	Minter       string
+	Port         int
+	Host         string // address that other nodes use to connect
+	Bind         string // address to listen on. All interfaces if empty
+	Nodes        []net.NodeAddr
+	Seeds        []string          // URLs and JSON files with initial nodes
+	SeedNodes    net.NodesListJSON // initial nodes list in the config
+	Logs         []string
+	Prune        int
+	BanTime      int
+	MaxConn      int
+	Encrypt      bool
+	LanDiscovery bool   // find nodes of same chain in local network
+	RPCPort      int    // JSON-RPC port. RPC is disabled if 0
+	RPCHost      string // host to listen for RPC requests. localhost by default
+	RPCUser      string
+	RPCPassword  string
+	RESTPort     int    // read-only REST API port. Disabled if 0
+	RESTHost     string // host to listen for REST requests. localhost by default
+	Database     database.DatabaseConfig
 }
 
 // Parses inout and config file. Command line arguments ovverride config file options
@@ -68,11 +104,24 @@ func GetAppInput() (AppInput, error) {
 	cmd.StringVar(&input.Args.Address, "address", "", "Address of operation")
 	cmd.StringVar(&input.Logs, "logs", "", "List of enabled logs groups")
 	cmd.StringVar(&input.MinterAddress, "minter", "", "Wallet address which signs blocks")
+	cmd.IntVar(&input.Prune, "prune", 0, "Number of top blocks to keep with full data. Older blocks are pruned")
+	cmd.IntVar(&input.BanTime, "bantime", 0, "Number of seconds to ban misbehaving nodes")
+	cmd.IntVar(&input.MaxConn, "maxconnections", 0, "Max number of inbound connections")
+	cmd.BoolVar(&input.Encrypt, "encrypt", false, "Encrypt connections to other nodes")
+	cmd.BoolVar(&input.LanDiscovery, "landiscovery", false, "Find nodes of same chain in local network")
+	cmd.IntVar(&input.RPCPort, "rpcport", 0, "Port for JSON-RPC requests")
+	cmd.StringVar(&input.RPCHost, "rpchost", "", "Host to listen for JSON-RPC requests. localhost by default")
+	cmd.StringVar(&input.RPCUser, "rpcuser", "", "User name for JSON-RPC requests")
+	cmd.StringVar(&input.RPCPassword, "rpcpassword", "", "Password for JSON-RPC requests")
+	cmd.IntVar(&input.RESTPort, "restport", 0, "Port for read-only REST API")
+	cmd.StringVar(&input.RESTHost, "resthost", "", "Host to listen for REST requests. localhost by default")
+	seedsPtr := cmd.String("seeds", "", "Comma separated list of URLs and JSON files with initial nodes")
 	cmd.StringVar(&input.Args.Genesis, "genesis", "", "Genesis block text")
 	cmd.StringVar(&input.Args.Transaction, "transaction", "", "Transaction ID")
 	cmd.StringVar(&input.Args.From, "from", "", "Address to send money from")
 	cmd.StringVar(&input.Args.To, "to", "", "Address to send money to")
 	cmd.StringVar(&input.Args.Host, "host", "", "Node Server Host")
+	cmd.StringVar(&input.Bind, "bind", "", "Address to listen on. All interfaces by default")
 	cmd.StringVar(&input.Args.NodeHost, "nodehost", "", "Remote Node Server Host")
 	cmd.IntVar(&input.Args.Port, "port", 0, "Node Server port")
 	cmd.IntVar(&input.Args.NodePort, "nodeport", 0, "Remote Node Server port")
@@ -80,6 +129,13 @@ func GetAppInput() (AppInput, error) {
 	cmd.StringVar(&input.Args.LogDest, "logdest", "file", "Destination of logs. file or stdout")
 	cmd.StringVar(&input.Args.View, "view", "", "View format")
 	cmd.BoolVar(&input.Args.Clean, "clean", false, "Clean data/cache")
+	cmd.IntVar(&input.Args.Depth, "depth", 0, "Number of blocks from the top to process")
+	cmd.BoolVar(&input.Args.Repair, "repair", false, "Fix found problems")
+	cmd.StringVar(&input.Args.File, "file", "", "File path")
+	cmd.StringVar(&input.Args.Dest, "dest", "", "Destination directory")
+	cmd.StringVar(&input.Args.Src, "src", "", "Source directory")
+	cmd.StringVar(&input.Args.Key, "key", "", "Public key of a node in hex")
+	cmd.StringVar(&input.Args.Digest, "digest", "", "Trusted digest of a UTXO snapshot in hex")
 
 	datadirPtr := cmd.String("datadir", "", "Location of data files, config, DB etc")
 	err := cmd.Parse(os.Args[2:])
@@ -110,6 +166,10 @@ func GetAppInput() (AppInput, error) {
 	input.Port = input.Args.Port
 	input.Host = input.Args.Host
 
+	if *seedsPtr != "" {
+		input.Seeds = strings.Split(*seedsPtr, ",")
+	}
+
 	// read config file . command line arguments are more important than a config
 	config, err := input.GetConfig()
 
@@ -130,24 +190,93 @@ func GetAppInput() (AppInput, error) {
 			input.Host = config.Host
 		}
 
+		if input.Bind == "" && config.Bind != "" {
+			input.Bind = config.Bind
+		}
+
 		if len(config.Nodes) > 0 {
 			input.Nodes = config.Nodes
 		}
 
+		if len(input.Seeds) == 0 && len(config.Seeds) > 0 {
+			input.Seeds = config.Seeds
+		}
+
+		input.SeedNodes = config.SeedNodes
+
 		if input.Logs == "" && len(config.Logs) > 0 {
 			input.Logs = strings.Join(config.Logs, ",")
 		}
 
+		if input.Prune < 1 && config.Prune > 0 {
+			input.Prune = config.Prune
+		}
+
+		if input.BanTime < 1 && config.BanTime > 0 {
+			input.BanTime = config.BanTime
+		}
+
+		if input.MaxConn < 1 && config.MaxConn > 0 {
+			input.MaxConn = config.MaxConn
+		}
+
+		if !input.Encrypt && config.Encrypt {
+			input.Encrypt = true
+		}
+
+		if !input.LanDiscovery && config.LanDiscovery {
+			input.LanDiscovery = true
+		}
+
+		if input.RPCPort < 1 && config.RPCPort > 0 {
+			input.RPCPort = config.RPCPort
+		}
+
+		if input.RPCHost == "" && config.RPCHost != "" {
+			input.RPCHost = config.RPCHost
+		}
+
+		if input.RPCUser == "" && config.RPCUser != "" {
+			input.RPCUser = config.RPCUser
+			input.RPCPassword = config.RPCPassword
+		}
+
+		if input.RESTPort < 1 && config.RESTPort > 0 {
+			input.RESTPort = config.RESTPort
+		}
+
+		if input.RESTHost == "" && config.RESTHost != "" {
+			input.RESTHost = config.RESTHost
+		}
+
 		input.Database = config.Database
 	} else {
 		input.Database.SetDefault()
 	}
 	input.Database.DataDir = input.DataDir
 
+	if input.RPCPassword == "" {
+		input.RPCPassword = os.Getenv(RPCPasswordEnv)
+	}
+
+	for i, seed := range input.Seeds {
+		seed = strings.Trim(seed, " ")
+
+		if !strings.Contains(seed, "://") && !filepath.IsAbs(seed) {
+			// files are in the data directory if the path is relative
+			seed = input.DataDir + seed
+		}
+		input.Seeds[i] = seed
+	}
+
 	if input.Host == "" {
 		input.Host = "localhost"
 	}
 
+	if input.Prune > 0 && input.Prune < MinPruneKeep {
+		return input, errors.New(fmt.Sprintf("Prune value %d is too small. At least %d blocks must be kept", input.Prune, MinPruneKeep))
+	}
+
 	return input, nil
 }
 func (c AppInput) GetConfig() (*AppConfig, error) {
@@ -213,9 +342,46 @@ func (c AppInput) UpdateConfig() error {
 	if c.Host != "" {
 		config.Host = c.Host
 	}
+	if c.Bind != "" {
+		config.Bind = c.Bind
+	}
 	if c.Port > 0 {
 		config.Port = c.Port
 	}
+	if c.Prune > 0 {
+		config.Prune = c.Prune
+	}
+	if c.BanTime > 0 {
+		config.BanTime = c.BanTime
+	}
+	if c.MaxConn > 0 {
+		config.MaxConn = c.MaxConn
+	}
+	if c.Encrypt {
+		config.Encrypt = true
+	}
+	if c.LanDiscovery {
+		config.LanDiscovery = true
+	}
+	if c.RPCPort > 0 {
+		config.RPCPort = c.RPCPort
+	}
+	if c.RPCHost != "" {
+		config.RPCHost = c.RPCHost
+	}
+	if c.RPCUser != "" {
+		config.RPCUser = c.RPCUser
+		config.RPCPassword = c.RPCPassword
+	}
+	if c.RESTPort > 0 {
+		config.RESTPort = c.RESTPort
+	}
+	if c.RESTHost != "" {
+		config.RESTHost = c.RESTHost
+	}
+	if len(c.Seeds) > 0 {
+		config.Seeds = c.Seeds
+	}
 
 	if c.Args.NodeHost != "" && c.Args.NodePort > 0 {
 		node := net.NodeAddr{c.Args.NodeHost, c.Args.NodePort}
@@ -277,10 +443,17 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  createwallet\n\t- Generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  createblockchain -address ADDRESS -genesis GENESISTEXT\n\t- Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  initblockchain [-nodehost HOST] [-nodeport PORT]\n\t- Loads a blockchain from other node to init the DB.")
+	fmt.Println("  exportchain -file FILE [-from HEIGHT] [-to HEIGHT]\n\t- Write blocks of the chain to a file. All blocks are written by default")
+	fmt.Println("  importchain -file FILE\n\t- Add blocks from a file made with exportchain. Blocks are verified. Creates new blockchain if it doesn't exist yet")
+	fmt.Println("  dumputxoset -file FILE\n\t- Write unspent outputs and block headers to a file. Prints the digest of the set")
+	fmt.Println("  loadutxoset -file FILE -digest HEX\n\t- Create new blockchain from a file made with dumputxoset. The digest must be taken from a trusted source, the file is refused if it is different. Blocks up to the tip are kept as headers only. Genesis hash must be set in SeedNodes of the config")
+	fmt.Println("  backup -dest DIR\n\t- Copy the DB files and the wallets file to a directory. Can be done while the node server works")
+	fmt.Println("  restore -src DIR\n\t- Replace the DB files and the wallets file with files from a backup directory. Files are checked and copied before. Current files are kept with .old extension. If some file can not be replaced, all files are returned back")
 	fmt.Println("  printchain [-view short|long]\n\t- Print all the blocks of the blockchain. Default view is long")
 	fmt.Println("  makeblock [-minter ADDRESS]\n\t- Try to mine new block if there are enough transactions")
 	fmt.Println("  dropblock\n\t- Delete last block fro the block chain. All transaction are returned back to unapproved state")
 	fmt.Println("  reindexcache\n\t- Rebuilds the database of unspent transactions outputs and transaction pointers")
+	fmt.Println("  verifychain [-depth N] [-repair]\n\t- Check consistency of blocks, chain index, transactions index and unspent outputs for N top blocks (all if not set). If the option -repair provided then fixes found problems")
 	fmt.Println("  showunspent -address ADDRESS\n\t- Print the list of all unspent transactions and balance")
 	fmt.Println("  unapprovedtransactions [-clean]\n\t- Print the list of transactions not included in any block yet. If the option -clean provided then cleans the cache")
 
@@ -292,13 +465,22 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT\n\t- Send AMOUNT of coins from FROM address to TO. ")
 	fmt.Println("  canceltransaction -transaction TRANSACTIONID\n\t- Cancel unapproved transaction. NOTE!. This cancels only from local cache!")
 
-	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-port PORT]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server and -port - listening port")
-	fmt.Println("  startintnode [-minter ADDRESS] [-port PORT]\n\t- Start a node server in interactive mode (no deamon). -minter defines minting address and -port - listening port")
+	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-bind HOST] [-port PORT] [-prune N] [-bantime SECONDS] [-maxconnections N] [-encrypt] [-seeds LIST] [-landiscovery] [-rpcport PORT] [-rpchost HOST] [-rpcuser USER -rpcpassword PASSWORD] [-restport PORT] [-resthost HOST]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server that other nodes use, -bind - address to listen on (all interfaces by default) and -port - listening port. -prune N - keep only N top blocks with full data, at least 288. -bantime - how long misbehaving nodes are banned, 24 hours by default. -maxconnections - max number of inbound connections, 125 by default. -encrypt - encrypt connections to other nodes. -seeds - comma separated URLs and JSON files with initial nodes. -landiscovery - find nodes of same chain in local network. -rpcport - port for JSON-RPC requests, -rpchost - host to listen on, localhost by default. -rpcuser and -rpcpassword - RPC credentials, the node auth string can be used as a password too if RPC is on localhost. -restport - port for read-only REST API, -resthost - host to listen on, localhost by default")
+	fmt.Println("  startintnode [-minter ADDRESS] [-host HOST] [-bind HOST] [-port PORT] [-prune N] [-bantime SECONDS] [-maxconnections N] [-encrypt] [-seeds LIST] [-landiscovery] [-rpcport PORT] [-rpchost HOST] [-rpcuser USER -rpcpassword PASSWORD] [-restport PORT] [-resthost HOST]\n\t- Start a node server in interactive mode (no deamon). -minter defines minting address and -port - listening port")
 	fmt.Println("  stopnode\n\t- Stop runnning node")
 	fmt.Println("  nodestate\n\t- Print state of the node process")
-	fmt.Println("  updateconfig [-minter ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
+	fmt.Println("  lockstatus\n\t- Print state of database locks and what process holds them")
+	fmt.Println("  updateconfig [-minter ADDRESS] [-host HOST] [-bind HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-prune N] [-bantime SECONDS] [-maxconnections N] [-encrypt] [-seeds LIST] [-landiscovery] [-rpcport PORT] [-rpchost HOST] [-rpcuser USER -rpcpassword PASSWORD] [-restport PORT] [-resthost HOST]\n\t- Update config file. Allows to set this node minter address, host, bind address and port, remote node host and port, prune mode, ban time, connections limit, encryption, seed sources, LAN discovery, RPC and REST settings")
 
-	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive")
+	fmt.Println("  seednodes\n\t- Load lists of initial nodes from seed sources and display them. Shows where every list is loaded from and why it is not used")
+	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive, with source, last seen and last success time and number of failures")
 	fmt.Println("  addnode -nodehost HOST -nodeport PORT\n\t- Adds new node to list of connections")
 	fmt.Println("  removenode -nodehost HOST -nodeport PORT\n\t- Removes a node from list of connections")
+	fmt.Println("  listbanned\n\t- Display list of banned hosts with time when the ban expires")
+	fmt.Println("  ban -nodehost HOST [-bantime SECONDS]\n\t- Refuse connections from the host. Default ban time is used if -bantime is not set")
+	fmt.Println("  unban -nodehost HOST\n\t- Remove a ban of the host")
+	fmt.Println("  nodekey\n\t- Display the identity key of this node. Other nodes can pin it")
+	fmt.Println("  listpinned\n\t- Display list of nodes with pinned keys")
+	fmt.Println("  pinnode -nodehost HOST -nodeport PORT -key KEY\n\t- Pin the key of a node. Connections to the node are encrypted and refused if the node has other key")
+	fmt.Println("  unpinnode -nodehost HOST -nodeport PORT\n\t- Remove pinned key of a node. New key is pinned on next encrypted connection")
 }
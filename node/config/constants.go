@@ -19,9 +19,16 @@ const MaxNumberTransactionInBlock = 10000
 // ==========================================================
 //No need to change this
 
+// Minimum number of top blocks a pruned node keeps with full data
+// A node can not switch to other branch that forks below its pruned blocks
+const MinPruneKeep = 288
+
 // File names
 const PidFileName = "server.pid"
 
+// Environment variable with the RPC password. It is not passed on command line of the daemon process
+const RPCPasswordEnv = "DEMOCOIN_RPCPASSWORD"
+
 // other internal constant
 const Daemonprocesscommandline = "daemonnode"
 
@@ -0,0 +1,28 @@
+package consensus
+
+// Custom errors
+
+import (
+	"fmt"
+)
+
+const BlockVerifyErrorPoW = "pow"
+const BlockVerifyErrorRules = "rules"
+
+// Block breaks consensus rules. Other errors of verification are not problems of the block
+type BlockVerifyError struct {
+	err  string
+	kind string
+}
+
+func (e *BlockVerifyError) Error() string {
+	return fmt.Sprintf("Block verify failed: %s", e.err)
+}
+
+func (e *BlockVerifyError) GetKind() string {
+	return e.kind
+}
+
+func NewBlockVerifyError(err string, kind string) error {
+	return &BlockVerifyError{err, kind}
+}
@@ -261,7 +261,7 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	}
 
 	if !valid {
-		return errors.New("Block hash is not valid")
+		return NewBlockVerifyError("Block hash is not valid", BlockVerifyErrorPoW)
 	}
 	n.Logger.Trace.Println("block hash verified")
 	// 2. check number of TX
@@ -274,11 +274,11 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	}
 
 	if txnum < min {
-		return errors.New("Number of transactions is too low")
+		return NewBlockVerifyError("Number of transactions is too low", BlockVerifyErrorRules)
 	}
 
 	if txnum > max {
-		return errors.New("Number of transactions is too high")
+		return NewBlockVerifyError("Number of transactions is too high", BlockVerifyErrorRules)
 	}
 
 	// 1
@@ -289,25 +289,29 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	for _, tx := range block.Transactions {
 		if tx.IsCoinbase() {
 			if coinbaseused {
-				return errors.New("2 coin base TX in the block")
+				return NewBlockVerifyError("2 coin base TX in the block", BlockVerifyErrorRules)
 			}
 			coinbaseused = true
 		}
 		vtx, err := n.getTransactionsManager().VerifyTransaction(tx, prevTXs, block.PrevBlockHash)
 
+		if txerr, ok := err.(*transactions.TXVerifyError); ok && txerr.GetKind() == transactions.TXVerifyErrorInvalid {
+			return NewBlockVerifyError(txerr.Error(), BlockVerifyErrorRules)
+		}
+
 		if err != nil {
 			return err
 		}
 
 		if !vtx {
-			return errors.New(fmt.Sprintf("Transaction in a block is not valid: %x", tx.ID))
+			return NewBlockVerifyError(fmt.Sprintf("Transaction in a block is not valid: %x", tx.ID), BlockVerifyErrorRules)
 		}
 
 		prevTXs = append(prevTXs, tx)
 	}
 	// 1.
 	if !coinbaseused {
-		return errors.New("No coinbase TX in the block")
+		return NewBlockVerifyError("No coinbase TX in the block", BlockVerifyErrorRules)
 	}
 	return nil
 }
@@ -17,8 +17,9 @@ var (
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
-	block  *structures.Block
-	target *big.Int
+	block   *structures.Block
+	target  *big.Int
+	txshash []byte // is set when PoW of a header is checked. Block has no transactions in this case
 }
 
 // NewProofOfWork builds and returns a ProofOfWork object
@@ -36,7 +37,7 @@ func NewProofOfWork(b *structures.Block) *ProofOfWork {
 
 	target.Lsh(target, uint(256-tb))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, target, nil}
 
 	return pow
 }
@@ -44,10 +45,16 @@ func NewProofOfWork(b *structures.Block) *ProofOfWork {
 // Prepares data for next iteration of PoW
 // this will be hashed
 func (pow *ProofOfWork) prepareData() ([]byte, error) {
-	txshash, err := pow.block.HashTransactions()
+	txshash := pow.txshash
 
-	if err != nil {
-		return nil, err
+	if txshash == nil {
+		var err error
+
+		txshash, err = pow.block.HashTransactions()
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	data := bytes.Join(
@@ -119,3 +126,34 @@ func (pow *ProofOfWork) Validate() (bool, error) {
 
 	return isValid, nil
 }
+
+// Validates PoW of a block header. Hash of transactions is in the header, so transactions are not needed.
+// Also the hash in the header must be the hash of its data. Next blocks refer to it
+func ValidateHeader(h *structures.BlockHeader) (bool, error) {
+	block := &structures.Block{}
+	block.Timestamp = h.Timestamp
+	block.PrevBlockHash = h.PrevBlockHash
+	block.Hash = h.Hash
+	block.Nonce = h.Nonce
+	block.Height = h.Height
+
+	pow := NewProofOfWork(block)
+	pow.txshash = h.TxHash
+
+	predata, err := pow.prepareData()
+
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256(pow.addNonceToPrepared(predata, block.Nonce))
+
+	if !bytes.Equal(hash[:], h.Hash) {
+		return false, nil
+	}
+
+	var hashInt big.Int
+	hashInt.SetBytes(hash[:])
+
+	return hashInt.Cmp(pow.target) == -1, nil
+}
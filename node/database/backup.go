@@ -0,0 +1,241 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Buckets that must exist in every DB file
+var requiredBuckets = map[string][]string{
+	ClassNameBlockchain: []string{blocksBucket, blockChainBucket, transactionsBucket, transactionsOutputsBucket,
+		unspentTransactionsBucket, unapprovedTransactionsBucket},
+	ClassNameNodes: []string{nodesBucket},
+}
+
+// Copies DB files to a directory. Every file is copied in a read transaction,
+// so the copy is consistent even if other process writes to the DB at same time
+func (bdm *BoltDBManager) Backup(destDir string) error {
+	for _, name := range []string{ClassNameBlockchain, ClassNameNodes} {
+		conn, err := bdm.getConnectionForObject(name)
+
+		if err != nil {
+			return err
+		}
+
+		destfile := destDir + bdm.getDBFileNameForObject(name)
+
+		err = conn.view(func(tx *bolt.Tx) error {
+			return tx.CopyFile(destfile, 0600)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks DB files in a backup directory. Files must be complete DB files of supported version
+func (bdm *BoltDBManager) CheckBackup(srcDir string) error {
+	for _, name := range []string{ClassNameBlockchain, ClassNameNodes} {
+		srcfile := srcDir + bdm.getDBFileNameForObject(name)
+
+		if !bdm.dbExists(srcfile) {
+			return errors.New(fmt.Sprintf("Database file %s not found", srcfile))
+		}
+
+		err := bdm.checkDBFile(name, srcfile)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Database file %s is not valid: %s", srcfile, err.Error()))
+		}
+	}
+	return nil
+}
+
+// Replaces DB files with files from a backup directory. Current files are renamed to *.old
+// Extra files (source path -> destination path) are replaced together with DB files.
+// All files are copied first, then all are renamed. If any rename fails, done renames are undone
+// Both DB files are locked while they are replaced, nobody must have them opened
+func (bdm *BoltDBManager) Restore(srcDir string, extraFiles map[string]string) error {
+	err := bdm.CheckBackup(srcDir)
+
+	if err != nil {
+		return err
+	}
+
+	bdm.reason = "Restore"
+
+	files := map[string]string{}
+
+	for _, name := range []string{ClassNameBlockchain, ClassNameNodes} {
+		lock, err := bdm.lockDB(name)
+
+		if err != nil {
+			return err
+		}
+
+		defer lock.Unlock()
+
+		dbfile, err := bdm.getDBFileForObject(name)
+
+		if err != nil {
+			return err
+		}
+
+		files[srcDir+bdm.getDBFileNameForObject(name)] = dbfile
+	}
+
+	for srcfile, destfile := range extraFiles {
+		files[srcfile] = destfile
+	}
+
+	// copy first, so a failed copy doesn't break current files
+	for srcfile, destfile := range files {
+		err = copyFile(srcfile, destfile+".restore")
+
+		if err != nil {
+			for _, destfile := range files {
+				os.Remove(destfile + ".restore")
+			}
+			return err
+		}
+	}
+
+	return replaceFiles(files)
+}
+
+// Renames *.restore files to destination files. Current files are renamed to *.old
+// On error all done renames are undone and *.restore files are removed
+func replaceFiles(files map[string]string) error {
+	// destination file -> true if it existed and was renamed to *.old
+	replaced := map[string]bool{}
+
+	undo := func() {
+		for destfile, existed := range replaced {
+			if existed {
+				os.Rename(destfile+".old", destfile)
+			} else {
+				os.Remove(destfile)
+			}
+		}
+		for _, destfile := range files {
+			os.Remove(destfile + ".restore")
+		}
+	}
+
+	for _, destfile := range files {
+		existed := false
+
+		if _, err := os.Stat(destfile); err == nil {
+			err = os.Rename(destfile, destfile+".old")
+
+			if err != nil {
+				undo()
+				return err
+			}
+			existed = true
+		}
+
+		err := os.Rename(destfile+".restore", destfile)
+
+		if err != nil {
+			if existed {
+				os.Rename(destfile+".old", destfile)
+			}
+			undo()
+			return err
+		}
+
+		replaced[destfile] = existed
+	}
+	return nil
+}
+
+// Opens a DB file in read only mode and checks the schema version and buckets
+func (bdm *BoltDBManager) checkDBFile(name string, dbfile string) error {
+	db, err := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		version, _ := readSchemaVersion(tx)
+
+		if version > getCurrentSchemaVersion(name) {
+			return errors.New(fmt.Sprintf("Schema version %d is not supported", version))
+		}
+
+		for _, bucket := range requiredBuckets[name] {
+			if tx.Bucket([]byte(bucket)) == nil {
+				return errors.New(fmt.Sprintf("Bucket %s is not found", bucket))
+			}
+		}
+		return nil
+	})
+
+	if err != nil || name != ClassNameBlockchain {
+		return err
+	}
+
+	// top block must be in the DB
+	bc := Blockchain{DB: &BoltDB{db: db, name: name}}
+
+	topBlock, err := bc.GetTopBlock()
+
+	if err != nil {
+		return err
+	}
+
+	if topBlock == nil {
+		return errors.New("Top block is not found")
+	}
+	return nil
+}
+
+func (bdm *BoltDBManager) getDBFileNameForObject(name string) string {
+	if bdm.isNodesDB(name) {
+		return bdm.Config.NodesFile
+	}
+	return bdm.Config.BlockchainFile
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Sync()
+
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	hash1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	hash2 := []byte{0, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	bc, _ := man.GetBlockchainObject()
+
+	err = bc.PutBlockOnTop(hash1, []byte("block1"))
+
+	assert.NoError(t, err, "Can not add block1")
+
+	backupDir := testFolderName + "/backup/"
+
+	err = os.Mkdir(backupDir, 0744)
+
+	assert.NoError(t, err, "Backup folder not created")
+
+	err = man.Backup(backupDir)
+
+	assert.NoError(t, err, "Backup failed")
+
+	err = man.CheckBackup(backupDir)
+
+	assert.NoError(t, err, "Backup must be valid")
+
+	err = bc.PutBlockOnTop(hash2, []byte("block2"))
+
+	assert.NoError(t, err, "Can not add block2")
+
+	man.CloseConnection()
+
+	err = man.Restore(backupDir, nil)
+
+	assert.NoError(t, err, "Restore failed")
+
+	dbfile, _ := man.getDBFileForObject(ClassNameBlockchain)
+
+	_, err = os.Stat(dbfile + ".old")
+
+	assert.NoError(t, err, "Previous DB file must be kept")
+
+	man.OpenConnection("testing")
+
+	bc, _ = man.GetBlockchainObject()
+
+	topHash, err := bc.GetTopHash()
+
+	assert.NoError(t, err, "Can not get top hash")
+	assert.Equal(t, hash1, topHash, "DB is not restored")
+
+	// broken file must not be accepted
+	err = ioutil.WriteFile(backupDir+man.Config.NodesFile, []byte("broken"), 0600)
+
+	assert.NoError(t, err, "Can not write file")
+
+	err = man.CheckBackup(backupDir)
+
+	assert.Error(t, err, "Broken backup must not be valid")
+}
+
+func TestReplaceFilesUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replace")
+
+	assert.NoError(t, err, "Can not create temp folder")
+
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(dir+"/a", []byte("old a"), 0600)
+	ioutil.WriteFile(dir+"/a.restore", []byte("new a"), 0600)
+	ioutil.WriteFile(dir+"/b", []byte("old b"), 0600)
+	// b.restore is missing, so b can not be replaced
+
+	err = replaceFiles(map[string]string{"a": dir + "/a", "b": dir + "/b"})
+
+	assert.Error(t, err, "Replace must fail")
+
+	data, _ := ioutil.ReadFile(dir + "/a")
+
+	assert.Equal(t, "old a", string(data), "File a must be returned back")
+
+	data, _ = ioutil.ReadFile(dir + "/b")
+
+	assert.Equal(t, "old b", string(data), "File b must not be changed")
+}
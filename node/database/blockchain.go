@@ -16,7 +16,7 @@ type Blockchain struct {
 
 // create bucket etc. DB is already inited
 func (bc *Blockchain) InitDB() error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(blocksBucket))
 
 		if err != nil {
@@ -47,7 +47,7 @@ func (bc *Blockchain) GetTopBlock() ([]byte, error) {
 func (bc *Blockchain) GetBlock(hash []byte) ([]byte, error) {
 	var blockData []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -97,7 +97,7 @@ func (bc *Blockchain) PutBlockOnTop(hash []byte, blockdata []byte) error {
 
 // Add block record
 func (bc *Blockchain) PutBlock(hash []byte, blockdata []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -111,7 +111,7 @@ func (bc *Blockchain) PutBlock(hash []byte, blockdata []byte) error {
 
 // Delete block record
 func (bc *Blockchain) DeleteBlock(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -125,7 +125,7 @@ func (bc *Blockchain) DeleteBlock(hash []byte) error {
 
 // Save top level block hash
 func (bc *Blockchain) SaveTopHash(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -142,7 +142,7 @@ func (bc *Blockchain) SaveTopHash(hash []byte) error {
 func (bc *Blockchain) GetTopHash() ([]byte, error) {
 	var topHash []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -167,7 +167,7 @@ func (bc *Blockchain) GetTopHash() ([]byte, error) {
 
 // Save first (or genesis) block hash. It should be called when blockchain is created
 func (bc *Blockchain) SaveFirstHash(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -181,7 +181,7 @@ func (bc *Blockchain) SaveFirstHash(hash []byte) error {
 func (bc *Blockchain) GetFirstHash() ([]byte, error) {
 	var firstHash []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -204,6 +204,39 @@ func (bc *Blockchain) GetFirstHash() ([]byte, error) {
 	return nil, NewNotFoundDBError("firsthash")
 }
 
+// Save hash of the last pruned block. Blocks below it are pruned too
+func (bc *Blockchain) SaveLastPrunedHash(hash []byte) error {
+	return bc.DB.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Put([]byte("p"), hash)
+	})
+}
+
+// Returns hash of the last pruned block. Empty if nothing was pruned yet
+func (bc *Blockchain) GetLastPrunedHash() ([]byte, error) {
+	var prunedHash []byte
+
+	err := bc.DB.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		prunedHash = utils.CopyBytes(b.Get([]byte("p")))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prunedHash, nil
+}
+
 // add block to chain
 func (bc *Blockchain) AddToChain(hash, prevHash []byte) error {
 	length := len(hash)
@@ -214,7 +247,7 @@ func (bc *Blockchain) AddToChain(hash, prevHash []byte) error {
 
 	emptyHash := make([]byte, length)
 
-	return bc.DB.db.Update(func(tx *bolt.Tx) error {
+	return bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -269,7 +302,7 @@ func (bc *Blockchain) RemoveFromChain(hash []byte) error {
 
 	emptyHash := make([]byte, length)
 
-	return bc.DB.db.Update(func(tx *bolt.Tx) error {
+	return bc.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -328,7 +361,7 @@ func (bc *Blockchain) BlockInChain(hash []byte) (bool, error) {
 
 	found := false
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -362,7 +395,7 @@ func (bc *Blockchain) GetLocationInChain(hash []byte) (bool, []byte, []byte, err
 
 	found := false
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -398,3 +431,28 @@ func (bc *Blockchain) GetLocationInChain(hash []byte) (bool, []byte, []byte, err
 
 	return found, prevHash, nextHash, nil
 }
+
+// Writes chain record for a block with given prev and next hashes. Empty hash means there is no such block.
+// Is used to repair the chain index
+func (bc *Blockchain) PutChainRecord(hash, prevHash, nextHash []byte) error {
+	length := len(hash)
+
+	if length == 0 {
+		return NewHashEmptyDBError()
+	}
+
+	return bc.DB.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blockChainBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+
+		hashBytes := make([]byte, length*2)
+
+		copy(hashBytes[0:length], prevHash)
+		copy(hashBytes[length:], nextHash)
+
+		return b.Put(hash, hashBytes)
+	})
+}
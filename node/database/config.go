@@ -4,6 +4,7 @@ type DatabaseConfig struct {
 	DataDir        string
 	BlockchainFile string
 	NodesFile      string
+	LockTimeout    int // seconds to wait for a DB lock. default is used if 0
 }
 
 func (dbc *DatabaseConfig) IsEmpty() bool {
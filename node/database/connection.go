@@ -5,22 +5,54 @@ import (
 )
 
 type BoltDB struct {
-	db       *bolt.DB
-	lockFile string
+	db   *bolt.DB
+	name string
+	lock *fileLock
+	// transaction started by a manager. if it is set all operations are done in it
+	tx *bolt.Tx
+	// connection is shared between managers and stays opened
+	shared bool
 }
 
 func (bdb *BoltDB) Close() error {
-	if bdb.db == nil {
+	if bdb.tx != nil {
+		// this is a copy bound to a transaction. real connection is closed by a manager
 		return nil
 	}
-	bdb.db.Close()
-	bdb.db = nil
-
+	if bdb.db != nil {
+		bdb.db.Close()
+		bdb.db = nil
+	}
+	if bdb.lock != nil {
+		bdb.lock.Unlock()
+		bdb.lock = nil
+	}
 	return nil
 }
 
+// Returns copy of a connection where all operations are executed in the transaction
+func (bdb *BoltDB) withTx(tx *bolt.Tx) *BoltDB {
+	return &BoltDB{db: bdb.db, name: bdb.name, tx: tx, shared: bdb.shared}
+}
+
+// Executes writable function. Uses a manager transaction if it was started
+func (bdb *BoltDB) update(fn func(*bolt.Tx) error) error {
+	if bdb.tx != nil {
+		return fn(bdb.tx)
+	}
+	return bdb.db.Update(fn)
+}
+
+// Executes read only function. Uses a manager transaction if it was started
+func (bdb *BoltDB) view(fn func(*bolt.Tx) error) error {
+	if bdb.tx != nil {
+		return fn(bdb.tx)
+	}
+	return bdb.db.View(fn)
+}
+
 func (bdb *BoltDB) forEachInBucket(bucket string, callback ForEachKeyIteratorInterface) error {
-	return bdb.db.View(func(tx *bolt.Tx) error {
+	return bdb.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 
 		if b == nil {
@@ -50,7 +82,7 @@ func (bdb *BoltDB) forEachInBucket(bucket string, callback ForEachKeyIteratorInt
 func (bdb *BoltDB) getCountInBucket(bucket string) (int, error) {
 	count := 0
 
-	err := bdb.db.View(func(tx *bolt.Tx) error {
+	err := bdb.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 
 		if b == nil {
@@ -11,6 +11,8 @@ const DBCursorBreak = "cursorbreak"
 const DBHashNotFoundError = "hashnotfound"
 const DBHashEmptyError = "hashisemptyd"
 const DBHashError = "hashemptyd"
+const DBLockError = "lock"
+const DBLockedError = "locked"
 
 type DBError struct {
 	err  string
@@ -63,3 +65,7 @@ func NewHashEmptyDBError() error {
 func NewHashDBError(err string) error {
 	return &DBError{err, DBHashError}
 }
+
+func NewDBLockedError(status LockStatus) error {
+	return &DBError{"Database is locked. Current state: " + status.String(), DBLockedError}
+}
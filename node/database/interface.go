@@ -9,13 +9,24 @@ type DBManager interface {
 	SetLogger(logger *utils.LoggerMan) error
 	GetLockerObject() DatabaseLocker
 	SetLockerObject(lockerobj DatabaseLocker)
+	SetReadOnly(readonly bool)
 
 	InitDatabase() error
 	CheckDBExists() (bool, error)
 
+	Backup(destDir string) error
+	CheckBackup(srcDir string) error
+	Restore(srcDir string, extraFiles map[string]string) error
+
 	OpenConnection(reason string) error
 	CloseConnection() error
 	IsConnectionOpen() bool
+	OpenPersistentConnection(reason string) error
+	ClosePersistentConnection() error
+
+	View(f func() error) error
+	Update(f func() error) error
+	GetLockStatus() ([]LockStatus, error)
 
 	GetBlockchainObject() (BlockchainInterface, error)
 	GetTransactionsObject() (TranactionsInterface, error)
@@ -46,11 +57,14 @@ type BlockchainInterface interface {
 	GetTopHash() ([]byte, error)
 	SaveFirstHash(hash []byte) error
 	GetFirstHash() ([]byte, error)
+	SaveLastPrunedHash(hash []byte) error
+	GetLastPrunedHash() ([]byte, error)
 
 	GetLocationInChain(hash []byte) (bool, []byte, []byte, error)
 	BlockInChain(hash []byte) (bool, error)
 	RemoveFromChain(hash []byte) error
 	AddToChain(hash, prevHash []byte) error
+	PutChainRecord(hash, prevHash, nextHash []byte) error
 }
 
 type TranactionsInterface interface {
@@ -93,4 +107,15 @@ type NodesInterface interface {
 
 	PutNode(nodeID []byte, nodeData []byte) error
 	DeleteNode(nodeID []byte) error
+	GetNodeStats(nodeID []byte) ([]byte, error)
+	PutNodeStats(nodeID []byte, stats []byte) error
+
+	ForEachBan(callback ForEachKeyIteratorInterface) error
+	PutBan(host []byte, banData []byte) error
+	DeleteBan(host []byte) error
+
+	ForEachNodeKey(callback ForEachKeyIteratorInterface) error
+	GetNodeKey(nodeID []byte) ([]byte, error)
+	PutNodeKey(nodeID []byte, key []byte) error
+	DeleteNodeKey(nodeID []byte) error
 }
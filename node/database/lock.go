@@ -0,0 +1,208 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LockStateFree      = "free"
+	LockStateShared    = "shared"
+	LockStateExclusive = "exclusive"
+)
+
+// default time to wait for a DB lock if it is not set in the config
+const defaultLockTimeout = 30 // seconds
+
+// how often to try to get a lock again if it is held by other process
+const lockRetryInterval = 10 * time.Millisecond
+
+// OS level lock (flock, LockFileEx on windows) on a DB lock file. It coordinates access to a DB between processes.
+// Many readers can hold shared lock at same time. Only one process can hold exclusive lock.
+// The lock is released by the kernel when a process exits, so it can not stay after a crash
+type fileLock struct {
+	path string
+	file *os.File
+	mode string
+}
+
+// Info about a state of a DB lock. Exclusive holder saves own info in the lock file
+type LockStatus struct {
+	Name   string
+	File   string
+	State  string
+	PID    int
+	Reason string
+	Since  time.Time
+}
+
+func newFileLock(path string) *fileLock {
+	return &fileLock{path: path}
+}
+
+// Get a lock in shared or exclusive mode. Waits while other process holds conflicting lock
+// but not longer then timeout
+func (l *fileLock) Lock(mode string, reason string, timeout time.Duration) error {
+	if l.file != nil {
+		return NewDBError("The lock is already held", DBLockError)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := tryLockFile(file, mode == LockStateShared)
+
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+
+			status, _ := probeFileLock(l.path)
+
+			return NewDBLockedError(status)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	l.file = file
+	l.mode = mode
+
+	if mode == LockStateExclusive {
+		// only one exclusive holder can exist. it is safe to write own info for others
+		if reason == "" {
+			reason = "unknown"
+		}
+		info := strconv.Itoa(os.Getpid()) + " " + strconv.FormatInt(time.Now().Unix(), 10) + " " + reason
+
+		err = file.Truncate(0)
+
+		if err == nil {
+			_, err = file.WriteAt([]byte(info), 0)
+		}
+
+		if err != nil {
+			l.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Release a lock
+func (l *fileLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+
+	var err error
+
+	if l.mode == LockStateExclusive {
+		// clean holder info. it is not actual anymore
+		err = l.file.Truncate(0)
+	}
+
+	unlockErr := unlockFile(l.file)
+
+	l.file.Close()
+	l.file = nil
+
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+// Detects state of a lock file without waiting. Exclusive lock holder info is loaded from the file
+func probeFileLock(path string) (LockStatus, error) {
+	status := LockStatus{File: path, State: LockStateFree}
+
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+
+	if err != nil {
+		return status, err
+	}
+
+	defer file.Close()
+
+	locked, err := tryLockFile(file, false)
+
+	if err != nil {
+		return status, err
+	}
+
+	if locked {
+		unlockFile(file)
+		return status, nil
+	}
+
+	locked, err = tryLockFile(file, true)
+
+	if err != nil {
+		return status, err
+	}
+
+	if locked {
+		unlockFile(file)
+		status.State = LockStateShared
+		return status, nil
+	}
+
+	status.State = LockStateExclusive
+
+	info, err := ioutil.ReadAll(file)
+
+	if err != nil {
+		return status, err
+	}
+
+	parts := strings.SplitN(string(info), " ", 3)
+
+	if len(parts) == 3 {
+		status.PID, _ = strconv.Atoi(parts[0])
+
+		since, err := strconv.ParseInt(parts[1], 10, 64)
+
+		if err == nil {
+			status.Since = time.Unix(since, 0)
+		}
+		status.Reason = parts[2]
+	}
+
+	return status, nil
+}
+
+func (s LockStatus) String() string {
+	switch s.State {
+	case LockStateExclusive:
+		if s.PID > 0 {
+			return fmt.Sprintf("exclusive lock by process %d since %s (%s)",
+				s.PID, s.Since.Format("2006-01-02 15:04:05"), s.Reason)
+		}
+		return "exclusive lock"
+	case LockStateShared:
+		return "shared lock (readers)"
+	}
+	return "free"
+}
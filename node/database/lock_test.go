@@ -0,0 +1,66 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFileLock(t *testing.T) {
+	destroyTestDB(nil)
+
+	err := os.Mkdir(testFolderName, 0744)
+
+	assert.NoError(t, err, "Test folder not created")
+
+	defer destroyTestDB(nil)
+
+	lockfile := testFolderName + "/test.lock"
+
+	status, err := probeFileLock(lockfile)
+
+	assert.NoError(t, err, "Probe of missed lock failed")
+	assert.Equal(t, LockStateFree, status.State, "Missed lock file must be free")
+
+	// many readers at same time
+	reader1 := newFileLock(lockfile)
+	reader2 := newFileLock(lockfile)
+
+	assert.NoError(t, reader1.Lock(LockStateShared, "read1", time.Second))
+	assert.NoError(t, reader2.Lock(LockStateShared, "read2", time.Second))
+
+	status, _ = probeFileLock(lockfile)
+
+	assert.Equal(t, LockStateShared, status.State, "Lock must be shared")
+
+	// writer can not get a lock while there are readers
+	writer := newFileLock(lockfile)
+
+	err = writer.Lock(LockStateExclusive, "write", 100*time.Millisecond)
+
+	assert.Error(t, err, "Exclusive lock must fail when readers exist")
+	assert.True(t, err.(*DBError).IsKind(DBLockedError), "Wrong error kind")
+
+	reader1.Unlock()
+	reader2.Unlock()
+
+	assert.NoError(t, writer.Lock(LockStateExclusive, "write", time.Second))
+
+	status, _ = probeFileLock(lockfile)
+
+	assert.Equal(t, LockStateExclusive, status.State, "Lock must be exclusive")
+	assert.Equal(t, os.Getpid(), status.PID, "Holder PID is wrong")
+	assert.Equal(t, "write", status.Reason, "Holder reason is wrong")
+
+	err = reader1.Lock(LockStateShared, "read1", 100*time.Millisecond)
+
+	assert.Error(t, err, "Shared lock must fail when writer exists")
+
+	writer.Unlock()
+
+	status, _ = probeFileLock(lockfile)
+
+	assert.Equal(t, LockStateFree, status.State, "Lock must be free after unlock")
+}
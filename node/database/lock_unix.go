@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package database
+
+import (
+	"os"
+	"syscall"
+)
+
+// Try to get flock on a file without waiting. Returns false if other process holds conflicting lock
+func tryLockFile(file *os.File, shared bool) (bool, error) {
+	how := syscall.LOCK_EX
+
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+
+	if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package database
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+// windows locks are mandatory, so a byte far after the end of the file is locked.
+// This keeps holder info in the file readable for other processes
+func lockFileRange() *syscall.Overlapped {
+	return &syscall.Overlapped{Offset: 0, OffsetHigh: 0x7fffffff}
+}
+
+// Try to get LockFileEx on a file without waiting. Returns false if other process holds conflicting lock
+func tryLockFile(file *os.File, shared bool) (bool, error) {
+	flags := uint32(lockfileFailImmediately)
+
+	if !shared {
+		flags |= lockfileExclusiveLock
+	}
+
+	r, _, err := procLockFileEx.Call(file.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(lockFileRange())))
+
+	if r != 0 {
+		return true, nil
+	}
+
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return false, nil
+	}
+	return false, err
+}
+
+func unlockFile(file *os.File) error {
+	r, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockFileRange())))
+
+	if r == 0 {
+		return err
+	}
+	return nil
+}
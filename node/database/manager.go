@@ -6,7 +6,6 @@ import (
 	"os"
 	"path"
 
-	"strconv"
 	"sync"
 	"time"
 
@@ -30,17 +29,30 @@ type BoltDBManager struct {
 	openedConn bool
 	locker     *BoltDBLocker
 	SessID     string
+	readOnly   bool
+	reason     string
+	tx         *bolt.Tx
 }
 
+// The object is shared between all managers of a process
 type BoltDBLocker struct {
 	lockBC    *sync.Mutex
 	lockNodes *sync.Mutex
+	// write transactions of all routines go one by one. Shared connections don't use lockBC
+	lockWrite *sync.Mutex
+	// if a DB is persistent then connections are opened once and shared by all managers
+	persistent bool
+	connLock   *sync.Mutex
+	connBC     *BoltDB
+	connNodes  *BoltDB
 }
 
 func (bdm *BoltDBManager) GetLockerObject() DatabaseLocker {
 	locker := &BoltDBLocker{}
 	locker.lockBC = &sync.Mutex{}
 	locker.lockNodes = &sync.Mutex{}
+	locker.lockWrite = &sync.Mutex{}
+	locker.connLock = &sync.Mutex{}
 
 	return locker
 }
@@ -59,11 +71,115 @@ func (bdm *BoltDBManager) SetLogger(logger *utils.LoggerMan) error {
 	return nil
 }
 
+// Readonly mode means DB will be opened with shared lock. Other readers can use it at same time
+func (bdm *BoltDBManager) SetReadOnly(readonly bool) {
+	bdm.readOnly = readonly
+}
+
+// Opens DB files and keeps them opened until ClosePersistentConnection is called.
+// All managers with same locker object will use these connections. They don't wait for each other
+// as it happens with usual connections. Bolt allows many read transactions at same time
+// and serializes write transactions, so only one writer works at any moment.
+// Other processes can not open the DB while connections are opened, they must send requests to the node server
+func (bdm *BoltDBManager) OpenPersistentConnection(reason string) error {
+	bdm.locker.connLock.Lock()
+
+	if bdm.locker.persistent {
+		bdm.locker.connLock.Unlock()
+		return nil
+	}
+	bdm.locker.persistent = true
+	bdm.locker.connLock.Unlock()
+
+	bdm.reason = reason
+
+	// open now to be sure DB is fine
+	for _, name := range []string{ClassNameBlockchain, ClassNameNodes} {
+		_, err := bdm.getPersistentConnection(name, false)
+
+		if err != nil {
+			bdm.ClosePersistentConnection()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Closes shared connections. After this managers open DB for each connection again
+func (bdm *BoltDBManager) ClosePersistentConnection() error {
+	bdm.locker.connLock.Lock()
+	defer bdm.locker.connLock.Unlock()
+
+	if !bdm.locker.persistent {
+		return nil
+	}
+	bdm.locker.persistent = false
+
+	bdm.locker.closeSharedConnections()
+
+	return nil
+}
+
+// Executes a function in one read transaction. All DB objects created inside the function
+// see same state of the blockchain DB, even if other routine writes to it at same time
+func (bdm *BoltDBManager) View(f func() error) error {
+	if bdm.tx != nil {
+		// already in transaction
+		return f()
+	}
+
+	conn, err := bdm.getConnectionForObject(ClassNameBlockchain)
+
+	if err != nil {
+		return err
+	}
+
+	return conn.db.View(func(tx *bolt.Tx) error {
+		bdm.tx = tx
+		defer func() { bdm.tx = nil }()
+
+		return f()
+	})
+}
+
+// Executes a function in one write transaction. All changes done by DB objects created inside
+// the function are commited together. If the function returns error then nothing is saved.
+// Only one write transaction can be active in a process. Checks done inside the function
+// can not be changed by other routine before the changes are saved
+func (bdm *BoltDBManager) Update(f func() error) error {
+	if bdm.tx != nil {
+		if !bdm.tx.Writable() {
+			return NewDBError("Can not start write transaction inside read transaction", DBLockError)
+		}
+		return f()
+	}
+
+	conn, err := bdm.getConnectionForObject(ClassNameBlockchain)
+
+	if err != nil {
+		return err
+	}
+
+	if bdm.locker != nil {
+		bdm.locker.lockWrite.Lock()
+		defer bdm.locker.lockWrite.Unlock()
+	}
+
+	return conn.db.Update(func(tx *bolt.Tx) error {
+		bdm.tx = tx
+		defer func() { bdm.tx = nil }()
+
+		return f()
+	})
+}
+
 func (bdm *BoltDBManager) OpenConnection(reason string) error {
 	//bdm.Logger.Trace.Println("open connection for " + reason)
 	if bdm.openedConn {
 		return nil
 	}
+	bdm.reason = reason
 	// real connection will be done when first object is created
 	bdm.openedConn = true
 
@@ -77,16 +193,19 @@ func (bdm *BoltDBManager) CloseConnection() error {
 		return nil
 	}
 
-	if bdm.connBC != nil {
-		bdm.connBC.Close()
-		bdm.unLockDB(bdm.connBC.lockFile)
-		bdm.connBC = nil
-	}
-	if bdm.connNodes != nil {
-		bdm.connNodes.Close()
-		bdm.unLockDB(bdm.connNodes.lockFile)
-		bdm.connNodes = nil
+	for _, conn := range []*BoltDB{bdm.connBC, bdm.connNodes} {
+		if conn == nil {
+			continue
+		}
+		if conn.shared {
+			// shared connection stays opened
+			continue
+		}
+		conn.Close()
+		bdm.getProcessLocker(conn.name).Unlock()
 	}
+	bdm.connBC = nil
+	bdm.connNodes = nil
 
 	bdm.openedConn = false
 	return nil
@@ -208,7 +327,7 @@ func (bdm *BoltDBManager) GetBlockchainObject() (BlockchainInterface, error) {
 	}
 
 	bc := Blockchain{}
-	bc.DB = conn
+	bc.DB = bdm.bindTx(conn)
 
 	return &bc, nil
 }
@@ -222,7 +341,7 @@ func (bdm *BoltDBManager) GetTransactionsObject() (TranactionsInterface, error)
 	}
 
 	txs := Tranactions{}
-	txs.DB = conn
+	txs.DB = bdm.bindTx(conn)
 
 	return &txs, nil
 }
@@ -236,7 +355,7 @@ func (bdm *BoltDBManager) GetUnapprovedTransactionsObject() (UnapprovedTransacti
 	}
 
 	uos := UnapprovedTransactions{}
-	uos.DB = conn
+	uos.DB = bdm.bindTx(conn)
 
 	return &uos, nil
 }
@@ -250,7 +369,7 @@ func (bdm *BoltDBManager) GetUnspentOutputsObject() (UnspentOutputsInterface, er
 	}
 
 	uts := UnspentOutputs{}
-	uts.DB = conn
+	uts.DB = bdm.bindTx(conn)
 
 	return &uts, nil
 }
@@ -289,162 +408,210 @@ func (bdm *BoltDBManager) getConnectionForObjectWithCheck(name string, ignoremis
 		return bdm.connNodes, nil
 	}
 
-	// create new connection
-	boltdbfile, err := bdm.getDBFileForObject(name)
+	var boltDB *BoltDB
+	var err error
 
-	if err != nil {
-		return nil, err
-	}
-
-	if bdm.dbExists(boltdbfile) == false && !ignoremissed {
-		return nil, errors.New(fmt.Sprintf("Database file %s not found", boltdbfile))
-	}
+	if bdm.isPersistent() {
+		boltDB, err = bdm.getPersistentConnection(name, ignoremissed)
+	} else {
+		// parallel routines of same process must not use the DB at same time
+		locker := bdm.getProcessLocker(name)
+		locker.Lock()
 
-	err = bdm.lockDB(name, bdm.SessID)
+		boltDB, err = bdm.openDB(name, ignoremissed)
 
-	if err != nil {
-		return nil, err
+		if err != nil {
+			locker.Unlock()
+		}
 	}
 
-	db, err := bolt.Open(boltdbfile, 0600, &bolt.Options{Timeout: 10 * time.Second})
-
 	if err != nil {
-		bdm.Logger.Trace.Printf("Error opening DB %s for %s", err.Error(), name)
 		return nil, err
 	}
 
-	// if success create object and assign connection
-	boltDB := BoltDB{db, name}
-
 	if bdm.isBCDB(name) {
-		bdm.connBC = &boltDB
+		bdm.connBC = boltDB
 	}
 
 	if bdm.isNodesDB(name) {
-		bdm.connNodes = &boltDB
+		bdm.connNodes = boltDB
 	}
 
-	return &boltDB, nil
+	return boltDB, nil
 }
 
-// Creates a lock file for DB access. We need this to controll parallel access to the DB
-func (bdm *BoltDBManager) lockDB(name string, locksess string) error {
-	if locksess == "" {
-		locksess = utils.RandString(5)
-		//bdm.Logger.Trace.Println(string(debug.Stack()))
-	}
+// Returns shared connection. Opens it if it is not yet opened
+func (bdm *BoltDBManager) getPersistentConnection(name string, ignoremissed bool) (*BoltDB, error) {
+	bdm.locker.connLock.Lock()
+	defer bdm.locker.connLock.Unlock()
 
-	var locker *sync.Mutex
+	var boltDB *BoltDB
 
-	if bdm.isNodesDB(name) {
-		locker = bdm.locker.lockNodes
+	if bdm.isBCDB(name) {
+		boltDB = bdm.locker.connBC
 	} else {
-		locker = bdm.locker.lockBC
+		boltDB = bdm.locker.connNodes
 	}
 
-	locker.Lock()
+	if boltDB == nil {
+		var err error
+		boltDB, err = bdm.openDB(name, ignoremissed)
 
-	lockfile, err := bdm.getDBLockFileForObject(name)
+		if err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		locker.Unlock()
-		return err
+		boltDB.shared = true
+
+		if bdm.isBCDB(name) {
+			bdm.locker.connBC = boltDB
+		} else {
+			bdm.locker.connNodes = boltDB
+		}
 	}
 
-	i := 0
+	return boltDB, nil
+}
 
-	info, err := os.Stat(lockfile)
+// Must be called when connLock is locked
+func (l *BoltDBLocker) closeSharedConnections() {
+	if l.connBC != nil {
+		l.connBC.Close()
+		l.connBC = nil
+	}
+	if l.connNodes != nil {
+		l.connNodes.Close()
+		l.connNodes = nil
+	}
+}
 
-	if err == nil {
-		t := time.Since(info.ModTime())
+func (bdm *BoltDBManager) isPersistent() bool {
+	bdm.locker.connLock.Lock()
+	defer bdm.locker.connLock.Unlock()
 
-		// this is for case when something goes very wrong , process fails and lock is not removed
-		if t.Minutes() > 60 {
-			os.Remove(lockfile)
-		}
+	return bdm.locker.persistent
+}
+
+// Locks DB file and opens it
+func (bdm *BoltDBManager) openDB(name string, ignoremissed bool) (*BoltDB, error) {
+	boltdbfile, err := bdm.getDBFileForObject(name)
+
+	if err != nil {
+		return nil, err
 	}
 
-	for bdm.dbExists(lockfile) != false {
+	if bdm.dbExists(boltdbfile) == false && !ignoremissed {
+		return nil, errors.New(fmt.Sprintf("Database file %s not found", boltdbfile))
+	}
 
-		if i > 5000 {
-			time.Sleep(1 * time.Second)
-		} else {
-			time.Sleep(50 * time.Millisecond)
-		}
+	lock, err := bdm.lockDB(name)
+
+	if err != nil {
+		return nil, err
+	}
 
-		i++
+	// other process could create the file while we waited for the lock
+	isnew := !bdm.dbExists(boltdbfile)
 
-		if i > 10000 {
-			locker.Unlock()
-			bdm.Logger.Trace.Println("too long lock. return with error")
-			return errors.New("Can not open DB. Lock failed after many attempts")
-		}
+	db, err := bolt.Open(boltdbfile, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: bdm.readOnly})
+
+	if err != nil {
+		lock.Unlock()
+		bdm.Logger.Trace.Printf("Error opening DB %s for %s", err.Error(), name)
+		return nil, err
 	}
 
-	file, err := os.Create(lockfile)
+	err = bdm.checkSchema(db, name, boltdbfile, isnew)
 
 	if err != nil {
-		locker.Unlock()
-		return err
+		db.Close()
+		lock.Unlock()
+		return nil, err
 	}
 
-	defer file.Close()
+	return &BoltDB{db: db, name: name, lock: lock}, nil
+}
 
-	starttime := time.Now().UTC().UnixNano()
+// If a manager transaction is started, returns connection bound to it
+func (bdm *BoltDBManager) bindTx(conn *BoltDB) *BoltDB {
+	if bdm.tx == nil {
+		return conn
+	}
+	return conn.withTx(bdm.tx)
+}
 
-	_, err = file.WriteString(strconv.Itoa(int(starttime)))
+// Gets OS level lock on a DB lock file. Readonly manager gets shared lock, so many readers
+// can work in parallel. Otherwise exclusive lock is used. Lock is released automatically
+// if a process dies, so stale locks are not possible
+func (bdm *BoltDBManager) lockDB(name string) (*fileLock, error) {
+	lockfile, err := bdm.getDBLockFileForObject(name)
 
 	if err != nil {
-		locker.Unlock()
-		return err
+		return nil, err
 	}
 
-	file.WriteString(" " + locksess)
+	mode := LockStateExclusive
+
+	if bdm.readOnly {
+		mode = LockStateShared
+	}
 
-	file.Sync() // flush to disk
+	reason := bdm.reason
 
-	return nil
-}
+	if bdm.SessID != "" {
+		reason = reason + " " + bdm.SessID
+	}
 
-// Removes DB lock file
-func (bdm *BoltDBManager) unLockDB(name string) {
+	lock := newFileLock(lockfile)
 
-	var locker *sync.Mutex
+	err = lock.Lock(mode, reason, bdm.getLockTimeout())
 
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func (bdm *BoltDBManager) getProcessLocker(name string) *sync.Mutex {
 	if bdm.isNodesDB(name) {
-		locker = bdm.locker.lockNodes
-	} else {
-		locker = bdm.locker.lockBC
+		return bdm.locker.lockNodes
 	}
+	return bdm.locker.lockBC
+}
 
-	lockfile, err := bdm.getDBLockFileForObject(name)
+func (bdm *BoltDBManager) getLockTimeout() time.Duration {
+	timeout := bdm.Config.LockTimeout
 
-	if err != nil {
-		locker.Unlock()
-		return
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
 	}
-	if bdm.dbExists(lockfile) != false {
-		/*
-			lockinfobytes, err := ioutil.ReadFile(lockfile)
+	return time.Duration(timeout) * time.Second
+}
+
+// Returns info about locks of all DB files. It doesn't wait for a lock
+func (bdm *BoltDBManager) GetLockStatus() ([]LockStatus, error) {
+	list := []LockStatus{}
 
-			if err == nil {
-				lockinfo := string(lockinfobytes)
+	for _, name := range []string{ClassNameBlockchain, ClassNameNodes} {
+		lockfile, err := bdm.getDBLockFileForObject(name)
 
-				parts := strings.Split(lockinfo, " ")
+		if err != nil {
+			return nil, err
+		}
+
+		status, err := probeFileLock(lockfile)
 
-				starttime, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		status.Name = name
 
-				if err == nil {
-					duration := time.Since(time.Unix(0, int64(starttime)))
-					ms := duration.Nanoseconds() / int64(time.Millisecond)
-					bdm.Logger.Trace.Printf("UnLocked %s after %d ms , %s", name, ms, parts[1])
-				}
-			}
-		*/
-		os.Remove(lockfile)
+		list = append(list, status)
 	}
-	locker.Unlock()
+	return list, nil
 }
+
 func (bdm *BoltDBManager) getDBFileForObject(name string) (string, error) {
 	switch name {
 	case ClassNameNodes:
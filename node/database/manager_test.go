@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestManagerTransactions(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	hash1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	hash2 := []byte{0, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	// failed transaction must not save anything
+	err = man.Update(func() error {
+		bc, err := man.GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		err = bc.PutBlock(hash1, []byte("block1"))
+
+		if err != nil {
+			return err
+		}
+		return errors.New("Something went wrong")
+	})
+
+	assert.Error(t, err, "Error expected from transaction")
+
+	bc, _ := man.GetBlockchainObject()
+
+	exists, err := bc.CheckBlockExists(hash1)
+
+	assert.NoError(t, err, "Check block1 exists")
+	assert.False(t, exists, "Block1 must not be saved")
+
+	err = man.Update(func() error {
+		bc, err := man.GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		err = bc.PutBlock(hash1, []byte("block1"))
+
+		if err != nil {
+			return err
+		}
+		return bc.PutBlock(hash2, []byte("block2"))
+	})
+
+	assert.NoError(t, err, "Transaction failed")
+
+	err = man.View(func() error {
+		bc, err := man.GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range [][]byte{hash1, hash2} {
+			exists, err := bc.CheckBlockExists(hash)
+
+			if err != nil {
+				return err
+			}
+			assert.True(t, exists, "Block must be saved")
+		}
+
+		// write is not allowed in read transaction
+		assert.Error(t, man.Update(func() error { return nil }))
+
+		return nil
+	})
+
+	assert.NoError(t, err, "View failed")
+}
+
+func TestManagerPersistentConnection(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(nil)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	man.CloseConnection()
+
+	err = man.OpenPersistentConnection("testing")
+
+	assert.NoError(t, err, "Can not open persistent connection")
+
+	defer man.ClosePersistentConnection()
+
+	// other manager uses same connection and doesn't wait for the first one
+	man2 := &BoltDBManager{}
+	man2.SetLockerObject(man.locker)
+	man2.SetLogger(man.Logger)
+	man2.SetConfig(man.Config)
+
+	man.OpenConnection("testing")
+	man2.OpenConnection("testing")
+
+	bc1, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Can not get BC object")
+
+	bc2, err := man2.GetBlockchainObject()
+
+	assert.NoError(t, err, "Can not get BC object for second manager")
+
+	hash := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+
+	assert.NoError(t, bc1.PutBlock(hash, []byte("block")))
+
+	exists, err := bc2.CheckBlockExists(hash)
+
+	assert.NoError(t, err, "Check block exists")
+	assert.True(t, exists, "Block must be visible for second manager")
+
+	man.CloseConnection()
+	man2.CloseConnection()
+
+	status, err := man.GetLockStatus()
+
+	assert.NoError(t, err, "Can not get lock status")
+	assert.Equal(t, LockStateExclusive, status[0].State, "Persistent connection must hold a lock")
+}
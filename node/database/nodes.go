@@ -2,18 +2,41 @@ package database
 
 import (
 	"github.com/boltdb/bolt"
+
+	"github.com/gelembjuk/democoin/lib/utils"
 )
 
 const nodesBucket = "nodes"
+const bannedNodesBucket = "bannednodes"
+const nodeKeysBucket = "nodekeys"
+const nodeStatsBucket = "nodestats"
 
 type Nodes struct {
 	DB *BoltDB
 }
 
 func (ns *Nodes) InitDB() error {
-	err := ns.DB.db.Update(func(tx *bolt.Tx) error {
+	err := ns.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(nodesBucket))
 
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(bannedNodesBucket))
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(nodeKeysBucket))
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(nodeStatsBucket))
+
 		if err != nil {
 			return err
 		}
@@ -38,7 +61,7 @@ func (ns *Nodes) GetCount() (int, error) {
 
 // Save node info
 func (ns *Nodes) PutNode(nodeID []byte, nodeData []byte) error {
-	return ns.DB.db.Update(func(txDB *bolt.Tx) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(nodesBucket))
 
 		if b == nil {
@@ -48,9 +71,137 @@ func (ns *Nodes) PutNode(nodeID []byte, nodeData []byte) error {
 	})
 }
 
+// Deletes a node and its stats
 func (ns *Nodes) DeleteNode(nodeID []byte) error {
-	return ns.DB.db.Update(func(txDB *bolt.Tx) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(nodesBucket))
+		bs := txDB.Bucket([]byte(nodeStatsBucket))
+
+		if b == nil || bs == nil {
+			return NewDBIsNotReadyError()
+		}
+
+		err := b.Delete(nodeID)
+
+		if err != nil {
+			return err
+		}
+		return bs.Delete(nodeID)
+	})
+}
+
+// Returns stats of a node: when it was seen, failures etc. Empty if there are no stats
+func (ns *Nodes) GetNodeStats(nodeID []byte) ([]byte, error) {
+	var stats []byte
+
+	err := ns.DB.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(nodeStatsBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+
+		stats = b.Get(nodeID)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stats) > 0 {
+		stats = utils.CopyBytes(stats)
+	}
+
+	return stats, nil
+}
+
+// Save stats of a node
+func (ns *Nodes) PutNodeStats(nodeID []byte, stats []byte) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
+		b := txDB.Bucket([]byte(nodeStatsBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Put(nodeID, stats)
+	})
+}
+
+// retrns banned nodes iterator. Key is a host, value is ban info
+func (ns *Nodes) ForEachBan(callback ForEachKeyIteratorInterface) error {
+	return ns.DB.forEachInBucket(bannedNodesBucket, callback)
+}
+
+// Save ban of a host
+func (ns *Nodes) PutBan(host []byte, banData []byte) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
+		b := txDB.Bucket([]byte(bannedNodesBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Put(host, banData)
+	})
+}
+
+func (ns *Nodes) DeleteBan(host []byte) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
+		b := txDB.Bucket([]byte(bannedNodesBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Delete(host)
+	})
+}
+
+// retrns pinned keys iterator. Key is a node address, value is a public key of the node
+func (ns *Nodes) ForEachNodeKey(callback ForEachKeyIteratorInterface) error {
+	return ns.DB.forEachInBucket(nodeKeysBucket, callback)
+}
+
+// Returns pinned public key of a node. Empty if there is no key for the node
+func (ns *Nodes) GetNodeKey(nodeID []byte) ([]byte, error) {
+	var key []byte
+
+	err := ns.DB.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(nodeKeysBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+
+		key = b.Get(nodeID)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) > 0 {
+		key = utils.CopyBytes(key)
+	}
+
+	return key, nil
+}
+
+// Save public key of a node
+func (ns *Nodes) PutNodeKey(nodeID []byte, key []byte) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
+		b := txDB.Bucket([]byte(nodeKeysBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Put(nodeID, key)
+	})
+}
+
+func (ns *Nodes) DeleteNodeKey(nodeID []byte) error {
+	return ns.DB.update(func(txDB *bolt.Tx) error {
+		b := txDB.Bucket([]byte(nodeKeysBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
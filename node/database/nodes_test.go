@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNodesBans(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	nddb, err := man.GetNodesObject()
+
+	assert.NoError(t, err, "Can not get nodes object")
+
+	err = nddb.PutBan([]byte("10.0.0.1"), []byte("ban1"))
+
+	assert.NoError(t, err, "Can not save ban")
+
+	err = nddb.PutBan([]byte("10.0.0.2"), []byte("ban2"))
+
+	assert.NoError(t, err, "Can not save ban")
+
+	err = nddb.DeleteBan([]byte("10.0.0.1"))
+
+	assert.NoError(t, err, "Can not delete ban")
+
+	bans := map[string]string{}
+
+	err = nddb.ForEachBan(func(k, v []byte) error {
+		bans[string(k)] = string(v)
+		return nil
+	})
+
+	assert.NoError(t, err, "Can not read bans")
+	assert.Equal(t, map[string]string{"10.0.0.2": "ban2"}, bans, "Wrong list of bans")
+
+	count, err := nddb.GetCount()
+
+	assert.NoError(t, err, "Can not count nodes")
+	assert.Equal(t, 0, count, "Bans must not be in the list of nodes")
+}
+
+func TestNodesStats(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	nddb, err := man.GetNodesObject()
+
+	assert.NoError(t, err, "Can not get nodes object")
+
+	node := []byte("10.0.0.1:20000")
+
+	err = nddb.PutNode(node, node)
+
+	assert.NoError(t, err, "Can not save node")
+
+	stats, err := nddb.GetNodeStats(node)
+
+	assert.NoError(t, err, "Can not read stats")
+	assert.Empty(t, stats, "New node must not have stats")
+
+	err = nddb.PutNodeStats(node, []byte("stats"))
+
+	assert.NoError(t, err, "Can not save stats")
+
+	stats, err = nddb.GetNodeStats(node)
+
+	assert.NoError(t, err, "Can not read stats")
+	assert.Equal(t, []byte("stats"), stats, "Wrong stats")
+
+	err = nddb.DeleteNode(node)
+
+	assert.NoError(t, err, "Can not delete node")
+
+	stats, err = nddb.GetNodeStats(node)
+
+	assert.NoError(t, err, "Can not read stats")
+	assert.Empty(t, stats, "Stats must be deleted with the node")
+}
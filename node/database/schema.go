@@ -0,0 +1,178 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+const metaBucket = "meta"
+const metaSchemaVersionKey = "schemaversion"
+
+// DBs created before the schema version was stored have this version
+const initialSchemaVersion = 1
+
+// Converts data of a DB file from previous version to Version. Executed in one transaction
+type schemaMigration struct {
+	Version     int
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+}
+
+// Migrations for every DB file. When format of buckets or stored values changes
+// new migration must be added here with next version number
+var schemaMigrations = map[string][]schemaMigration{
+	ClassNameBlockchain: []schemaMigration{},
+	ClassNameNodes: []schemaMigration{
+		schemaMigration{
+			Version:     2,
+			Description: "bucket of banned nodes",
+			Migrate: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists([]byte(bannedNodesBucket))
+				return err
+			}},
+		schemaMigration{
+			Version:     3,
+			Description: "bucket of pinned node keys",
+			Migrate: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists([]byte(nodeKeysBucket))
+				return err
+			}},
+		schemaMigration{
+			Version:     4,
+			Description: "bucket of nodes stats",
+			Migrate: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists([]byte(nodeStatsBucket))
+				return err
+			}},
+	},
+}
+
+// Returns version of DB schema supported by this code
+func getCurrentSchemaVersion(name string) int {
+	version := initialSchemaVersion
+
+	for _, m := range schemaMigrations[name] {
+		if m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+// Returns schema version saved in a DB. False if the version is not saved yet
+func readSchemaVersion(tx *bolt.Tx) (int, bool) {
+	b := tx.Bucket([]byte(metaBucket))
+
+	if b == nil {
+		return initialSchemaVersion, false
+	}
+
+	data := b.Get([]byte(metaSchemaVersionKey))
+
+	if len(data) != 4 {
+		return initialSchemaVersion, false
+	}
+
+	return int(binary.BigEndian.Uint32(data)), true
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(version))
+
+	return b.Put([]byte(metaSchemaVersionKey), data)
+}
+
+// Checks schema version of opened DB file. New file gets current version.
+// If the version is older then the file is copied to a backup and migrations are executed one by one.
+// DB of newer version or DB that needs migration in read only mode can not be used
+func (bdm *BoltDBManager) checkSchema(db *bolt.DB, name string, dbfile string, isnew bool) error {
+	current := getCurrentSchemaVersion(name)
+
+	if isnew {
+		if bdm.readOnly {
+			return nil
+		}
+		return db.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, current)
+		})
+	}
+
+	version := 0
+	saved := false
+
+	db.View(func(tx *bolt.Tx) error {
+		version, saved = readSchemaVersion(tx)
+		return nil
+	})
+
+	if version > current {
+		return errors.New(fmt.Sprintf("Database %s has schema version %d. Supported version is %d. Update the node software", dbfile, version, current))
+	}
+
+	if bdm.readOnly {
+		if version < current {
+			return errors.New(fmt.Sprintf("Database %s has old schema version %d. Run any command that changes data to upgrade it to %d", dbfile, version, current))
+		}
+		return nil
+	}
+
+	if version == current {
+		if saved {
+			return nil
+		}
+		return db.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, current)
+		})
+	}
+
+	backupfile := fmt.Sprintf("%s.v%d.bak", dbfile, version)
+
+	bdm.Logger.Trace.Printf("Migrate DB %s from version %d to %d. Backup to %s", dbfile, version, current, backupfile)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backupfile, 0600)
+	})
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can not backup DB before migration: %s", err.Error()))
+	}
+
+	for _, m := range schemaMigrations[name] {
+		if m.Version <= version {
+			continue
+		}
+
+		if m.Version != version+1 {
+			return errors.New(fmt.Sprintf("Migration of DB %s to version %d is not found", dbfile, version+1))
+		}
+
+		bdm.Logger.Trace.Printf("Migrate DB %s to version %d: %s", dbfile, m.Version, m.Description)
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			err := m.Migrate(tx)
+
+			if err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx, m.Version)
+		})
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Migration of DB %s to version %d failed: %s. Backup is in %s", dbfile, m.Version, err.Error(), backupfile))
+		}
+
+		version = m.Version
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	assert "github.com/stretchr/testify/require"
+)
+
+func getTestSchemaVersion(man *BoltDBManager) (int, bool, error) {
+	conn, err := man.getConnectionForObject(ClassNameBlockchain)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	version := 0
+	saved := false
+
+	err = conn.view(func(tx *bolt.Tx) error {
+		version, saved = readSchemaVersion(tx)
+		return nil
+	})
+
+	return version, saved, err
+}
+
+func TestSchemaMigration(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	version, saved, err := getTestSchemaVersion(man)
+
+	assert.NoError(t, err, "Can not read schema version")
+	assert.True(t, saved, "Schema version must be saved in new DB")
+	assert.Equal(t, initialSchemaVersion, version, "Wrong schema version of new DB")
+
+	man.CloseConnection()
+
+	migrations := schemaMigrations[ClassNameBlockchain]
+
+	defer func() { schemaMigrations[ClassNameBlockchain] = migrations }()
+
+	schemaMigrations[ClassNameBlockchain] = append(migrations, schemaMigration{
+		Version:     initialSchemaVersion + 1,
+		Description: "test bucket",
+		Migrate: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("testbucket"))
+			return err
+		}})
+
+	// read only connection can not migrate
+	man.SetReadOnly(true)
+	man.OpenConnection("testing")
+
+	_, err = man.GetBlockchainObject()
+
+	assert.Error(t, err, "Old DB must not be opened in read only mode")
+
+	man.CloseConnection()
+	man.SetReadOnly(false)
+	man.OpenConnection("testing")
+
+	version, _, err = getTestSchemaVersion(man)
+
+	assert.NoError(t, err, "Migration failed")
+	assert.Equal(t, initialSchemaVersion+1, version, "Schema version is not updated")
+
+	conn, _ := man.getConnectionForObject(ClassNameBlockchain)
+
+	conn.view(func(tx *bolt.Tx) error {
+		assert.NotNil(t, tx.Bucket([]byte("testbucket")), "Migration was not executed")
+		return nil
+	})
+
+	dbfile, _ := man.getDBFileForObject(ClassNameBlockchain)
+
+	_, err = os.Stat(dbfile + ".v1.bak")
+
+	assert.NoError(t, err, "Backup is not created before migration")
+
+	man.CloseConnection()
+
+	// DB of newer version can not be opened
+	schemaMigrations[ClassNameBlockchain] = migrations
+
+	man.OpenConnection("testing")
+
+	_, err = man.GetBlockchainObject()
+
+	assert.Error(t, err, "DB of newer version must not be opened")
+}
@@ -13,7 +13,7 @@ type Tranactions struct {
 
 // Init database
 func (txs *Tranactions) InitDB() error {
-	err := txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err := txs.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(transactionsBucket))
 
 		if err != nil {
@@ -24,7 +24,7 @@ func (txs *Tranactions) InitDB() error {
 	if err != nil {
 		return err
 	}
-	err = txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err = txs.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(transactionsOutputsBucket))
 
 		if err != nil {
@@ -38,7 +38,7 @@ func (txs *Tranactions) InitDB() error {
 	return nil
 }
 func (txs *Tranactions) TruncateDB() error {
-	err := txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err := txs.DB.update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket([]byte(transactionsBucket))
 
 		if err != nil && err != bolt.ErrBucketNotFound {
@@ -55,7 +55,7 @@ func (txs *Tranactions) TruncateDB() error {
 	if err != nil {
 		return err
 	}
-	err = txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err = txs.DB.update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket([]byte(transactionsOutputsBucket))
 
 		if err != nil && err != bolt.ErrBucketNotFound {
@@ -77,7 +77,7 @@ func (txs *Tranactions) TruncateDB() error {
 
 // Save link between TX and block hash
 func (txs *Tranactions) PutTXToBlockLink(txID []byte, blockHash []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
@@ -91,7 +91,7 @@ func (txs *Tranactions) PutTXToBlockLink(txID []byte, blockHash []byte) error {
 func (txs *Tranactions) GetBlockHashForTX(txID []byte) ([]byte, error) {
 	var blockHash []byte
 
-	err := txs.DB.db.View(func(txDB *bolt.Tx) error {
+	err := txs.DB.view(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
@@ -110,7 +110,7 @@ func (txs *Tranactions) GetBlockHashForTX(txID []byte) ([]byte, error) {
 
 // Delete link between TX and a block hash
 func (txs *Tranactions) DeleteTXToBlockLink(txID []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
@@ -122,7 +122,7 @@ func (txs *Tranactions) DeleteTXToBlockLink(txID []byte) error {
 
 // Save spent outputs for TX
 func (txs *Tranactions) PutTXSpentOutputs(txID []byte, outputs []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
@@ -136,7 +136,7 @@ func (txs *Tranactions) PutTXSpentOutputs(txID []byte, outputs []byte) error {
 func (txs *Tranactions) GetTXSpentOutputs(txID []byte) ([]byte, error) {
 	var outputsData []byte
 
-	err := txs.DB.db.View(func(txDB *bolt.Tx) error {
+	err := txs.DB.view(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
@@ -155,7 +155,7 @@ func (txs *Tranactions) GetTXSpentOutputs(txID []byte) ([]byte, error) {
 
 // Delete info about spent outputs for TX
 func (txs *Tranactions) DeleteTXSpentData(txID []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB *bolt.Tx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
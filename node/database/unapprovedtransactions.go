@@ -11,7 +11,7 @@ type UnapprovedTransactions struct {
 }
 
 func (uts *UnapprovedTransactions) InitDB() error {
-	err := uts.DB.db.Update(func(tx *bolt.Tx) error {
+	err := uts.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(unapprovedTransactionsBucket))
 
 		if err != nil {
@@ -37,7 +37,7 @@ func (uts *UnapprovedTransactions) GetCount() (int, error) {
 }
 
 func (uts *UnapprovedTransactions) TruncateDB() error {
-	err := uts.DB.db.Update(func(tx *bolt.Tx) error {
+	err := uts.DB.update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket([]byte(unapprovedTransactionsBucket))
 
 		if err != nil && err != bolt.ErrBucketNotFound {
@@ -61,7 +61,7 @@ func (uts *UnapprovedTransactions) TruncateDB() error {
 func (uts *UnapprovedTransactions) GetTransaction(txID []byte) ([]byte, error) {
 	var txBytes []byte
 
-	err := uts.DB.db.View(func(tx *bolt.Tx) error {
+	err := uts.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
@@ -80,7 +80,7 @@ func (uts *UnapprovedTransactions) GetTransaction(txID []byte) ([]byte, error) {
 
 // Add transaction record
 func (uts *UnapprovedTransactions) PutTransaction(txID []byte, txdata []byte) error {
-	return uts.DB.db.Update(func(tx *bolt.Tx) error {
+	return uts.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
@@ -93,7 +93,7 @@ func (uts *UnapprovedTransactions) PutTransaction(txID []byte, txdata []byte) er
 
 // delete transation from DB
 func (uts *UnapprovedTransactions) DeleteTransaction(txID []byte) error {
-	return uts.DB.db.Update(func(tx *bolt.Tx) error {
+	return uts.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
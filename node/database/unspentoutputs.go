@@ -11,7 +11,7 @@ type UnspentOutputs struct {
 }
 
 func (uos *UnspentOutputs) InitDB() error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(unspentTransactionsBucket))
 		return err
 	})
@@ -28,7 +28,7 @@ func (uos *UnspentOutputs) GetCount() (int, error) {
 }
 
 func (uos *UnspentOutputs) TruncateDB() error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket([]byte(unspentTransactionsBucket))
 
 		if err != nil {
@@ -43,7 +43,7 @@ func (uos *UnspentOutputs) TruncateDB() error {
 func (uos *UnspentOutputs) GetDataForTransaction(txID []byte) ([]byte, error) {
 	var txData []byte
 
-	err := uos.DB.db.View(func(tx *bolt.Tx) error {
+	err := uos.DB.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
@@ -61,7 +61,7 @@ func (uos *UnspentOutputs) GetDataForTransaction(txID []byte) ([]byte, error) {
 }
 
 func (uos *UnspentOutputs) DeleteDataForTransaction(txID []byte) error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
@@ -72,7 +72,7 @@ func (uos *UnspentOutputs) DeleteDataForTransaction(txID []byte) error {
 	})
 }
 func (uos *UnspentOutputs) PutDataForTransaction(txID []byte, txData []byte) error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
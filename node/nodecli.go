@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gelembjuk/democoin/lib/net"
 	"github.com/gelembjuk/democoin/lib/nodeclient"
@@ -12,8 +16,12 @@ import (
 	"github.com/gelembjuk/democoin/node/config"
 	"github.com/gelembjuk/democoin/node/nodemanager"
 	"github.com/gelembjuk/democoin/node/server"
+	"github.com/gelembjuk/democoin/node/structures"
 )
 
+// Number of blocks requested from the node server at once to print the chain
+const printChainPageSize = 100
+
 type NodeCLI struct {
 	Input              config.AppInput
 	Logger             *utils.LoggerMan
@@ -77,13 +85,23 @@ func (c *NodeCLI) CreateNode() {
 
 	node.DBConn.SetConfig(c.Input.Database)
 
+	// commands that only read data don't need to block other processes
+	node.DBConn.SetReadOnly(c.isReadOnlyCommand())
+
 	node.DBConn.Init()
 
 	node.Logger = c.Logger
 	node.MinterAddress = c.Input.MinterAddress
+	node.PruneKeep = c.Input.Prune
+	node.BanTime = c.Input.BanTime
 
 	node.Init()
-	node.InitNodes(c.Input.Nodes, false)
+	node.NodeNet.SetSeeds(c.Input.Seeds, &c.Input.SeedNodes)
+
+	if c.AlreadyRunningPort == 0 {
+		// the server keeps the DB opened. commands are sent to it in this case
+		node.InitNodes(c.Input.Nodes, false)
+	}
 
 	node.NodeClient.SetAuthStr(c.NodeAuthStr)
 
@@ -98,8 +116,15 @@ func (c NodeCLI) isInteractiveMode() bool {
 		"createblockchain",
 		"initblockchain",
 		"printchain",
+		"exportchain",
+		"importchain",
+		"dumputxoset",
+		"loadutxoset",
+		"backup",
+		"restore",
 		"makeblock",
 		"reindexcache",
+		"verifychain",
 		"send",
 		"getbalance",
 		"getbalances",
@@ -112,14 +137,103 @@ func (c NodeCLI) isInteractiveMode() bool {
 		"addrhistory",
 		"showunspent",
 		"shownodes",
+		"seednodes",
+		"addnode",
+		"removenode",
+		"listbanned",
+		"ban",
+		"unban",
+		"nodekey",
+		"listpinned",
+		"pinnode",
+		"unpinnode",
+		"lockstatus"}
+
+	for _, cm := range commands {
+		if cm == c.Command {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+* Detects if a command only reads data from the DB. Such commands can work in parallel.
+* They still wait while other process holds exclusive lock, up to the lock timeout.
+* When the node server works they are sent to it
+ */
+func (c NodeCLI) isReadOnlyCommand() bool {
+	commands := []string{
+		"printchain",
+		"exportchain",
+		"dumputxoset",
+		"backup",
+		"getbalance",
+		"getbalances",
+		"addrhistory",
+		"showunspent",
+		"shownodes",
+		"seednodes",
+		"listbanned",
+		"listpinned"}
+
+	for _, cm := range commands {
+		if cm == c.Command {
+			return true
+		}
+	}
+
+	if c.Command == "unapprovedtransactions" && !c.Input.Args.Clean {
+		return true
+	}
+
+	if c.Command == "verifychain" && !c.Input.Args.Repair {
+		return true
+	}
+	return false
+}
+
+/*
+* Detects if a command can be executed when the node server works. The server keeps the DB opened,
+* so these commands are sent to it. Other commands need the DB and can not work at this time
+ */
+func (c NodeCLI) isServerCommand() bool {
+	commands := []string{
+		"printchain",
+		"exportchain",
+		"dumputxoset",
+		"backup",
+		"getbalance",
+		"getbalances",
+		"createwallet",
+		"listaddresses",
+		"send",
+		"addrhistory",
+		"showunspent",
+		"shownodes",
+		"seednodes",
 		"addnode",
-		"removenode"}
+		"removenode",
+		"listbanned",
+		"ban",
+		"unban",
+		"listpinned",
+		"pinnode",
+		"unpinnode"}
 
 	for _, cm := range commands {
 		if cm == c.Command {
 			return true
 		}
 	}
+
+	if c.Command == "unapprovedtransactions" && !c.Input.Args.Clean {
+		return true
+	}
+
+	if c.Command == "verifychain" && !c.Input.Args.Repair {
+		return true
+	}
 	return false
 }
 
@@ -142,12 +256,30 @@ func (c NodeCLI) isNodeManageMode() bool {
 * Executes the client command in interactive mode
  */
 func (c NodeCLI) ExecuteCommand() error {
+	if c.Command == "lockstatus" {
+		// this must work even if the DB is locked by other process
+		return c.commandLockStatus()
+	}
+
+	if c.Command == "nodekey" {
+		// the key is in a file. blockchain is not needed
+		return c.commandNodeKey()
+	}
+
 	c.CreateNode() // init node struct
 
-	if c.Command != "createblockchain" &&
+	if c.AlreadyRunningPort > 0 {
+		if !c.isServerCommand() {
+			return errors.New(fmt.Sprintf("Node server is running. Stop it before to execute %s", c.Command))
+		}
+	} else if c.Command != "createblockchain" &&
 		c.Command != "initblockchain" &&
+		c.Command != "importchain" &&
+		c.Command != "loadutxoset" &&
+		c.Command != "restore" &&
 		c.Command != "createwallet" &&
 		c.Command != "listaddresses" &&
+		c.Command != "seednodes" &&
 		c.Command != "nodestate" {
 		// only these 3 addresses can be executed if no blockchain yet
 		if !c.Node.BlockchainExist() {
@@ -166,9 +298,30 @@ func (c NodeCLI) ExecuteCommand() error {
 	} else if c.Command == "printchain" {
 		return c.commandPrintChain()
 
+	} else if c.Command == "exportchain" {
+		return c.commandExportChain()
+
+	} else if c.Command == "importchain" {
+		return c.commandImportChain()
+
+	} else if c.Command == "dumputxoset" {
+		return c.commandDumpUTXOSet()
+
+	} else if c.Command == "loadutxoset" {
+		return c.commandLoadUTXOSet()
+
+	} else if c.Command == "backup" {
+		return c.commandBackup()
+
+	} else if c.Command == "restore" {
+		return c.commandRestore()
+
 	} else if c.Command == "reindexcache" {
 		return c.commandReindexCache()
 
+	} else if c.Command == "verifychain" {
+		return c.commandVerifyChain()
+
 	} else if c.Command == "getbalance" {
 		return c.commandGetBalance()
 
@@ -205,11 +358,32 @@ func (c NodeCLI) ExecuteCommand() error {
 	} else if c.Command == "shownodes" {
 		return c.commandShowNodes()
 
+	} else if c.Command == "seednodes" {
+		return c.commandSeedNodes()
+
 	} else if c.Command == "addnode" {
 		return c.commandAddNode()
 
 	} else if c.Command == "removenode" {
 		return c.commandRemoveNode()
+
+	} else if c.Command == "listbanned" {
+		return c.commandListBanned()
+
+	} else if c.Command == "ban" {
+		return c.commandBan()
+
+	} else if c.Command == "unban" {
+		return c.commandUnban()
+
+	} else if c.Command == "listpinned" {
+		return c.commandListPinned()
+
+	} else if c.Command == "pinnode" {
+		return c.commandPinNode()
+
+	} else if c.Command == "unpinnode" {
+		return c.commandUnpinNode()
 	}
 
 	return errors.New("Unknown management command")
@@ -223,7 +397,8 @@ func (c NodeCLI) createDaemonManager() (*server.NodeDaemon, error) {
 
 	c.CreateNode()
 
-	if !c.Node.BlockchainExist() {
+	// the DB is opened by the node server if it works. it has the blockchain in this case
+	if c.AlreadyRunningPort == 0 && !c.Node.BlockchainExist() {
 		return nil, errors.New("Blockchain is not found. Must be created or inited")
 	}
 
@@ -231,6 +406,17 @@ func (c NodeCLI) createDaemonManager() (*server.NodeDaemon, error) {
 	nd.Logger = c.Logger
 	nd.Port = c.Input.Port
 	nd.Host = c.Input.Host
+	nd.Bind = c.Input.Bind
+	nd.MaxConnections = c.Input.MaxConn
+	nd.Encrypt = c.Input.Encrypt
+	nd.Seeds = c.Input.Seeds
+	nd.LanDiscovery = c.Input.LanDiscovery
+	nd.RPCPort = c.Input.RPCPort
+	nd.RPCHost = c.Input.RPCHost
+	nd.RPCUser = c.Input.RPCUser
+	nd.RPCPassword = c.Input.RPCPassword
+	nd.RESTPort = c.Input.RESTPort
+	nd.RESTHost = c.Input.RESTHost
 	nd.Node = c.Node
 	nd.Init()
 
@@ -317,6 +503,63 @@ func (c *NodeCLI) getLocalNetworkClient() nodeclient.NodeClient {
 	return nc
 }
 
+// Returns function that reads blocks from the top to genesis. Blocks are requested from the node server
+// by pages if it works
+func (c *NodeCLI) getChainBlocksReader() (func() (*structures.Block, error), error) {
+	if c.AlreadyRunningPort == 0 {
+		bci, err := c.Node.GetBlockChainIterator()
+
+		if err != nil {
+			return nil, err
+		}
+		return bci.Next, nil
+	}
+
+	nc := c.getLocalNetworkClient()
+
+	page := [][]byte{}
+	var next []byte
+
+	return func() (*structures.Block, error) {
+		if len(page) == 0 {
+			var err error
+
+			page, err = nc.SendGetChainBlocks(next, printChainPageSize)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(page) == 0 {
+				return nil, nil
+			}
+		}
+
+		block := &structures.Block{}
+
+		err := block.DeserializeBlock(page[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		page = page[1:]
+		next = block.PrevBlockHash
+
+		return block, nil
+	}, nil
+}
+
+// Node server works in other directory. Paths of files for it must be absolute
+func getAbsPath(path string) string {
+	abspath, err := filepath.Abs(path)
+
+	if err != nil {
+		return path
+	}
+	return abspath
+}
+
 // To create new blockchain from scratch
 func (c *NodeCLI) commandCreateBlockchain() error {
 	err := c.Node.CreateBlockchain(c.Input.Args.Address, c.Input.Args.Genesis)
@@ -354,7 +597,7 @@ func (c *NodeCLI) commandInitBlockchain() error {
 // Print full blockchain
 
 func (c *NodeCLI) commandPrintChain() error {
-	bci, err := c.Node.GetBlockChainIterator()
+	next, err := c.getChainBlocksReader()
 
 	if err != nil {
 		return err
@@ -363,7 +606,7 @@ func (c *NodeCLI) commandPrintChain() error {
 	blocks := []string{}
 
 	for {
-		blockfull, err := bci.Next()
+		blockfull, err := next()
 
 		if err != nil {
 			return err
@@ -393,6 +636,10 @@ func (c *NodeCLI) commandPrintChain() error {
 			fmt.Printf("Height: %d\n", block.Height)
 			fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
 
+			if blockfull.IsPruned() {
+				fmt.Println("Block is pruned. Only header is kept")
+			}
+
 			for _, tx := range block.Transactions {
 				fmt.Println(tx)
 			}
@@ -416,6 +663,169 @@ func (c *NodeCLI) commandPrintChain() error {
 	return nil
 }
 
+// Write blocks to a file. Range of heights can be set with -from and -to
+func (c *NodeCLI) commandExportChain() error {
+	if c.Input.Args.File == "" {
+		return errors.New("File path is not provided")
+	}
+
+	from := 0
+	to := -1
+
+	var err error
+
+	if c.Input.Args.From != "" {
+		from, err = strconv.Atoi(c.Input.Args.From)
+
+		if err != nil {
+			return errors.New("From height is not valid")
+		}
+	}
+
+	if c.Input.Args.To != "" {
+		to, err = strconv.Atoi(c.Input.Args.To)
+
+		if err != nil {
+			return errors.New("To height is not valid")
+		}
+	}
+
+	var count int
+
+	if c.AlreadyRunningPort > 0 {
+		// the file is written by the server. relative path must be same for it
+		nc := c.getLocalNetworkClient()
+		count, err = nc.SendExportChain(getAbsPath(c.Input.Args.File), from, to)
+	} else {
+		count, err = c.Node.ExportChain(c.Input.Args.File, from, to)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Done! %d blocks exported to %s\n", count, c.Input.Args.File)
+
+	return nil
+}
+
+// Add blocks from a file made with exportchain
+func (c *NodeCLI) commandImportChain() error {
+	if c.Input.Args.File == "" {
+		return errors.New("File path is not provided")
+	}
+
+	added, skipped, err := c.Node.ImportChain(c.Input.Args.File)
+
+	if err != nil {
+		fmt.Printf("Added %d blocks, skipped %d existent blocks\n", added, skipped)
+		return err
+	}
+
+	fmt.Printf("Done! Added %d blocks, skipped %d existent blocks\n", added, skipped)
+
+	return nil
+}
+
+// Write the UTXO set and chain headers to a file
+func (c *NodeCLI) commandDumpUTXOSet() error {
+	if c.Input.Args.File == "" {
+		return errors.New("File path is not provided")
+	}
+
+	var tipHash, digest []byte
+	var count int
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		var info nodeclient.ComUTXOSetInfo
+
+		info, err = nc.SendDumpUTXOSet(getAbsPath(c.Input.Args.File))
+
+		tipHash, count, digest = info.TipHash, info.Count, info.Digest
+	} else {
+		tipHash, count, digest, err = c.Node.DumpUnspentOutputs(c.Input.Args.File)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Done! %d transactions with unspent outputs written to %s\n", count, c.Input.Args.File)
+	fmt.Printf("Tip: %x\n", tipHash)
+	fmt.Printf("Digest: %x\n", digest)
+
+	return nil
+}
+
+// Create new DB from a file made with dumputxoset
+func (c *NodeCLI) commandLoadUTXOSet() error {
+	if c.Input.Args.File == "" {
+		return errors.New("File path is not provided")
+	}
+
+	trustedDigest, err := hex.DecodeString(c.Input.Args.Digest)
+
+	if err != nil || len(trustedDigest) != sha256.Size {
+		return errors.New("Trusted digest of the snapshot must be provided with -digest as 64 hex symbols")
+	}
+
+	tipHash, count, digest, err := c.Node.LoadUnspentOutputs(c.Input.Args.File, trustedDigest)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Done! %d transactions with unspent outputs loaded from %s\n", count, c.Input.Args.File)
+	fmt.Printf("Tip: %x\n", tipHash)
+	fmt.Printf("Digest: %x\n", digest)
+
+	return nil
+}
+
+// Copy DB files and wallets to a directory
+func (c *NodeCLI) commandBackup() error {
+	if c.Input.Args.Dest == "" {
+		return errors.New("Destination directory is not provided")
+	}
+
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+		err = nc.SendBackup(getAbsPath(c.Input.Args.Dest))
+	} else {
+		err = c.Node.Backup(c.Input.Args.Dest)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Done! Backup is saved to %s\n", c.Input.Args.Dest)
+
+	return nil
+}
+
+// Replace DB files and wallets with files from a backup
+func (c *NodeCLI) commandRestore() error {
+	if c.Input.Args.Src == "" {
+		return errors.New("Source directory is not provided")
+	}
+
+	err := c.Node.Restore(c.Input.Args.Src)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Done! Data restored from %s\n", c.Input.Args.Src)
+
+	return nil
+}
+
 // Show contents of a cache of unapproved transactions (transactions pool)
 func (c *NodeCLI) commandUnapprovedTransactions() error {
 
@@ -425,14 +835,31 @@ func (c *NodeCLI) commandUnapprovedTransactions() error {
 		return c.Node.GetTransactionsManager().CleanUnapprovedCache()
 	}
 
-	total, _ := c.Node.GetTransactionsManager().ForEachUnapprovedTransaction(
-		func(txhash, txstr string) error {
-			fmt.Printf("============ Transaction %x ============\n", txhash)
+	printTransaction := func(txhash, txstr string) error {
+		fmt.Printf("============ Transaction %x ============\n", txhash)
 
-			fmt.Println(txstr)
+		fmt.Println(txstr)
 
-			return nil
-		})
+		return nil
+	}
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		txs, err := nc.SendGetUnapproved()
+
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range txs {
+			printTransaction(tx.Hash, tx.Info)
+		}
+		fmt.Printf("\nTotal transactions: %d\n", len(txs))
+		return nil
+	}
+
+	total, _ := c.Node.GetTransactionsManager().ForEachUnapprovedTransaction(printTransaction)
 	fmt.Printf("\nTotal transactions: %d\n", total)
 	return nil
 }
@@ -578,6 +1005,16 @@ func (c *NodeCLI) commandSend() error {
 
 // Reindex cache of transactions information
 func (c *NodeCLI) commandReindexCache() error {
+	prunedHeight, err := c.Node.NodeBC.GetBCManager().GetPrunedHeight()
+
+	if err != nil {
+		return err
+	}
+
+	if prunedHeight > 0 {
+		return errors.New("Blockchain is pruned. Caches can not be rebuilt without full blocks")
+	}
+
 	info, err := c.Node.GetTransactionsManager().ReindexData()
 
 	if err != nil {
@@ -588,6 +1025,42 @@ func (c *NodeCLI) commandReindexCache() error {
 	return nil
 }
 
+// Check the DB consistency. Prints found problems
+func (c *NodeCLI) commandVerifyChain() error {
+	var count int
+	var problems []string
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		var result nodeclient.ComVerifyChainResult
+
+		result, err = nc.SendVerifyChain(c.Input.Args.Depth)
+
+		count, problems = result.Count, result.Problems
+	} else {
+		count, problems, err = c.Node.VerifyChain(c.Input.Args.Depth, c.Input.Args.Repair)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Done! Checked %d blocks. No problems found.\n", count)
+	} else if c.Input.Args.Repair {
+		fmt.Printf("Checked %d blocks. Found %d problems. Repaired what was possible.\n", count, len(problems))
+	} else {
+		fmt.Printf("Checked %d blocks. Found %d problems. Run with -repair to fix them.\n", count, len(problems))
+	}
+	return nil
+}
+
 // Try to mine a block if there is anough unapproved transactions
 func (c *NodeCLI) commandMakeBlock() error {
 	block, err := c.Node.TryToMakeBlock([]byte{})
@@ -692,6 +1165,11 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 		fmt.Printf("  Loaded %d of %d blocks\n", info.BlocksNumber, info.ExpectingBlocksHeight+1)
 	}
 
+	if info.Syncing {
+		fmt.Printf("  Sync: headers loaded up to height %d, loading blocks from %d nodes, %d blocks requested\n",
+			info.SyncHeadersHeight, info.SyncPeers, info.SyncBlocksInFlight)
+	}
+
 	fmt.Printf("  Number of unapproved transactions - %d\n", info.TransactionsCached)
 
 	fmt.Printf("  Number of unspent transactions outputs - %d\n", info.UnspentOutputs)
@@ -699,31 +1177,108 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 	return nil
 }
 
-// Displays list of nodes (connections)
+// Displays list of nodes (connections) with stats of connections to them
 func (c *NodeCLI) commandShowNodes() error {
-	var nodes []net.NodeAddr
+	var nodes []net.NodeStats
 	var err error
 
 	if c.AlreadyRunningPort > 0 {
 		// connect to node to get nodes list
 		nc := c.getLocalNetworkClient()
-		nodes, err = nc.SendGetNodes()
+		nodes, err = nc.SendGetNodesStats()
 
 		if err != nil {
 			return err
 		}
 	} else {
-		nodes = c.Node.NodeNet.GetNodes()
+		nodes = c.Node.NodeNet.GetNodesStats()
 	}
 	fmt.Println("Nodes:")
 
 	for _, n := range nodes {
-		fmt.Println("  ", n.NodeAddrToString())
+		source := n.Source
+
+		if source == "" {
+			source = "unknown"
+		}
+
+		fmt.Printf("   %s from %s. Seen: %s, success: %s, failures: %d\n", n.Addr.NodeAddrToString(), source,
+			formatNodeTime(n.LastSeen), formatNodeTime(n.LastSuccess), n.Failures)
+	}
+
+	return nil
+}
+
+// Displays lists of initial nodes from seed sources. Lists with other genesis hash are not used
+func (c *NodeCLI) commandSeedNodes() error {
+	var genesisHash []byte
+
+	isKnown := c.Node.NodeNet.CheckIsKnown
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		info, err := nc.SendGetState()
+
+		if err != nil {
+			return err
+		}
+		genesisHash = info.GenesisHash
+
+		nodes, err := nc.SendGetNodes()
+
+		if err != nil {
+			return err
+		}
+
+		isKnown = func(addr net.NodeAddr) bool {
+			for _, node := range nodes {
+				if node.CompareToAddress(addr) {
+					return true
+				}
+			}
+			return false
+		}
+	} else if c.Node.BlockchainExist() {
+		var err error
+
+		genesisHash, err = c.Node.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Seed sources:")
+
+	for _, result := range c.Node.NodeNet.LoadSeeds(genesisHash) {
+		if result.Error != "" {
+			fmt.Printf("   %s: not used. %s\n", result.Source, result.Error)
+			continue
+		}
+
+		fmt.Printf("   %s: %d nodes, genesis %s\n", result.Source, len(result.Nodes), result.Genesis)
+
+		for _, n := range result.Nodes {
+			known := ""
+
+			if isKnown(n) {
+				known = " (known)"
+			}
+			fmt.Printf("      %s%s\n", n.NodeAddrToString(), known)
+		}
 	}
 
 	return nil
 }
 
+func formatNodeTime(t int64) string {
+	if t == 0 {
+		return "never"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04:05")
+}
+
 // Add a node to connections
 func (c *NodeCLI) commandAddNode() error {
 	newaddr := net.NodeAddr{c.Input.Args.NodeHost, c.Input.Args.NodePort}
@@ -737,7 +1292,7 @@ func (c *NodeCLI) commandAddNode() error {
 			return err
 		}
 	} else {
-		c.Node.AddNodeToKnown(newaddr, false)
+		c.Node.AddNodeToKnown(newaddr, net.NodeSourceManual, false)
 	}
 
 	fmt.Println("Success!")
@@ -766,3 +1321,211 @@ func (c *NodeCLI) commandRemoveNode() error {
 
 	return nil
 }
+
+// Displays list of banned hosts
+func (c *NodeCLI) commandListBanned() error {
+	var bans []nodeclient.ComBannedNode
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+		bans, err = nc.SendGetBanned()
+	} else {
+		bans, err = c.Node.GetBannedNodes()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Banned:")
+
+	for _, ban := range bans {
+		fmt.Printf("   %s until %s. %s\n", ban.Host, time.Unix(ban.Until, 0).Format("2006-01-02 15:04:05"), ban.Reason)
+	}
+
+	return nil
+}
+
+// Ban a host. Connections from it will be refused
+func (c *NodeCLI) commandBan() error {
+	if c.Input.Args.NodeHost == "" {
+		return errors.New("Host to ban is not provided")
+	}
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		err := nc.SendBanNode(c.Input.Args.NodeHost, c.Input.BanTime)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := c.Node.BanNode(c.Input.Args.NodeHost, c.Input.BanTime, "Banned manually")
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Success!")
+
+	return nil
+}
+
+// Remove a ban of a host
+func (c *NodeCLI) commandUnban() error {
+	if c.Input.Args.NodeHost == "" {
+		return errors.New("Host to unban is not provided")
+	}
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		err := nc.SendUnbanNode(c.Input.Args.NodeHost)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		err := c.Node.UnbanNode(c.Input.Args.NodeHost)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Success!")
+
+	return nil
+}
+
+// Displays identity key of this node. Owners of other nodes can pin it
+func (c *NodeCLI) commandNodeKey() error {
+	key, err := net.LoadNodeKey(c.DataDir)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Node key: %x\n", key.PublicKey)
+	fmt.Printf("Fingerprint: %s\n", net.KeyFingerprint(key.PublicKey))
+
+	return nil
+}
+
+// Displays list of nodes with pinned keys
+func (c *NodeCLI) commandListPinned() error {
+	var pins []nodeclient.ComPinnedNode
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+		pins, err = nc.SendGetPinned()
+	} else {
+		pins, err = c.Node.GetPinnedNodes()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Pinned keys:")
+
+	for _, pin := range pins {
+		fmt.Printf("   %s - %s (%x)\n", pin.Node.NodeAddrToString(), net.KeyFingerprint(pin.Key), pin.Key)
+	}
+
+	return nil
+}
+
+// Pin a key of a node. Connections to the node with other key will be refused
+func (c *NodeCLI) commandPinNode() error {
+	addr := net.NodeAddr{c.Input.Args.NodeHost, c.Input.Args.NodePort}
+
+	if addr.Host == "" || addr.Port < 1 {
+		return errors.New("Node host and port are not provided")
+	}
+
+	key, err := hex.DecodeString(c.Input.Args.Key)
+
+	if err != nil || len(key) != net.NodeKeyLength {
+		return errors.New("Node key must be 128 hex symbols")
+	}
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		err = nc.SendPinNode(addr, key)
+	} else {
+		err = c.Node.PinNodeKey(addr, key)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Success!")
+
+	return nil
+}
+
+// Remove pinned key of a node
+func (c *NodeCLI) commandUnpinNode() error {
+	addr := net.NodeAddr{c.Input.Args.NodeHost, c.Input.Args.NodePort}
+
+	if addr.Host == "" || addr.Port < 1 {
+		return errors.New("Node host and port are not provided")
+	}
+
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		nc := c.getLocalNetworkClient()
+
+		err = nc.SendUnpinNode(addr)
+	} else {
+		err = c.Node.UnpinNodeKey(addr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Success!")
+
+	return nil
+}
+
+// Shows what process holds a lock on DB files
+func (c *NodeCLI) commandLockStatus() error {
+	dbconn := nodemanager.Database{}
+	dbconn.SetLogger(c.Logger)
+	dbconn.SetConfig(c.Input.Database)
+
+	list, err := dbconn.GetLockStatus()
+
+	if err != nil {
+		return err
+	}
+
+	nd := server.NodeDaemon{}
+	nd.DataDir = c.DataDir
+	nd.Logger = c.Logger
+
+	_, serverPID, _, _ := nd.GetServerState()
+
+	fmt.Println("Database locks:")
+
+	for _, status := range list {
+		fmt.Printf("  %s (%s) - %s", status.Name, status.File, status)
+
+		if status.PID > 0 && status.PID == serverPID {
+			fmt.Print(", node server")
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
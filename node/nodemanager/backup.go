@@ -0,0 +1,109 @@
+package nodemanager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gelembjuk/democoin/lib/wallet"
+)
+
+// Copies the DB files and the wallets file to a directory. DB files are copied in read transactions,
+// so the backup is consistent even if the node server works at same time
+func (n *Node) Backup(destDir string) error {
+	destDir = prepareDirPath(destDir)
+
+	err := os.MkdirAll(destDir, 0700)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.DBConn.DB().Backup(destDir)
+
+	if err != nil {
+		return err
+	}
+
+	walletsFile := getWalletsFilePath(n.DataDir)
+
+	if _, err := os.Stat(walletsFile); os.IsNotExist(err) {
+		// there are no wallets on this node
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(walletsFile)
+
+	if err != nil {
+		return err
+	}
+
+	destWalletsFile := getWalletsFilePath(destDir)
+
+	err = ioutil.WriteFile(destWalletsFile, data, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	// the file could be written by other process while we read it
+	err = checkWalletsFile(destWalletsFile)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Wallets file copy is not valid: %s. Try again", err.Error()))
+	}
+
+	return nil
+}
+
+// Replaces the DB files and the wallets file with files from a backup directory.
+// All files are checked and copied before any of them is replaced. Current files are renamed to *.old
+// If some file can not be replaced, all files are returned back
+// The node server must not work at this time
+func (n *Node) Restore(srcDir string) error {
+	srcDir = prepareDirPath(srcDir)
+
+	err := n.DBConn.CheckBackup(srcDir)
+
+	if err != nil {
+		return err
+	}
+
+	srcWalletsFile := getWalletsFilePath(srcDir)
+
+	extraFiles := map[string]string{}
+
+	if _, err := os.Stat(srcWalletsFile); err == nil {
+		err = checkWalletsFile(srcWalletsFile)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Wallets file %s is not valid: %s", srcWalletsFile, err.Error()))
+		}
+
+		extraFiles[srcWalletsFile] = getWalletsFilePath(n.DataDir)
+	}
+
+	return n.DBConn.Restore(srcDir, extraFiles)
+}
+
+func getWalletsFilePath(dir string) string {
+	ws := wallet.Wallets{}
+	ws.DataDir = dir
+
+	return ws.GetFilePath()
+}
+
+func checkWalletsFile(filepath string) error {
+	ws := wallet.Wallets{}
+	ws.WalletsFile = filepath
+
+	return ws.LoadFromFile()
+}
+
+func prepareDirPath(dir string) string {
+	if dir[len(dir)-1:] != "/" {
+		dir += "/"
+	}
+	return dir
+}
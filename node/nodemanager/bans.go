@@ -0,0 +1,143 @@
+package nodemanager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+)
+
+// Ban time if it is not set in config
+const defaultBanTime = 24 * 3600
+
+// Returns ban duration in seconds
+func (n *Node) GetBanTime() int {
+	if n.BanTime > 0 {
+		return n.BanTime
+	}
+	return defaultBanTime
+}
+
+// Saves a ban of a host. Connections from it are refused until the ban expires.
+// If duration is 0 then the default ban time is used
+func (n *Node) BanNode(host string, duration int, reason string) (nodeclient.ComBannedNode, error) {
+	if duration < 1 {
+		duration = n.GetBanTime()
+	}
+
+	ban := nodeclient.ComBannedNode{}
+	ban.Host = host
+	ban.Until = time.Now().Unix() + int64(duration)
+	ban.Reason = reason
+
+	if n.DBConn.OpenConnectionIfNeeded("BanNode", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return ban, err
+	}
+
+	var buff bytes.Buffer
+
+	err = gob.NewEncoder(&buff).Encode(ban)
+
+	if err != nil {
+		return ban, err
+	}
+
+	err = nddb.PutBan([]byte(host), buff.Bytes())
+
+	if err != nil {
+		return ban, err
+	}
+
+	n.Logger.Trace.Printf("Banned %s until %s. %s", host, time.Unix(ban.Until, 0).String(), reason)
+
+	return ban, nil
+}
+
+// Removes a ban of a host
+func (n *Node) UnbanNode(host string) error {
+	if n.DBConn.OpenConnectionIfNeeded("UnbanNode", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return err
+	}
+
+	return nddb.DeleteBan([]byte(host))
+}
+
+// Returns list of bans that are not expired yet
+func (n *Node) GetBannedNodes() ([]nodeclient.ComBannedNode, error) {
+	bans := []nodeclient.ComBannedNode{}
+
+	err := n.forEachBan(func(ban nodeclient.ComBannedNode, expired bool) error {
+		if !expired {
+			bans = append(bans, ban)
+		}
+		return nil
+	})
+
+	return bans, err
+}
+
+// Deletes expired bans from the DB
+func (n *Node) RemoveExpiredBans() error {
+	expiredHosts := []string{}
+
+	err := n.forEachBan(func(ban nodeclient.ComBannedNode, expired bool) error {
+		if expired {
+			expiredHosts = append(expiredHosts, ban.Host)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, host := range expiredHosts {
+		err = n.UnbanNode(host)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) forEachBan(callback func(ban nodeclient.ComBannedNode, expired bool) error) error {
+	if n.DBConn.OpenConnectionIfNeeded("BannedNodes", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	return nddb.ForEachBan(func(k, v []byte) error {
+		ban := nodeclient.ComBannedNode{}
+
+		err := gob.NewDecoder(bytes.NewReader(v)).Decode(&ban)
+
+		if err != nil {
+			// broken record. it is removed as expired
+			ban.Host = string(k)
+			return callback(ban, true)
+		}
+
+		return callback(ban, ban.Until <= now)
+	})
+}
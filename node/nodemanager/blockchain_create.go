@@ -125,14 +125,21 @@ func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *
 				return false, err
 			}
 
-			_, err = BC.AddBlock(block)
+			// block and caches are updated in one DB transaction
+			err = n.DBConn.Update(func() error {
+				_, err := BC.AddBlock(block)
+
+				if err != nil {
+					return err
+				}
+
+				return TXMan.BlockAdded(block, true)
+			})
 
 			if err != nil {
 				return false, err
 			}
 
-			TXMan.BlockAdded(block, true)
-
 			MH = block.Height
 		}
 	}
@@ -183,41 +190,42 @@ func (n *makeBlockchain) addFirstBlock(genesis *structures.Block) error {
 		return err
 	}
 
-	bcdb, err := n.DBConn.DB().GetBlockchainObject()
-
-	if err != nil {
-		n.Logger.Error.Printf("Can not create conn object: %s", err.Error())
-		return err
-	}
-
 	blockdata, err := genesis.Serialize()
 
 	if err != nil {
 		return err
 	}
 
-	err = bcdb.PutBlockOnTop(genesis.Hash, blockdata)
+	// the block and caches are saved in one DB transaction
+	return n.DBConn.Update(func() error {
+		bcdb, err := n.DBConn.DB().GetBlockchainObject()
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			n.Logger.Error.Printf("Can not create conn object: %s", err.Error())
+			return err
+		}
 
-	err = bcdb.SaveFirstHash(genesis.Hash)
+		err = bcdb.PutBlockOnTop(genesis.Hash, blockdata)
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	// add first rec to chain list
-	err = bcdb.AddToChain(genesis.Hash, []byte{})
+		err = bcdb.SaveFirstHash(genesis.Hash)
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	n.Logger.Trace.Printf("Prepare TX caches\n")
+		// add first rec to chain list
+		err = bcdb.AddToChain(genesis.Hash, []byte{})
+
+		if err != nil {
+			return err
+		}
 
-	n.getTransactionsManager().BlockAdded(genesis, true)
+		n.Logger.Trace.Printf("Prepare TX caches\n")
 
-	return err
+		return n.getTransactionsManager().BlockAdded(genesis, true)
+	})
 }
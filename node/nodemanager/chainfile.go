@@ -0,0 +1,277 @@
+package nodemanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gelembjuk/democoin/node/blockchain"
+	"github.com/gelembjuk/democoin/node/consensus"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Chain file format. It starts with the magic string and the format version (uint32).
+// Then blocks follow from lower to higher. Every block is serialised block data
+// prefixed with its length (uint32). All numbers are big endian
+const chainFileMagic = "DMCHAIN"
+const chainFileVersion = 1
+
+// block bigger than this is considered as broken file
+const chainFileMaxBlockSize = 64 * 1024 * 1024
+
+// Writes blocks of primary chain with heights in range from-to to a file.
+// If to is less than 0 then blocks up to the top are written
+// Returns number of written blocks
+func (n *Node) ExportChain(filepath string, from, to int) (int, error) {
+	file, err := os.Create(filepath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	_, err = w.WriteString(chainFileMagic)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint32(chainFileVersion))
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	// all blocks are read in one transaction. the chain is not changed while we export
+	err = n.DBConn.View(func() error {
+		bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		bcm, err := n.GetBCManager()
+
+		if err != nil {
+			return err
+		}
+
+		hash, err := bcdb.GetFirstHash()
+
+		if err != nil {
+			return err
+		}
+
+		for len(hash) > 0 {
+			block, err := bcm.GetBlock(hash)
+
+			if err != nil {
+				return err
+			}
+
+			if to >= 0 && block.Height > to {
+				break
+			}
+
+			if block.Height >= from {
+				if block.IsPruned() {
+					return errors.New(fmt.Sprintf("Block %x at height %d is pruned. Can not export it", block.Hash, block.Height))
+				}
+
+				blockdata, err := block.Serialize()
+
+				if err != nil {
+					return err
+				}
+
+				err = binary.Write(w, binary.BigEndian, uint32(len(blockdata)))
+
+				if err != nil {
+					return err
+				}
+
+				_, err = w.Write(blockdata)
+
+				if err != nil {
+					return err
+				}
+
+				count++
+			}
+
+			_, _, hash, err = bcdb.GetLocationInChain(hash)
+
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, w.Flush()
+}
+
+// Reads blocks from a chain file and adds them to the blockchain.
+// Every block is verified same way as a block received from other node.
+// If there is no blockchain yet then first block in the file must be the genesis block
+// Returns number of added blocks and number of skipped blocks (that already exist)
+func (n *Node) ImportChain(filepath string) (int, int, error) {
+	file, err := os.Open(filepath)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(chainFileMagic))
+
+	_, err = io.ReadFull(r, magic)
+
+	if err != nil || bytes.Compare(magic, []byte(chainFileMagic)) != 0 {
+		return 0, 0, errors.New("The file is not a chain file")
+	}
+
+	var version uint32
+
+	err = binary.Read(r, binary.BigEndian, &version)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if version != chainFileVersion {
+		return 0, 0, errors.New(fmt.Sprintf("Chain file version %d is not supported", version))
+	}
+
+	added := 0
+	skipped := 0
+
+	bcexists := n.BlockchainExist()
+
+	for {
+		var length uint32
+
+		err = binary.Read(r, binary.BigEndian, &length)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return added, skipped, err
+		}
+
+		if length == 0 || length > chainFileMaxBlockSize {
+			return added, skipped, errors.New(fmt.Sprintf("Wrong block size %d in the chain file", length))
+		}
+
+		blockdata := make([]byte, length)
+
+		_, err = io.ReadFull(r, blockdata)
+
+		if err != nil {
+			return added, skipped, errors.New(fmt.Sprintf("Can not read block from the chain file: %s", err.Error()))
+		}
+
+		block := &structures.Block{}
+
+		err = block.DeserializeBlock(blockdata)
+
+		if err != nil {
+			return added, skipped, err
+		}
+
+		isadded, err := n.importBlock(block, bcexists)
+
+		if err != nil {
+			return added, skipped, errors.New(fmt.Sprintf("Block %x at height %d: %s", block.Hash, block.Height, err.Error()))
+		}
+
+		if isadded {
+			added++
+		} else {
+			skipped++
+		}
+
+		bcexists = true
+	}
+
+	return added, skipped, nil
+}
+
+// Adds imported block. Returns false if the block already exists
+func (n *Node) importBlock(block *structures.Block, bcexists bool) (bool, error) {
+	if len(block.PrevBlockHash) == 0 {
+		return n.importGenesisBlock(block, bcexists)
+	}
+
+	if !bcexists {
+		return false, errors.New("Blockchain is not found. First block in the file must be the genesis block")
+	}
+
+	addstate, err := n.AddBlock(block)
+
+	if err != nil {
+		return false, err
+	}
+
+	if addstate == blockchain.BCBAddState_notAddedExists {
+		return false, nil
+	}
+
+	if addstate == blockchain.BCBAddState_notAddedNoPrev {
+		return false, errors.New("Previous block is not found")
+	}
+
+	return true, nil
+}
+
+// Genesis block creates new blockchain. If blockchain exists then the block must be same as existent genesis
+func (n *Node) importGenesisBlock(block *structures.Block, bcexists bool) (bool, error) {
+	if bcexists {
+		firstHash, err := n.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.Compare(firstHash, block.Hash) != 0 {
+			return false, errors.New(fmt.Sprintf("Genesis block is different from existent %x", firstHash))
+		}
+		return false, nil
+	}
+
+	valid, err := consensus.NewProofOfWork(block).Validate()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !valid {
+		return false, errors.New("Block hash is not valid")
+	}
+
+	err = n.getCreateManager().addFirstBlock(block)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -1,6 +1,7 @@
 package nodemanager
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/gelembjuk/democoin/lib/utils"
@@ -13,6 +14,7 @@ type Database struct {
 	Config    database.DatabaseConfig
 	lockerObj database.DatabaseLocker
 	locallock *sync.Mutex
+	readOnly  bool
 }
 
 func (db *Database) DB() database.DBManager {
@@ -48,6 +50,7 @@ func (db *Database) Clone() Database {
 	ndb.SetLogger(db.Logger)
 	ndb.SetConfig(db.Config)
 	ndb.lockerObj = db.lockerObj
+	ndb.readOnly = db.readOnly
 
 	return ndb
 }
@@ -60,6 +63,48 @@ func (db *Database) SetConfig(config database.DatabaseConfig) {
 	db.Config = config
 }
 
+// Readonly connection will not block other readers. Use it for commands that don't modify data
+func (db *Database) SetReadOnly(readonly bool) {
+	db.readOnly = readonly
+}
+
+// Returns state of DB locks. Connection is not opened for this
+func (db *Database) GetLockStatus() ([]database.LockStatus, error) {
+	var list []database.LockStatus
+
+	err := db.withManager(func(dbm database.DBManager) error {
+		var err error
+		list, err = dbm.GetLockStatus()
+		return err
+	})
+
+	return list, err
+}
+
+// Keeps DB opened while a process works. Used by node server. All clones of this object
+// use same opened DB and don't block each other
+func (db *Database) OpenPersistentConnection(reason string) error {
+	return db.withManager(func(dbm database.DBManager) error {
+		return dbm.OpenPersistentConnection(reason)
+	})
+}
+
+func (db *Database) ClosePersistentConnection() error {
+	return db.withManager(func(dbm database.DBManager) error {
+		return dbm.ClosePersistentConnection()
+	})
+}
+
+// Executes a function in a read transaction. DB state is same for all reads inside it
+func (db *Database) View(f func() error) error {
+	return db.DB().View(f)
+}
+
+// Executes a function in a write transaction. All changes are saved together or not saved at all
+func (db *Database) Update(f func() error) error {
+	return db.DB().Update(f)
+}
+
 func (db *Database) OpenConnection(reason string, sessid string) error {
 	//db.Logger.Trace.Printf("OpenConn in DB man %s", reason)
 
@@ -80,6 +125,7 @@ func (db *Database) PrepareConnection(sessid string) {
 	db.db = obj
 	db.db.SetLogger(db.Logger)
 	db.db.SetConfig(db.Config)
+	db.db.SetReadOnly(db.readOnly)
 
 	if db.lockerObj != nil {
 		db.db.SetLockerObject(db.lockerObj)
@@ -125,3 +171,34 @@ func (db *Database) CheckConnectionIsOpen() bool {
 	}
 	return false
 }
+
+// Checks DB files in a backup directory. Connection is not opened for this
+func (db *Database) CheckBackup(srcDir string) error {
+	return db.withManager(func(dbm database.DBManager) error {
+		return dbm.CheckBackup(srcDir)
+	})
+}
+
+// Replaces DB files and extra files with files from a backup directory. Connection must not be opened
+func (db *Database) Restore(srcDir string, extraFiles map[string]string) error {
+	if db.db != nil {
+		return errors.New("DB connection is opened. Close it before to restore")
+	}
+
+	return db.withManager(func(dbm database.DBManager) error {
+		return dbm.Restore(srcDir, extraFiles)
+	})
+}
+
+// Executes a function with a manager that doesn't need opened connection. If a connection
+// is opened its manager is used, otherwise temporary one is created and dropped after
+func (db *Database) withManager(f func(dbm database.DBManager) error) error {
+	if db.db != nil {
+		return f(db.db)
+	}
+
+	db.PrepareConnection("")
+	defer db.CleanConnection()
+
+	return f(db.db)
+}
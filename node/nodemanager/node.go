@@ -24,10 +24,18 @@ type Node struct {
 	DataDir string
 
 	MinterAddress string
+	PruneKeep     int // number of top blocks to keep with full data. 0 means no pruning
+	BanTime       int // seconds. how long misbehaving nodes are banned
 	NodeClient    *nodeclient.NodeClient
 	OtherNodes    []net.NodeAddr
 	DBConn        *Database
 	SessionID     string
+	TxAnnouncer   TransactionsAnnouncer // node server sets it to announce transactions in batches
+}
+
+// Announces transactions to other nodes. If it is not set, every transaction is announced separately
+type TransactionsAnnouncer interface {
+	AnnounceTransaction(txID []byte, nodes []net.NodeAddr)
 }
 
 // Init node.
@@ -43,7 +51,7 @@ func (n *Node) Init() {
 	n.NodeBC.DBConn = n.DBConn
 
 	// Nodes list storage
-	n.NodeNet.SetExtraManager(NodesListStorage{n.DBConn, n.SessionID})
+	n.NodeNet.SetExtraManager(newNodesListStorage(n.DBConn, n.SessionID))
 	// load list of nodes from config
 	n.NodeNet.SetNodes([]net.NodeAddr{}, true)
 
@@ -87,6 +95,8 @@ func (n *Node) InitClient() error {
 
 	client.Logger = n.Logger
 	client.NodeNet = &n.NodeNet
+	// node key is loaded from the data directory when first encrypted connection is opened
+	client.DataDir = n.DataDir
 
 	n.NodeClient = &client
 
@@ -153,11 +163,13 @@ func (n *Node) InitBlockchainFromOther(host string, port int) (bool, error) {
 		// load node from special hardcoded url
 		n.NodeNet.LoadInitialNodes(nil)
 		// get node from known nodes
-		if len(n.NodeNet.Nodes) == 0 {
+		nodes := n.NodeNet.GetNodesToConnect(1)
+
+		if len(nodes) == 0 {
 
 			return false, errors.New("No known nodes to request a blockchain")
 		}
-		nd := n.NodeNet.Nodes[rand.Intn(len(n.NodeNet.Nodes))]
+		nd := nodes[0]
 
 		host = nd.Host
 		port = nd.Port
@@ -177,11 +189,24 @@ func (n *Node) InitBlockchainFromOther(host string, port int) (bool, error) {
 
 /*
 * Send transaction to all known nodes. This wil send only hash and node hash to check if hash exists or no
+* Node server announces transactions in batches, nodes that know a transaction don't get it again
  */
 func (n *Node) SendTransactionToAll(tx *structures.Transaction) {
 	n.Logger.Trace.Printf("Send transaction to %d nodes", len(n.NodeNet.Nodes))
 
-	for _, node := range n.NodeNet.Nodes {
+	if n.TxAnnouncer != nil {
+		nodes := []net.NodeAddr{}
+
+		for _, node := range n.NodeNet.GetNodesToConnect(0) {
+			if !node.CompareToAddress(n.NodeClient.NodeAddress) {
+				nodes = append(nodes, node)
+			}
+		}
+		n.TxAnnouncer.AnnounceTransaction(tx.ID, nodes)
+		return
+	}
+
+	for _, node := range n.NodeNet.GetNodesToConnect(0) {
 		if node.CompareToAddress(n.NodeClient.NodeAddress) {
 			continue
 		}
@@ -192,12 +217,17 @@ func (n *Node) SendTransactionToAll(tx *structures.Transaction) {
 
 // Add node
 // We need this for case when we want to do some more actions after node added
-func (n *Node) AddNodeToKnown(addr net.NodeAddr, sendversion bool) {
+// Source is set for new node. Manual source is set for known node too, such node is never removed as stale
+func (n *Node) AddNodeToKnown(addr net.NodeAddr, source string, sendversion bool) {
 	// this is just aliace. check function will do all work
 	// it will check if addres is in list, if no, it will send list of all known
 	// nodes to that address and ad it to known
 	added := n.CheckAddressKnown(addr)
 
+	if added || source == net.NodeSourceManual {
+		n.NodeNet.SetNodeSource(addr, source)
+	}
+
 	if added && sendversion {
 		n.Logger.Trace.Printf("Added node %s\n", addr.NodeAddrToString())
 		// end version to this node
@@ -211,7 +241,7 @@ func (n *Node) AddNodeToKnown(addr net.NodeAddr, sendversion bool) {
 // But not send full block, only hash and previous hash. So, other can copy it
 // Address from where we get it will be skipped
 func (n *Node) SendBlockToAll(newBlock *structures.Block, skipaddr net.NodeAddr) {
-	for _, node := range n.NodeNet.Nodes {
+	for _, node := range n.NodeNet.GetNodesToConnect(0) {
 		if node.CompareToAddress(n.NodeClient.NodeAddress) {
 			continue
 		}
@@ -223,30 +253,57 @@ func (n *Node) SendBlockToAll(newBlock *structures.Block, skipaddr net.NodeAddr)
 }
 
 /*
-* Send own version to all known nodes
+* Send own version to nodes. If the list is empty, nodes are picked from known nodes
  */
 func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
-	opened := n.DBConn.OpenConnectionIfNeeded("GetHeigh", n.SessionID)
-	bestHeight, err := n.NodeBC.GetBestHeight()
-
-	if opened {
-		n.DBConn.CloseConnection()
-	}
+	state, err := n.GetVersionState()
 
 	if err != nil {
 		return
 	}
 
 	if len(nodes) == 0 {
-		nodes = n.NodeNet.Nodes
+		nodes = n.NodeNet.GetNodesToConnect(net.MaxOutboundNodes)
 	}
 
 	for _, node := range nodes {
 		if node.CompareToAddress(n.NodeClient.NodeAddress) {
 			continue
 		}
-		n.NodeClient.SendVersion(node, bestHeight)
+		n.NodeClient.SendVersion(node, state)
+	}
+}
+
+// Returns state of the blockchain and services of this node. These are sent to other nodes in version command
+func (n *Node) GetVersionState() (nodeclient.ComVersion, error) {
+	state := nodeclient.ComVersion{}
+
+	opened := n.DBConn.OpenConnectionIfNeeded("GetHeigh", n.SessionID)
+
+	var err error
+
+	state.BestHeight, err = n.NodeBC.GetBestHeight()
+
+	if err == nil {
+		state.PrunedHeight, err = n.NodeBC.GetBCManager().GetPrunedHeight()
 	}
+
+	if err == nil {
+		state.GenesisHash, err = n.NodeBC.GetBCManager().GetGenesisBlockHash()
+	}
+
+	if opened {
+		n.DBConn.CloseConnection()
+	}
+
+	if state.PrunedHeight > 0 {
+		// there is no full history of addresses on pruned node, so it can not serve wallets
+		state.Services = net.ServicePruned
+	} else {
+		state.Services = net.ServiceFullNode | net.ServiceLiteServing
+	}
+
+	return state, err
 }
 
 /*
@@ -257,7 +314,7 @@ func (n *Node) CheckAddressKnown(addr net.NodeAddr) bool {
 	if !n.NodeNet.CheckIsKnown(addr) {
 		// send him all addresses
 		n.Logger.Trace.Printf("sending list of address to %s , %s", addr.NodeAddrToString(), n.NodeNet.Nodes)
-		n.NodeClient.SendAddrList(addr, n.NodeNet.Nodes)
+		n.NodeClient.SendAddrList(addr, n.NodeNet.GetNodes())
 
 		n.NodeNet.AddNodeToKnown(addr)
 
@@ -370,8 +427,27 @@ func (n *Node) TryToMakeBlock(newTransactionID []byte) ([]byte, error) {
 
 // Add new block to blockchain.
 // It can be executed when new block was created locally or received from other node
+// All DB changes (block, chain, transactions index, unspent outputs and unapproved cache)
+// are saved in one DB transaction. If something fails nothing is saved
 
 func (n *Node) AddBlock(block *structures.Block) (uint, error) {
+	var addstate uint
+
+	err := n.DBConn.Update(func() error {
+		var err error
+		addstate, err = n.addBlock(block)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return addstate, nil
+}
+
+// Add block to blockchain and update all caches. Must be executed in a DB transaction
+func (n *Node) addBlock(block *structures.Block) (uint, error) {
 	bcm, err := n.GetBCManager()
 
 	if err != nil {
@@ -391,7 +467,11 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 		addstate == blockchain.BCBAddState_addedToTop ||
 		addstate == blockchain.BCBAddState_addedToParallelTop {
 
-		n.GetTransactionsManager().BlockAdded(block, addstate == blockchain.BCBAddState_addedToTop)
+		err = n.GetTransactionsManager().BlockAdded(block, addstate == blockchain.BCBAddState_addedToTop)
+
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	if addstate == blockchain.BCBAddState_addedToParallelTop {
@@ -424,6 +504,16 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 		}
 	}
 
+	if addstate == blockchain.BCBAddState_addedToTop ||
+		addstate == blockchain.BCBAddState_addedToParallelTop {
+
+		err = n.pruneBlocks()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return addstate, nil
 }
 
@@ -432,21 +522,22 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 * This will not check if there are other branch that can now be longest and becomes main branch
  */
 func (n *Node) DropBlock() error {
-	block, err := n.NodeBC.DropBlock()
-
-	if err != nil {
-		return err
-	}
+	// block and caches are updated in one DB transaction
+	return n.DBConn.Update(func() error {
+		block, err := n.NodeBC.DropBlock()
 
-	n.GetTransactionsManager().BlockRemoved(block)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return n.GetTransactionsManager().BlockRemoved(block)
+	})
 }
 
 // New block info received from oher node. It is only Hash and PrevHash, not full block
-// Check if this is new block and if previous block is fine
+// Check if this is new block and if previous block is fine. Compact block is requested if the node supports it
 // returns state of processing. if a block data was requested or exists or prev doesn't exist
-func (n *Node) ReceivedBlockFromOtherNode(addrfrom net.NodeAddr, bsdata []byte) (int, error) {
+func (n *Node) ReceivedBlockFromOtherNode(addrfrom net.NodeAddr, bsdata []byte, compact bool) (int, error) {
 
 	bs := &structures.BlockShort{}
 	err := bs.DeserializeBlock(bsdata)
@@ -463,7 +554,13 @@ func (n *Node) ReceivedBlockFromOtherNode(addrfrom net.NodeAddr, bsdata []byte)
 
 	if blockstate == 0 {
 		// in this case we can request this block full info
-		n.NodeClient.SendGetData(addrfrom, "block", bs.Hash)
+		// compact block is smaller, its transactions are usually in our pool already
+		kind := "block"
+
+		if compact {
+			kind = "cmpctblock"
+		}
+		n.NodeClient.SendGetData(addrfrom, kind, bs.Hash)
 		return 0, nil // 0 means a block can be added and now we requested info about it
 	}
 	return blockstate, nil
@@ -475,15 +572,20 @@ func (n *Node) ReceivedBlockFromOtherNode(addrfrom net.NodeAddr, bsdata []byte)
 * returns state of processing. if a block data was requested or exists or prev doesn't exist
  */
 func (n *Node) ReceivedFullBlockFromOtherNode(blockdata []byte) (int, uint, *structures.Block, error) {
-	addstate := uint(blockchain.BCBAddState_error)
-
 	block := &structures.Block{}
 	err := block.DeserializeBlock(blockdata)
 
 	if err != nil {
-		return -1, addstate, nil, err
+		return -1, uint(blockchain.BCBAddState_error), nil, err
 	}
 
+	return n.ReceivedFullBlock(block)
+}
+
+// Same as ReceivedFullBlockFromOtherNode for a block that is decoded already
+func (n *Node) ReceivedFullBlock(block *structures.Block) (int, uint, *structures.Block, error) {
+	addstate := uint(blockchain.BCBAddState_error)
+
 	n.Logger.Trace.Printf("Recevied a new block %x", block.Hash)
 
 	// check state of this block
@@ -537,5 +639,11 @@ func (n *Node) GetNodeState() (nodeclient.ComGetNodeState, error) {
 
 	result.UnspentOutputs = unspent
 
+	result.GenesisHash, err = n.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+	if err != nil {
+		return result, err
+	}
+
 	return result, nil
 }
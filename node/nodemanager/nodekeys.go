@@ -0,0 +1,130 @@
+package nodemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Returns pinned identity key of a node. Empty if the key is not pinned yet
+func (n *Node) GetNodeKey(addr net.NodeAddr) ([]byte, error) {
+	if n.DBConn.OpenConnectionIfNeeded("GetNodeKey", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nddb.GetNodeKey([]byte(getNodeKeyID(addr)))
+}
+
+// Pins identity key of a node. Connections to the node with other key are refused after this
+func (n *Node) PinNodeKey(addr net.NodeAddr, key []byte) error {
+	if len(key) != net.NodeKeyLength {
+		return errors.New(fmt.Sprintf("Node key must be %d bytes", net.NodeKeyLength))
+	}
+
+	if n.DBConn.OpenConnectionIfNeeded("PinNodeKey", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return err
+	}
+
+	err = nddb.PutNodeKey([]byte(getNodeKeyID(addr)), key)
+
+	if err != nil {
+		return err
+	}
+
+	n.Logger.Trace.Printf("Pinned key %s of %s", net.KeyFingerprint(key), addr.NodeAddrToString())
+
+	return nil
+}
+
+// Removes pinned key of a node. New key is pinned on next encrypted connection
+func (n *Node) UnpinNodeKey(addr net.NodeAddr) error {
+	if n.DBConn.OpenConnectionIfNeeded("UnpinNodeKey", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return err
+	}
+
+	return nddb.DeleteNodeKey([]byte(getNodeKeyID(addr)))
+}
+
+// Returns list of pinned keys
+func (n *Node) GetPinnedNodes() ([]nodeclient.ComPinnedNode, error) {
+	if n.DBConn.OpenConnectionIfNeeded("PinnedNodes", n.SessionID) {
+		defer n.DBConn.CloseConnection()
+	}
+
+	nddb, err := n.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []nodeclient.ComPinnedNode{}
+
+	err = nddb.ForEachNodeKey(func(k, v []byte) error {
+		pin := nodeclient.ComPinnedNode{}
+
+		if pin.Node.LoadFromString(string(k)) != nil {
+			// broken record. skip it
+			return nil
+		}
+		pin.Key = utils.CopyBytes(v)
+
+		list = append(list, pin)
+		return nil
+	})
+
+	return list, err
+}
+
+// Checks key of a node received in the handshake. Key is pinned if the node has no pinned key yet and
+// pin is true. Empty key means the connection is not encrypted, it is fine only if the key is not pinned
+func (n *Node) CheckNodeKey(addr net.NodeAddr, key []byte, pin bool) error {
+	pinned, err := n.GetNodeKey(addr)
+
+	if err != nil {
+		return err
+	}
+
+	if len(pinned) == 0 {
+		if pin && len(key) > 0 {
+			return n.PinNodeKey(addr, key)
+		}
+		return nil
+	}
+
+	if len(key) == 0 {
+		return errors.New(fmt.Sprintf("Node %s has pinned key. Connection must be encrypted", addr.NodeAddrToString()))
+	}
+
+	if !bytes.Equal(pinned, key) {
+		return errors.New(fmt.Sprintf("Key %s of node %s is different from pinned key %s",
+			net.KeyFingerprint(key), addr.NodeAddrToString(), net.KeyFingerprint(pinned)))
+	}
+	return nil
+}
+
+// Same node can be known as localhost and 127.0.0.1. It has one key
+func getNodeKeyID(addr net.NodeAddr) string {
+	return addr.Normalized().NodeAddrToString()
+}
@@ -1,15 +1,42 @@
 package nodemanager
 
 import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+
 	"github.com/gelembjuk/democoin/lib/net"
 )
 
+// Nodes list storage has own DB object. A node object can be used by many routines, its DB object
+// must not be opened and closed by all of them. Calls of the storage go one by one
 type NodesListStorage struct {
 	DBConn    *Database
 	SessionID string
+	lock      *sync.Mutex
+}
+
+func newNodesListStorage(db *Database, sessid string) NodesListStorage {
+	conn := db.Clone()
+
+	return NodesListStorage{&conn, sessid, &sync.Mutex{}}
+}
+
+// Locks the storage and opens the connection. Returned function must be called when the work is done
+func (s NodesListStorage) open(reason string) func() {
+	s.lock.Lock()
+
+	if s.DBConn.OpenConnectionIfNeeded(reason, s.SessionID) {
+		return func() {
+			s.DBConn.CloseConnection()
+			s.lock.Unlock()
+		}
+	}
+	return s.lock.Unlock
 }
 
 func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
+	defer s.open("GetNodes")()
 
 	nddb, err := s.DBConn.DB().GetNodesObject()
 
@@ -31,11 +58,8 @@ func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
 	return nodes, nil
 }
 func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
-	if !s.DBConn.CheckConnectionIsOpen() {
-		// if connection is not opened when this function is called, we have to close it
-		// we do this because this structre can be shared between threads.
-		defer s.DBConn.CloseConnection()
-	}
+	defer s.open("AddNodeToKnown")()
+
 	s.DBConn.Logger.Trace.Printf("AddNodeToKnown %s", addr.NodeAddrToString())
 
 	nddb, err := s.DBConn.DB().GetNodesObject()
@@ -51,11 +75,8 @@ func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
 	return
 }
 func (s NodesListStorage) RemoveNodeFromKnown(addr net.NodeAddr) {
-	if !s.DBConn.CheckConnectionIsOpen() {
-		// if connection is not opened when this function is called, we have to close it
-		// we do this because this structre can be shared between threads.
-		defer s.DBConn.CloseConnection()
-	}
+	defer s.open("RemoveNodeFromKnown")()
+
 	nddb, err := s.DBConn.DB().GetNodesObject()
 
 	if err != nil {
@@ -67,6 +88,7 @@ func (s NodesListStorage) RemoveNodeFromKnown(addr net.NodeAddr) {
 	return
 }
 func (s NodesListStorage) GetCountOfKnownNodes() (int, error) {
+	defer s.open("GetCountOfKnownNodes")()
 
 	nddb, err := s.DBConn.DB().GetNodesObject()
 
@@ -76,3 +98,50 @@ func (s NodesListStorage) GetCountOfKnownNodes() (int, error) {
 
 	return nddb.GetCount()
 }
+
+// Returns saved stats of a node. Nil if there are no stats
+func (s NodesListStorage) GetNodeStats(addr net.NodeAddr) (*net.NodeStats, error) {
+	defer s.open("GetNodeStats")()
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := nddb.GetNodeStats([]byte(addr.NodeAddrToString()))
+
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	stats := net.NodeStats{}
+
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&stats)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func (s NodesListStorage) SaveNodeStats(stats net.NodeStats) {
+	defer s.open("SaveNodeStats")()
+
+	nddb, err := s.DBConn.DB().GetNodesObject()
+
+	if err != nil {
+		return
+	}
+
+	var buff bytes.Buffer
+
+	err = gob.NewEncoder(&buff).Encode(stats)
+
+	if err != nil {
+		return
+	}
+
+	nddb.PutNodeStats([]byte(stats.Addr.NodeAddrToString()), buff.Bytes())
+}
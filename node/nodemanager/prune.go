@@ -0,0 +1,105 @@
+package nodemanager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/democoin/node/config"
+)
+
+// Deletes bodies of old blocks if the node works in prune mode.
+// Only PruneKeep top blocks are kept with full data. Headers and unspent outputs are kept for all blocks
+// Blocks are pruned from the bottom of the chain, so it continues from the last pruned block.
+// Must be executed in a DB transaction
+func (n *Node) pruneBlocks() error {
+	if n.PruneKeep < 1 {
+		return nil
+	}
+
+	if n.PruneKeep < config.MinPruneKeep {
+		// blocks of a reorg must stay with full data
+		return errors.New(fmt.Sprintf("Prune value %d is too small. At least %d blocks must be kept", n.PruneKeep, config.MinPruneKeep))
+	}
+
+	bcm, err := n.GetBCManager()
+
+	if err != nil {
+		return err
+	}
+
+	_, topHeight, err := bcm.GetState()
+
+	if err != nil {
+		return err
+	}
+
+	// blocks with this height and lower are pruned
+	pruneHeight := topHeight - n.PruneKeep
+
+	if pruneHeight < 1 {
+		return nil
+	}
+
+	bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcm.GetLastPrunedHash()
+
+	if err != nil {
+		return err
+	}
+
+	if len(hash) == 0 {
+		// genesis block is never pruned. start after it
+		hash, err = bcdb.GetFirstHash()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	txm := n.GetTransactionsManager()
+
+	for {
+		_, _, nextHash, err := bcdb.GetLocationInChain(hash)
+
+		if err != nil {
+			return err
+		}
+
+		if len(nextHash) == 0 {
+			break
+		}
+
+		block, err := bcm.GetBlock(nextHash)
+
+		if err != nil {
+			return err
+		}
+
+		if block.Height > pruneHeight {
+			break
+		}
+
+		n.Logger.Trace.Printf("Prune block %x at height %d", block.Hash, block.Height)
+
+		err = txm.BlockPruned(&block)
+
+		if err != nil {
+			return err
+		}
+
+		err = bcm.PruneBlock(&block)
+
+		if err != nil {
+			return err
+		}
+
+		hash = nextHash
+	}
+
+	return nil
+}
@@ -0,0 +1,582 @@
+package nodemanager
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/gelembjuk/democoin/node/consensus"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// UTXO snapshot file format. All numbers are big endian uint32, byte strings are prefixed with length.
+// The file starts with the magic string, the format version and the tip hash.
+// Then number of headers and headers of primary chain blocks from the genesis to the tip. PoW of every header is checked on load.
+// Then number of transactions and for every transaction ordered by ID: TX ID, number of outputs and outputs.
+// The file ends with sha256 digest of the tip hash and transactions records
+const utxoFileMagic = "DMUTXO"
+const utxoFileVersion = 2
+
+// limit for any byte string in the file. bigger is considered as broken file
+const utxoFileMaxItemSize = 16 * 1024 * 1024
+
+// Writes the UTXO set and headers of the chain to a file.
+// Returns the tip hash, number of transactions in the set and the digest of the set
+func (n *Node) DumpUnspentOutputs(filepath string) ([]byte, int, []byte, error) {
+	file, err := os.Create(filepath)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	var tipHash []byte
+	var digest []byte
+	count := 0
+
+	// all is read in one transaction. the set is consistent with the tip
+	err = n.DBConn.View(func() error {
+		bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		bcm, err := n.GetBCManager()
+
+		if err != nil {
+			return err
+		}
+
+		txm := n.GetTransactionsManager()
+
+		var tipHeight int
+
+		tipHash, tipHeight, err = bcm.GetState()
+
+		if err != nil {
+			return err
+		}
+
+		_, err = w.WriteString(utxoFileMagic)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileNumber(w, utxoFileVersion)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileBytes(w, tipHash)
+
+		if err != nil {
+			return err
+		}
+
+		// headers of all blocks. heights are from 0 to the tip height
+		err = writeFileNumber(w, tipHeight+1)
+
+		if err != nil {
+			return err
+		}
+
+		hash, err := bcdb.GetFirstHash()
+
+		if err != nil {
+			return err
+		}
+
+		headers := 0
+
+		for len(hash) > 0 {
+			block, err := bcm.GetBlock(hash)
+
+			if err != nil {
+				return err
+			}
+
+			header, err := block.GetHeader()
+
+			if err != nil {
+				return err
+			}
+
+			headerData, err := header.Serialize()
+
+			if err != nil {
+				return err
+			}
+
+			err = writeFileBytes(w, headerData)
+
+			if err != nil {
+				return err
+			}
+
+			headers++
+
+			_, _, hash, err = bcdb.GetLocationInChain(hash)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if headers != tipHeight+1 {
+			return errors.New(fmt.Sprintf("Chain index is broken. Found %d blocks for the tip height %d", headers, tipHeight))
+		}
+
+		total, err := txm.GetUnspentTransactionsCount()
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileNumber(w, total)
+
+		if err != nil {
+			return err
+		}
+
+		hasher := newUTXOFileHasher(tipHash)
+
+		rw := io.MultiWriter(w, hasher)
+
+		err = txm.ForEachUnspentTransaction(func(txID []byte, outputs []structures.TXOutputIndependent) error {
+			count++
+			return writeUTXOFileRecord(rw, txID, outputs)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if count != total {
+			return errors.New("Number of transactions in the UTXO set is changed while dumping")
+		}
+
+		digest = hasher.Sum(nil)
+
+		_, err = w.Write(digest)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return tipHash, count, digest, w.Flush()
+}
+
+// Creates new DB from the UTXO snapshot. The node must not have a blockchain yet.
+// Genesis hash must be set in the config, the first header must be that block.
+// Headers from the file are saved as pruned blocks, so the node works as pruned node after this
+// The digest of the file must be equal to the trusted digest. Anybody can make a file with correct
+// headers and own outputs, the digest in the file itself proves nothing
+// Returns the tip hash, number of transactions in the set and the digest of the set
+func (n *Node) LoadUnspentOutputs(filepath string, trustedDigest []byte) ([]byte, int, []byte, error) {
+	if len(trustedDigest) != sha256.Size {
+		return nil, 0, nil, errors.New("Trusted digest of the snapshot is required")
+	}
+
+	if n.BlockchainExist() {
+		return nil, 0, nil, errors.New("Blockchain already exists. Snapshot can be loaded only on new node")
+	}
+
+	if len(n.NodeNet.Genesis) == 0 {
+		return nil, 0, nil, errors.New("Genesis hash is not set in the config. It is needed to check the snapshot")
+	}
+
+	// first time the file is only verified. we don't want to create DB from broken file
+	var prevHeader *structures.BlockHeader
+
+	tipHash, count, digest, err := readUTXOFile(filepath, trustedDigest,
+		func(header *structures.BlockHeader) error {
+			valid, err := consensus.ValidateHeader(header)
+
+			if err != nil {
+				return err
+			}
+
+			if !valid {
+				return errors.New(fmt.Sprintf("Header %x has wrong PoW", header.Hash))
+			}
+
+			if prevHeader == nil {
+				if len(header.PrevBlockHash) > 0 || header.Height != 0 {
+					return errors.New("First header is not genesis block")
+				}
+
+				if bytes.Compare(header.Hash, n.NodeNet.Genesis) != 0 {
+					return errors.New(fmt.Sprintf("Genesis block %x is different from ours %x", header.Hash, n.NodeNet.Genesis))
+				}
+			} else if bytes.Compare(header.PrevBlockHash, prevHeader.Hash) != 0 ||
+				header.Height != prevHeader.Height+1 {
+				return errors.New(fmt.Sprintf("Header %x doesn't follow previous header", header.Hash))
+			}
+			prevHeader = header
+			return nil
+		},
+		func(txID []byte, outputs []structures.TXOutputIndependent) error {
+			return nil
+		})
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if prevHeader == nil || bytes.Compare(prevHeader.Hash, tipHash) != 0 {
+		return nil, 0, nil, errors.New("Last header is not the tip")
+	}
+
+	err = n.DBConn.InitDatabase()
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	err = n.DBConn.Update(func() error {
+		bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		txm := n.GetTransactionsManager()
+
+		_, _, _, err = readUTXOFile(filepath, trustedDigest,
+			func(header *structures.BlockHeader) error {
+				headerData, err := header.GetPrunedBlock().Serialize()
+
+				if err != nil {
+					return err
+				}
+
+				err = bcdb.PutBlock(header.Hash, headerData)
+
+				if err != nil {
+					return err
+				}
+
+				if len(header.PrevBlockHash) == 0 {
+					err = bcdb.SaveFirstHash(header.Hash)
+
+					if err != nil {
+						return err
+					}
+				}
+
+				return bcdb.AddToChain(header.Hash, header.PrevBlockHash)
+			},
+			func(txID []byte, outputs []structures.TXOutputIndependent) error {
+				return txm.AddUnspentTransaction(txID, outputs)
+			})
+
+		if err != nil {
+			return err
+		}
+
+		err = bcdb.SaveTopHash(tipHash)
+
+		if err != nil {
+			return err
+		}
+
+		// there are no full blocks. all is pruned up to the tip
+		return bcdb.SaveLastPrunedHash(tipHash)
+	})
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return tipHash, count, digest, nil
+}
+
+// Reads the UTXO snapshot file and executes callbacks for every header and every transaction.
+// Returns error if the digest is wrong or is different from the trusted digest
+func readUTXOFile(filepath string, trustedDigest []byte,
+	headerCallback func(header *structures.BlockHeader) error,
+	recordCallback func(txID []byte, outputs []structures.TXOutputIndependent) error) ([]byte, int, []byte, error) {
+
+	file, err := os.Open(filepath)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(utxoFileMagic))
+
+	_, err = io.ReadFull(r, magic)
+
+	if err != nil || bytes.Compare(magic, []byte(utxoFileMagic)) != 0 {
+		return nil, 0, nil, errors.New("The file is not a UTXO snapshot file")
+	}
+
+	version, err := readFileNumber(r)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if version != utxoFileVersion {
+		return nil, 0, nil, errors.New(fmt.Sprintf("UTXO snapshot file version %d is not supported", version))
+	}
+
+	tipHash, err := readFileBytes(r)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	headers, err := readFileNumber(r)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	for i := 0; i < headers; i++ {
+		headerData, err := readFileBytes(r)
+
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		header := &structures.BlockHeader{}
+
+		err = header.DeserializeHeader(headerData)
+
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		err = headerCallback(header)
+
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	count, err := readFileNumber(r)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	hasher := newUTXOFileHasher(tipHash)
+
+	// all records data goes to the hasher too
+	rr := io.TeeReader(r, hasher)
+
+	for i := 0; i < count; i++ {
+		txID, outputs, err := readUTXOFileRecord(rr)
+
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		err = recordCallback(txID, outputs)
+
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	digest := make([]byte, sha256.Size)
+
+	_, err = io.ReadFull(r, digest)
+
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if bytes.Compare(digest, hasher.Sum(nil)) != 0 {
+		return nil, 0, nil, errors.New("Digest of the UTXO snapshot is wrong")
+	}
+
+	if bytes.Compare(digest, trustedDigest) != 0 {
+		return nil, 0, nil, errors.New(fmt.Sprintf("Digest of the UTXO snapshot %x is different from trusted digest %x", digest, trustedDigest))
+	}
+
+	return tipHash, count, digest, nil
+}
+
+// Digest of the snapshot depends on the tip it was made for
+func newUTXOFileHasher(tipHash []byte) hash.Hash {
+	hasher := sha256.New()
+	hasher.Write(tipHash)
+
+	return hasher
+}
+
+// Writes TX ID and its outputs. Only fixed size numbers and byte strings are used, so the data is deterministic
+func writeUTXOFileRecord(w io.Writer, txID []byte, outputs []structures.TXOutputIndependent) error {
+	err := writeFileBytes(w, txID)
+
+	if err != nil {
+		return err
+	}
+
+	err = writeFileNumber(w, len(outputs))
+
+	if err != nil {
+		return err
+	}
+
+	for _, out := range outputs {
+		err = writeFileNumber(w, out.OIndex)
+
+		if err != nil {
+			return err
+		}
+
+		err = binary.Write(w, binary.BigEndian, out.Value)
+
+		if err != nil {
+			return err
+		}
+
+		isBase := uint8(0)
+
+		if out.IsBase {
+			isBase = 1
+		}
+
+		err = binary.Write(w, binary.BigEndian, isBase)
+
+		if err != nil {
+			return err
+		}
+
+		for _, data := range [][]byte{out.DestPubKeyHash, out.SendPubKeyHash, out.BlockHash} {
+			err = writeFileBytes(w, data)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readUTXOFileRecord(r io.Reader) ([]byte, []structures.TXOutputIndependent, error) {
+	txID, err := readFileBytes(r)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count, err := readFileNumber(r)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outputs := []structures.TXOutputIndependent{}
+
+	for i := 0; i < count; i++ {
+		out := structures.TXOutputIndependent{}
+		out.TXID = txID
+
+		out.OIndex, err = readFileNumber(r)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = binary.Read(r, binary.BigEndian, &out.Value)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var isBase uint8
+
+		err = binary.Read(r, binary.BigEndian, &isBase)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		out.IsBase = isBase == 1
+
+		for _, data := range []*[]byte{&out.DestPubKeyHash, &out.SendPubKeyHash, &out.BlockHash} {
+			*data, err = readFileBytes(r)
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		outputs = append(outputs, out)
+	}
+
+	return txID, outputs, nil
+}
+
+func writeFileNumber(w io.Writer, number int) error {
+	return binary.Write(w, binary.BigEndian, uint32(number))
+}
+
+func readFileNumber(r io.Reader) (int, error) {
+	var number uint32
+
+	err := binary.Read(r, binary.BigEndian, &number)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(number), nil
+}
+
+func writeFileBytes(w io.Writer, data []byte) error {
+	err := writeFileNumber(w, len(data))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+func readFileBytes(r io.Reader) ([]byte, error) {
+	length, err := readFileNumber(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if length > utxoFileMaxItemSize {
+		return nil, errors.New(fmt.Sprintf("Wrong data size %d in the file", length))
+	}
+
+	data := make([]byte, length)
+
+	_, err = io.ReadFull(r, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
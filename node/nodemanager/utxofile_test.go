@@ -0,0 +1,90 @@
+package nodemanager
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gelembjuk/democoin/node/structures"
+	assert "github.com/stretchr/testify/require"
+)
+
+// Writes a snapshot file without headers. The digest is correct for the written outputs
+func writeTestUTXOFile(filepath string, tipHash []byte, txID []byte, outputs []structures.TXOutputIndependent) ([]byte, error) {
+	file, err := os.Create(filepath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	w.WriteString(utxoFileMagic)
+	writeFileNumber(w, utxoFileVersion)
+	writeFileBytes(w, tipHash)
+	writeFileNumber(w, 0)
+	writeFileNumber(w, 1)
+
+	hasher := newUTXOFileHasher(tipHash)
+
+	err = writeUTXOFileRecord(io.MultiWriter(w, hasher), txID, outputs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	digest := hasher.Sum(nil)
+
+	w.Write(digest)
+
+	return digest, w.Flush()
+}
+
+func TestUTXOFileTrustedDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxofile")
+
+	assert.NoError(t, err, "Can not create temp folder")
+
+	defer os.RemoveAll(dir)
+
+	tipHash := []byte{1, 2, 3, 4}
+	txID := []byte{5, 6, 7, 8}
+	outputs := []structures.TXOutputIndependent{
+		structures.TXOutputIndependent{Value: 10, DestPubKeyHash: []byte{1, 1}, OIndex: 0}}
+
+	goodDigest, err := writeTestUTXOFile(dir+"/good", tipHash, txID, outputs)
+
+	assert.NoError(t, err, "Can not write good file")
+
+	// same tip, more money. the digest in the file is made for changed outputs
+	outputs[0].Value = 1000
+
+	badDigest, err := writeTestUTXOFile(dir+"/bad", tipHash, txID, outputs)
+
+	assert.NoError(t, err, "Can not write tampered file")
+
+	noop := func(header *structures.BlockHeader) error { return nil }
+	norecord := func(txID []byte, outputs []structures.TXOutputIndependent) error { return nil }
+
+	_, count, _, err := readUTXOFile(dir+"/good", goodDigest, noop, norecord)
+
+	assert.NoError(t, err, "Good file must be accepted")
+	assert.Equal(t, 1, count, "Wrong number of transactions")
+
+	// the tampered file is consistent with own digest
+	_, _, _, err = readUTXOFile(dir+"/bad", badDigest, noop, norecord)
+
+	assert.NoError(t, err, "Tampered file must be self-consistent")
+
+	_, _, _, err = readUTXOFile(dir+"/bad", goodDigest, noop, norecord)
+
+	assert.Error(t, err, "Tampered file must be refused with trusted digest")
+
+	_, _, _, err = (&Node{}).LoadUnspentOutputs(dir+"/bad", nil)
+
+	assert.Error(t, err, "File must be refused without trusted digest")
+}
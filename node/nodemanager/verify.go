@@ -0,0 +1,135 @@
+package nodemanager
+
+import (
+	"fmt"
+
+	"github.com/gelembjuk/democoin/node/consensus"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Checks consistency of the DB. Goes over primary chain from the top down to given depth (0 means all chain).
+// For every block checks the block data, chain index, transactions index and unspent outputs.
+// Only headers of pruned blocks are checked.
+// If repair is true then all is done in one DB transaction and fixable problems are fixed.
+// Returns number of checked blocks and list of found problems
+func (n *Node) VerifyChain(depth int, repair bool) (int, []string, error) {
+	count := 0
+	problems := []string{}
+
+	verify := func() error {
+		bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+		if err != nil {
+			return err
+		}
+
+		bcm, err := n.GetBCManager()
+
+		if err != nil {
+			return err
+		}
+
+		txm := n.GetTransactionsManager()
+
+		topHash, err := bcdb.GetTopHash()
+
+		if err != nil {
+			return err
+		}
+
+		hash := topHash
+		nextHash := []byte{}
+
+		for len(hash) > 0 && (depth == 0 || count < depth) {
+			blockData, err := bcdb.GetBlock(hash)
+
+			if err != nil {
+				return err
+			}
+
+			if blockData == nil {
+				problems = append(problems, fmt.Sprintf("Block %x is not found", hash))
+				break
+			}
+
+			block := &structures.Block{}
+
+			err = block.DeserializeBlock(blockData)
+
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Block %x can not be decoded: %s", hash, err.Error()))
+				break
+			}
+
+			count++
+
+			bproblems, err := bcm.VerifyBlock(block, hash, nextHash, repair)
+
+			if err != nil {
+				return err
+			}
+
+			problems = append(problems, bproblems...)
+
+			var valid bool
+
+			if block.IsPruned() {
+				// only header is kept. check its PoW with the saved hash of transactions
+				header, err := block.GetHeader()
+
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("Block %x header can not be built: %s", hash, err.Error()))
+				} else {
+					valid, err = consensus.ValidateHeader(header)
+
+					if err != nil {
+						return err
+					}
+
+					if !valid {
+						problems = append(problems, fmt.Sprintf("Block %x has wrong proof of work", block.Hash))
+					}
+				}
+				nextHash = hash
+				hash = block.PrevBlockHash
+				continue
+			}
+
+			valid, err = consensus.NewProofOfWork(block).Validate()
+
+			if err != nil {
+				return err
+			}
+
+			if !valid {
+				problems = append(problems, fmt.Sprintf("Block %x has wrong proof of work", block.Hash))
+			}
+
+			tproblems, err := txm.VerifyBlockCaches(block, topHash, repair)
+
+			if err != nil {
+				return err
+			}
+
+			problems = append(problems, tproblems...)
+
+			nextHash = hash
+			hash = block.PrevBlockHash
+		}
+		return nil
+	}
+
+	var err error
+
+	if repair {
+		err = n.DBConn.Update(verify)
+	} else {
+		err = n.DBConn.View(verify)
+	}
+
+	if err != nil {
+		return count, nil, err
+	}
+
+	return count, problems, nil
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Peer is banned when its score reaches this value
+const banScoreThreshold = 100
+
+// Scores for protocol violations. Block with wrong PoW costs nothing to make, the peer is banned at once
+const banScoreInvalidPoW = 100
+const banScoreInvalidBlock = 50
+const banScoreInvalidTransaction = 10
+const banScoreMalformedData = 20
+
+// Score of a peer is forgotten if it did not misbehave for this time. Rare mistakes of a good peer
+// must not add up to a ban
+const banScoreExpireTime = 24 * time.Hour
+
+// How often expired scores and bans are removed
+const banCleanInterval = time.Hour
+
+// Counts misbehavior of peers and bans them. Bans are saved in the nodes DB,
+// the list is kept in memory to check every connection quickly
+type peerBans struct {
+	S      *NodeServer
+	Logger *utils.LoggerMan
+	lock   *sync.Mutex
+	scores map[string]*peerScore
+	banned map[string]nodeclient.ComBannedNode
+	stop   chan struct{}
+}
+
+type peerScore struct {
+	score   int
+	updated time.Time // last misbehavior
+}
+
+func newPeerBans(s *NodeServer) *peerBans {
+	b := &peerBans{}
+	b.S = s
+	b.Logger = s.Logger
+	b.lock = &sync.Mutex{}
+	b.scores = map[string]*peerScore{}
+	b.banned = map[string]nodeclient.ComBannedNode{}
+	b.stop = make(chan struct{})
+
+	return b
+}
+
+// Starts routine that removes expired scores and bans
+func (b *peerBans) Start() {
+	go b.loop()
+}
+
+func (b *peerBans) Stop() {
+	close(b.stop)
+}
+
+func (b *peerBans) loop() {
+	ticker := time.NewTicker(banCleanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			err := b.RemoveExpired()
+
+			if err != nil {
+				b.Logger.Error.Println("Can not remove expired bans: ", err.Error())
+			}
+		}
+	}
+}
+
+// Forgets old scores and removes expired bans from memory and from the DB
+func (b *peerBans) RemoveExpired() error {
+	now := time.Now()
+
+	b.lock.Lock()
+
+	for host, score := range b.scores {
+		if score.updated.Add(banScoreExpireTime).Before(now) {
+			delete(b.scores, host)
+		}
+	}
+
+	for host, ban := range b.banned {
+		if ban.Until <= now.Unix() {
+			delete(b.banned, host)
+		}
+	}
+
+	b.lock.Unlock()
+
+	return b.S.CloneNode().RemoveExpiredBans()
+}
+
+// Loads bans from the DB. Expired bans are removed
+func (b *peerBans) Load() error {
+	node := b.S.CloneNode()
+
+	err := node.RemoveExpiredBans()
+
+	if err != nil {
+		return err
+	}
+
+	bans, err := node.GetBannedNodes()
+
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, ban := range bans {
+		b.banned[ban.Host] = ban
+	}
+	return nil
+}
+
+// Checks if connections from a host must be refused
+func (b *peerBans) IsBanned(host string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ban, ok := b.banned[host]
+
+	if !ok {
+		return false
+	}
+
+	if ban.Until <= time.Now().Unix() {
+		// record stays in DB. it is removed by RemoveExpired
+		delete(b.banned, host)
+		return false
+	}
+	return true
+}
+
+// Increases score of a peer. The peer is banned when the score reaches the threshold.
+// Score of a peer that did not misbehave for long time starts from 0. Loopback hosts are never banned,
+// same as they are not limited
+func (b *peerBans) Misbehaving(host string, score int, reason string) {
+	if host == "" {
+		return
+	}
+
+	if isLoopbackIP(host) {
+		b.Logger.Trace.Printf("Peer %s misbehaving: %s. Loopback host is not scored", host, reason)
+		return
+	}
+
+	now := time.Now()
+
+	b.lock.Lock()
+
+	ps, ok := b.scores[host]
+
+	if !ok || ps.updated.Add(banScoreExpireTime).Before(now) {
+		ps = &peerScore{}
+		b.scores[host] = ps
+	}
+
+	ps.score += score
+	ps.updated = now
+	total := ps.score
+
+	b.lock.Unlock()
+
+	b.Logger.Trace.Printf("Peer %s misbehaving: %s. Score %d", host, reason, total)
+
+	if total < banScoreThreshold {
+		return
+	}
+
+	_, err := b.Ban(host, 0, reason)
+
+	if err != nil {
+		b.Logger.Error.Printf("Can not ban %s: %s", host, err.Error())
+	}
+}
+
+// Bans a host for duration in seconds. Sessions with this host are closed
+func (b *peerBans) Ban(host string, duration int, reason string) (nodeclient.ComBannedNode, error) {
+	ban, err := b.S.CloneNode().BanNode(host, duration, reason)
+
+	if err != nil {
+		return ban, err
+	}
+
+	b.lock.Lock()
+
+	b.banned[host] = ban
+	delete(b.scores, host)
+
+	b.lock.Unlock()
+
+	if b.S.Peers != nil {
+		b.S.Peers.CloseHost(host)
+	}
+	return ban, nil
+}
+
+// Removes a ban of a host
+func (b *peerBans) Unban(host string) error {
+	err := b.S.CloneNode().UnbanNode(host)
+
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.banned, host)
+	delete(b.scores, host)
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+	"github.com/gelembjuk/democoin/node/consensus"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Makes compact block. Every transaction is presented with short ID, coinbase transaction is sent full.
+// Other node can not have it in the pool
+func makeCompactBlock(block *structures.Block) (nodeclient.ComCompactBlock, error) {
+	cblock := nodeclient.ComCompactBlock{}
+
+	header, err := block.GetHeader()
+
+	if err != nil {
+		return cblock, err
+	}
+
+	cblock.Header, err = header.Serialize()
+
+	if err != nil {
+		return cblock, err
+	}
+
+	cblock.ShortIDs = [][]byte{}
+	cblock.Prefilled = [][]byte{}
+
+	for _, tx := range block.Transactions {
+		cblock.ShortIDs = append(cblock.ShortIDs, structures.ShortTransactionID(block.Hash, tx.ID))
+
+		txdata := []byte{}
+
+		if tx.IsCoinbase() {
+			txdata, err = tx.Serialize()
+
+			if err != nil {
+				return cblock, err
+			}
+		}
+		cblock.Prefilled = append(cblock.Prefilled, txdata)
+	}
+	return cblock, nil
+}
+
+// Compact block received from other node. Transactions are taken from our pool,
+// missed transactions are requested from the node. Full block is requested if the block can not be rebuilt.
+// The block is added in a write transaction after it is rebuilt
+func (s *NodeServerRequest) handleCompactBlock() error {
+	var payload nodeclient.ComCompactBlock
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	header := &structures.BlockHeader{}
+
+	err = header.DeserializeHeader(payload.Header)
+
+	if err == nil && len(payload.ShortIDs) != len(payload.Prefilled) {
+		err = errors.New("Wrong number of prefilled transactions")
+	}
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	valid, err := consensus.ValidateHeader(header)
+
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		s.misbehaving(banScoreInvalidPoW, "Wrong PoW of compact block")
+		return errors.New(fmt.Sprintf("Compact block %x has wrong PoW", header.Hash))
+	}
+
+	blockstate, err := s.Node.NodeBC.CheckBlockState(header.Hash, header.PrevBlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	if blockstate == 1 {
+		// we have it already
+		return nil
+	}
+
+	if blockstate == 2 {
+		// previous block is not in the blockchain
+		if s.S.Sync == nil || !s.S.Sync.Start(payload.AddrFrom) {
+			s.Node.NodeClient.SendGetData(payload.AddrFrom, "block", header.Hash)
+		}
+		return nil
+	}
+
+	s.Logger.Trace.Printf("SessID: %s . Compact block %x with %d transactions", s.SessID, header.Hash, len(payload.ShortIDs))
+
+	// missed transactions are requested before the write transaction. Other writers don't wait for the node
+	block, err := s.rebuildCompactBlock(payload, header)
+
+	if err != nil {
+		s.Logger.Trace.Printf("Can not rebuild block %x: %s. Request full block", header.Hash, err.Error())
+
+		return s.Node.NodeClient.SendGetData(payload.AddrFrom, "block", header.Hash)
+	}
+
+	// state of the block is checked again, other routine could add it while we waited
+	err = s.Node.DBConn.Update(func() error {
+		return s.addBlockFromPeer(payload.AddrFrom, block)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.checkAddressKnown(payload.AddrFrom)
+
+	return nil
+}
+
+// Makes full block from compact block. Transactions are found in our pool by short IDs, others are requested
+func (s *NodeServerRequest) rebuildCompactBlock(payload nodeclient.ComCompactBlock, header *structures.BlockHeader) (*structures.Block, error) {
+	pooltxs, err := s.Node.GetTransactionsManager().GetUnapprovedTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := map[string]*structures.Transaction{}
+
+	for _, tx := range pooltxs {
+		pool[hex.EncodeToString(structures.ShortTransactionID(header.Hash, tx.ID))] = tx
+	}
+
+	txs := make([]*structures.Transaction, len(payload.ShortIDs))
+	missed := []int{}
+
+	for i, shortID := range payload.ShortIDs {
+		if len(payload.Prefilled[i]) > 0 {
+			tx := &structures.Transaction{}
+
+			err := tx.DeserializeTransaction(payload.Prefilled[i])
+
+			if err != nil {
+				return nil, err
+			}
+			txs[i] = tx
+			continue
+		}
+
+		if tx, ok := pool[hex.EncodeToString(shortID)]; ok {
+			txs[i] = tx
+			continue
+		}
+
+		missed = append(missed, i)
+	}
+
+	if len(missed) > 0 {
+		s.Logger.Trace.Printf("Request %d missed transactions of block %x", len(missed), header.Hash)
+
+		txsdata, err := s.Node.NodeClient.SendGetBlockTxs(payload.AddrFrom, header.Hash, missed)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(txsdata) != len(missed) {
+			return nil, errors.New(fmt.Sprintf("Received %d transactions, requested %d", len(txsdata), len(missed)))
+		}
+
+		for j, i := range missed {
+			tx := &structures.Transaction{}
+
+			err := tx.DeserializeTransaction(txsdata[j])
+
+			if err != nil {
+				return nil, err
+			}
+			txs[i] = tx
+		}
+	}
+
+	block := &structures.Block{}
+	block.Timestamp = header.Timestamp
+	block.Transactions = txs
+	block.PrevBlockHash = header.PrevBlockHash
+	block.Hash = header.Hash
+	block.Nonce = header.Nonce
+	block.Height = header.Height
+
+	// other transactions can have same short ID. Hash of transactions shows the block is same as was made
+	txshash, err := block.HashTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(txshash, header.TxHash) {
+		return nil, errors.New("Transactions are different from the header")
+	}
+
+	return block, nil
+}
+
+// Returns transactions of a block by indexes. Other node requests them if they are not in its pool
+func (s *NodeServerRequest) handleGetBlockTxs() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetBlockTxs
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	block, err := s.Node.NodeBC.GetBlock(payload.Hash)
+
+	if err != nil {
+		return err
+	}
+
+	if block.IsPruned() {
+		return errors.New(fmt.Sprintf("Block %x is pruned. Can not return transactions", payload.Hash))
+	}
+
+	result := nodeclient.ComBlockTxs{}
+	result.Transactions = [][]byte{}
+
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			s.misbehaving(banScoreMalformedData, "Wrong index of a transaction")
+			return errors.New(fmt.Sprintf("Block %x has no transaction %d", payload.Hash, i))
+		}
+
+		txdata, err := block.Transactions[i].Serialize()
+
+		if err != nil {
+			return err
+		}
+		result.Transactions = append(result.Transactions, txdata)
+	}
+
+	s.Logger.Trace.Printf("Return %d transactions of block %x", len(result.Transactions), payload.Hash)
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
+}
@@ -20,12 +20,23 @@ import (
 )
 
 type NodeDaemon struct {
-	Port    int
-	Host    string
-	DataDir string
-	Server  *NodeServer
-	Logger  *utils.LoggerMan
-	Node    *nodemanager.Node
+	Port           int
+	Host           string
+	Bind           string
+	MaxConnections int
+	Encrypt        bool
+	Seeds          []string
+	LanDiscovery   bool
+	RPCPort        int
+	RPCHost        string
+	RPCUser        string
+	RPCPassword    string
+	RESTPort       int
+	RESTHost       string
+	DataDir        string
+	Server         *NodeServer
+	Logger         *utils.LoggerMan
+	Node           *nodemanager.Node
 }
 
 func (n *NodeDaemon) Init() error {
@@ -51,6 +62,16 @@ func (n *NodeDaemon) createServer() error {
 
 	server.NodeAddress.Port = n.Port
 	server.NodeAddress.Host = n.Host
+	server.BindHost = n.Bind
+	server.MaxConnections = n.MaxConnections
+	server.Encrypt = n.Encrypt
+	server.LanDiscovery = n.LanDiscovery
+	server.RPCPort = n.RPCPort
+	server.RPCHost = n.RPCHost
+	server.RPCUser = n.RPCUser
+	server.RPCPassword = n.RPCPassword
+	server.RESTPort = n.RESTPort
+	server.RESTHost = n.RESTHost
 
 	server.DataDir = n.DataDir
 
@@ -155,6 +176,18 @@ func (n *NodeDaemon) StartServer() error {
 		"-minter=" + n.Server.Node.MinterAddress + " " +
 		"-port=" + strconv.Itoa(n.Port) + " " +
 		"-host=" + n.Host + " " +
+		"-bind=" + n.Bind + " " +
+		"-prune=" + strconv.Itoa(n.Server.Node.PruneKeep) + " " +
+		"-bantime=" + strconv.Itoa(n.Server.Node.BanTime) + " " +
+		"-maxconnections=" + strconv.Itoa(n.MaxConnections) + " " +
+		"-encrypt=" + strconv.FormatBool(n.Encrypt) + " " +
+		"-seeds=" + strings.Join(n.Seeds, ",") + " " +
+		"-landiscovery=" + strconv.FormatBool(n.LanDiscovery) + " " +
+		"-rpcport=" + strconv.Itoa(n.RPCPort) + " " +
+		"-rpchost=" + n.RPCHost + " " +
+		"-rpcuser=" + n.RPCUser + " " +
+		"-restport=" + strconv.Itoa(n.RESTPort) + " " +
+		"-resthost=" + n.RESTHost + " " +
 		"-logs=" + logsstate
 
 	n.Logger.Trace.Println("Execute command : ", command)
@@ -166,7 +199,21 @@ func (n *NodeDaemon) StartServer() error {
 		"-minter="+n.Server.Node.MinterAddress,
 		"-port="+strconv.Itoa(n.Port),
 		"-host="+n.Host,
+		"-bind="+n.Bind,
+		"-prune="+strconv.Itoa(n.Server.Node.PruneKeep),
+		"-bantime="+strconv.Itoa(n.Server.Node.BanTime),
+		"-maxconnections="+strconv.Itoa(n.MaxConnections),
+		"-encrypt="+strconv.FormatBool(n.Encrypt),
+		"-seeds="+strings.Join(n.Seeds, ","),
+		"-landiscovery="+strconv.FormatBool(n.LanDiscovery),
+		"-rpcport="+strconv.Itoa(n.RPCPort),
+		"-rpchost="+n.RPCHost,
+		"-rpcuser="+n.RPCUser,
+		"-restport="+strconv.Itoa(n.RESTPort),
+		"-resthost="+n.RESTHost,
 		"-logs="+logsstate)
+	// command line of a process is visible to all users. The password is passed in the environment
+	cmd.Env = append(os.Environ(), config.RPCPasswordEnv+"="+n.RPCPassword)
 	cmd.Start()
 	n.Logger.Trace.Println("Daemon process ID is : ", cmd.Process.Pid)
 	n.savePIDFile(cmd.Process.Pid, n.Port, "", "n")
@@ -299,7 +346,7 @@ func (n *NodeDaemon) DaemonizeServer() error {
 		// to force server to try to handle next command if there were no input connects
 		// if we don't do this it will stay in "Accepting" mode and can not real channel
 		n.Logger.Trace.Println("Send void command on port ", server.NodeAddress.Port)
-		serverAddr := net.NodeAddr{"localhost", server.NodeAddress.Port}
+		serverAddr := server.getLocalAddress()
 
 		nodeclient := server.GetClient()
 
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"time"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Multicast group where nodes announce themselves in local network
+const lanDiscoveryAddress = "239.255.67.67:20067"
+
+// How often a node announces itself
+const lanDiscoveryInterval = 30 * time.Second
+
+// Max size of an announcement. Bigger packets are not parsed
+const lanDiscoveryMaxSize = 512
+
+// Announcement of a node in local network. Host of the node is the sender address of the packet
+type lanAnnouncement struct {
+	Magic    uint32
+	Genesis  []byte // nodes of other chains are not added
+	Port     int
+	Instance string // random ID of the sender. Own announcements are skipped
+}
+
+// Announces this node in local network and adds nodes of same chain that announce themselves
+type lanDiscovery struct {
+	S        *NodeServer
+	Logger   *utils.LoggerMan
+	group    *net.UDPAddr
+	conn     *net.UDPConn
+	genesis  []byte
+	instance string
+	stop     chan struct{}
+}
+
+func newLanDiscovery(s *NodeServer) *lanDiscovery {
+	d := &lanDiscovery{}
+	d.S = s
+	d.Logger = s.Logger
+	d.instance = utils.RandString(16)
+	d.stop = make(chan struct{})
+
+	return d
+}
+
+// Joins the multicast group and starts routines to announce this node and to listen other nodes
+func (d *lanDiscovery) Start() error {
+	var err error
+
+	d.group, err = net.ResolveUDPAddr("udp4", lanDiscoveryAddress)
+
+	if err != nil {
+		return err
+	}
+
+	node := d.S.CloneNode()
+
+	err = node.DBConn.OpenConnection("LanDiscovery", utils.RandString(5))
+
+	if err != nil {
+		return err
+	}
+
+	d.genesis, err = node.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+	node.DBConn.CloseConnection()
+
+	if err != nil {
+		return err
+	}
+
+	d.conn, err = net.ListenMulticastUDP("udp4", nil, d.group)
+
+	if err != nil {
+		return err
+	}
+
+	d.Logger.Trace.Printf("LAN discovery in group %s", lanDiscoveryAddress)
+
+	go d.listen()
+	go d.announce()
+
+	return nil
+}
+
+func (d *lanDiscovery) Stop() {
+	close(d.stop)
+	d.conn.Close()
+}
+
+func (d *lanDiscovery) announce() {
+	ticker := time.NewTicker(lanDiscoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		err := d.sendAnnouncement()
+
+		if err != nil {
+			d.Logger.Trace.Printf("LAN announcement is not sent: %s", err.Error())
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *lanDiscovery) sendAnnouncement() error {
+	announcement := lanAnnouncement{netlib.NetworkMagic, d.genesis, d.S.NodeAddress.Port, d.instance}
+
+	data, err := netlib.GobEncode(announcement)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", nil, d.group)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write(data)
+
+	return err
+}
+
+func (d *lanDiscovery) listen() {
+	buf := make([]byte, lanDiscoveryMaxSize)
+
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+
+		if err != nil {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+
+			d.Logger.Trace.Printf("LAN discovery read error: %s", err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+
+		d.received(buf[:n], from)
+	}
+}
+
+// Adds the node from an announcement if it is a node of same chain and it is not known yet
+func (d *lanDiscovery) received(data []byte, from *net.UDPAddr) {
+	announcement := lanAnnouncement{}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&announcement)
+
+	if err != nil || announcement.Magic != netlib.NetworkMagic || announcement.Instance == d.instance {
+		return
+	}
+
+	host := from.IP.String()
+
+	if !bytes.Equal(announcement.Genesis, d.genesis) {
+		d.Logger.Trace.Printf("Node %s in local network has other chain", host)
+		return
+	}
+
+	if announcement.Port < 1 || announcement.Port > 65535 {
+		return
+	}
+
+	if d.S.Bans != nil && d.S.Bans.IsBanned(host) {
+		return
+	}
+
+	addr := netlib.NodeAddr{Host: host, Port: announcement.Port}
+
+	if d.S.Node.NodeNet.CheckIsKnown(addr) {
+		return
+	}
+
+	d.Logger.Trace.Printf("Found node %s in local network", addr.NodeAddrToString())
+
+	// this works in the discovery routine. the server node object must not be used for requests here
+	d.S.CloneNode().AddNodeToKnown(addr, netlib.NodeSourceLAN, true)
+
+	// the clone has own copy of the nodes list
+	d.S.Node.NodeNet.AddNodeToKnownFrom(addr, netlib.NodeSourceLAN)
+}
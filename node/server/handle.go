@@ -10,6 +10,7 @@ import (
 	"github.com/gelembjuk/democoin/lib/nodeclient"
 	"github.com/gelembjuk/democoin/lib/utils"
 	"github.com/gelembjuk/democoin/node/blockchain"
+	"github.com/gelembjuk/democoin/node/consensus"
 	"github.com/gelembjuk/democoin/node/nodemanager"
 	"github.com/gelembjuk/democoin/node/structures"
 	"github.com/gelembjuk/democoin/node/transactions"
@@ -20,11 +21,14 @@ type NodeServerRequest struct {
 	S                 *NodeServer
 	Request           []byte
 	RequestIP         string
+	Session           *peerSession // session where the request is received. nil for requests in separate connections
 	Logger            *utils.LoggerMan
 	HasResponse       bool
 	Response          []byte
 	NodeAuthStrIsGood bool
 	SessID            string
+	// executed when the DB transaction of the request is finished. Data is sent to other nodes here
+	afterCommit []func()
 }
 
 func (s *NodeServerRequest) Init() {
@@ -32,6 +36,13 @@ func (s *NodeServerRequest) Init() {
 	s.Response = nil
 }
 
+// Adds a function to execute after the DB transaction of the request is finished.
+// Other nodes must not be told about new data before they can request it, and other DB users
+// must not wait while data is sent
+func (s *NodeServerRequest) onCommit(f func()) {
+	s.afterCommit = append(s.afterCommit, f)
+}
+
 // Reads and parses request from network data
 func (s *NodeServerRequest) parseRequestData(payload interface{}) error {
 	var buff bytes.Buffer
@@ -47,6 +58,54 @@ func (s *NodeServerRequest) parseRequestData(payload interface{}) error {
 	return nil
 }
 
+// Increases ban score of the node that sent the request
+func (s *NodeServerRequest) misbehaving(score int, reason string) {
+	if s.S.Bans != nil {
+		s.S.Bans.Misbehaving(s.RequestIP, score, reason)
+	}
+}
+
+// Adds address of other node to known nodes. Node server checks the address before
+func (s *NodeServerRequest) checkAddressKnown(addr net.NodeAddr) {
+	if s.S.Peers == nil {
+		s.Node.CheckAddressKnown(addr)
+		return
+	}
+	s.S.Peers.CheckAddressKnown(addr)
+}
+
+// Remembers that the node of the session knows a transaction. It will not be announced to the node.
+// Requests in separate connections are skipped, address in them is sent by the node itself
+func (s *NodeServerRequest) setTransactionKnown(txID []byte) {
+	if s.S.TxRelay == nil || s.Session == nil {
+		return
+	}
+
+	s.S.TxRelay.SetKnown(s.Session.Addr, txID)
+}
+
+// Ban score for an error of block adding. Only a block that breaks consensus rules is a fault of a peer.
+// Errors of our DB, missed inputs or refused reorg are not
+func blockErrorBanScore(err error) int {
+	verr, ok := err.(*consensus.BlockVerifyError)
+
+	if !ok {
+		return 0
+	}
+
+	if verr.GetKind() == consensus.BlockVerifyErrorPoW {
+		return banScoreInvalidPoW
+	}
+	return banScoreInvalidBlock
+}
+
+// Only transaction with wrong signatures or amounts is a fault of a peer
+func isInvalidTransactionError(err error) bool {
+	txerr, ok := err.(*transactions.TXVerifyError)
+
+	return ok && txerr.GetKind() == transactions.TXVerifyErrorInvalid
+}
+
 // Find and return the list of unspent transactions
 func (s *NodeServerRequest) handleGetUnspent() error {
 	s.HasResponse = true
@@ -184,7 +243,9 @@ func (s *NodeServerRequest) handleTxFull() error {
 	TX := structures.Transaction{}
 	TX.DeserializeTransaction(payload.TX)
 
-	err = s.Node.GetTransactionsManager().ReceivedNewTransaction(&TX)
+	err = s.Node.DBConn.Update(func() error {
+		return s.Node.GetTransactionsManager().ReceivedNewTransaction(&TX)
+	})
 
 	if err != nil {
 		return errors.New(fmt.Sprintf("Transaction accepting error: %s", err.Error()))
@@ -218,7 +279,13 @@ func (s *NodeServerRequest) handleTxData() error {
 		return err
 	}
 
-	TX, err := s.Node.GetTransactionsManager().ReceivedNewTransactionData(payload.TX, payload.Signatures)
+	var TX *structures.Transaction
+
+	err = s.Node.DBConn.Update(func() error {
+		var err error
+		TX, err = s.Node.GetTransactionsManager().ReceivedNewTransactionData(payload.TX, payload.Signatures)
+		return err
+	})
 
 	if err != nil {
 		return errors.New(fmt.Sprintf("Transaction accepting error: %s", err.Error()))
@@ -293,6 +360,10 @@ func (s *NodeServerRequest) handleGetFirstBlocks() error {
 	result.Height = height
 
 	for _, block := range blocks {
+		if block.IsPruned() {
+			return errors.New("Blocks are pruned on this node. Can not return first blocks")
+		}
+
 		blockdata, err := block.Serialize()
 
 		if err != nil {
@@ -326,17 +397,23 @@ func (s *NodeServerRequest) handleAddr() error {
 
 	for _, node := range payload {
 		s.Logger.Trace.Printf("SessID: %s . node %s", s.SessID, node.NodeAddrToString())
-		if s.S.Node.NodeNet.AddNodeToKnown(node) {
+		// the node that sent addresses is the source of them
+		if s.S.Node.NodeNet.AddNodeToKnownFrom(node, s.RequestIP) {
 			addednodes = append(addednodes, node)
 			s.Logger.Trace.Printf("SessID: %s . node appended %s", s.SessID, node.NodeAddrToString())
 		}
 	}
 
-	s.Logger.Trace.Printf("SessID: %s . There are %d known nodes now!", s.SessID, len(s.Node.NodeNet.Nodes))
+	s.Logger.Trace.Printf("SessID: %s . There are %d known nodes now!", s.SessID, s.Node.NodeNet.GetCountOfKnownNodes())
 	s.Logger.Trace.Printf("SessID: %s . Send version to %d new nodes", s.SessID, len(addednodes))
 
+	if len(addednodes) > net.MaxOutboundNodes {
+		// other node can send many addresses. we don't connect to all of them at once
+		addednodes = addednodes[:net.MaxOutboundNodes]
+	}
+
 	if len(addednodes) > 0 {
-		// send own version to all new found nodes. maybe they have some more blocks
+		// send own version to new found nodes. maybe they have some more blocks
 		// and they will add me to known nodes after this
 		s.Node.SendVersionToNodes(addednodes)
 	}
@@ -350,34 +427,53 @@ func (s *NodeServerRequest) handleBlock() error {
 	err := s.parseRequestData(&payload)
 
 	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
 		return err
 	}
 
-	blockstate, addstate, block, err := s.Node.ReceivedFullBlockFromOtherNode(payload.Block)
-	s.Logger.Trace.Printf("adding new block %d, %d", blockstate, addstate)
-	// state of this adding we don't check. not interesting in this place
+	block := &structures.Block{}
+
+	err = block.DeserializeBlock(payload.Block)
+
 	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
 		return err
 	}
 
-	if blockstate == 0 {
-		s.Logger.Trace.Printf("send block to all ")
-		// block was added, now we can send it to all other nodes.
-		s.Node.SendBlockToAll(block, payload.AddrFrom)
+	// check of the block state and adding are done in one write transaction
+	err = s.Node.DBConn.Update(func() error {
+		return s.addBlockFromPeer(payload.AddrFrom, block)
+	})
+
+	if err != nil {
+		return err
 	}
+
+	err = s.requestTransitBlocks(payload.AddrFrom)
+
+	if err != nil {
+		// the block is added already. don't return error, it must be sent to other nodes
+		s.Logger.Trace.Printf("Request new block failed %s ", err.Error())
+	}
+	s.checkAddressKnown(payload.AddrFrom)
+
+	return nil
+}
+
+// Requests next blocks from the list of hashes the node posted before
+func (s *NodeServerRequest) requestTransitBlocks(addrfrom net.NodeAddr) error {
 	// this is the list of hashes some node posted before. If there are yes some data then try to get that blocks.
-	s.Logger.Trace.Printf("check count blocks left %d ", s.S.Transit.GetBlocksCount(payload.AddrFrom))
-	if s.S.Transit.GetBlocksCount(payload.AddrFrom) > 0 {
+	s.Logger.Trace.Printf("check count blocks left %d ", s.S.Transit.GetBlocksCount(addrfrom))
+	if s.S.Transit.GetBlocksCount(addrfrom) > 0 {
 		// get next block. continue to get next block if nothing is sent
 		for {
-			blockdata, err := s.S.Transit.ShiftNextBlock(payload.AddrFrom)
+			blockdata, err := s.S.Transit.ShiftNextBlock(addrfrom)
 
 			if err != nil {
-				s.Logger.Trace.Printf("Request new block failed %s ", err.Error())
 				return err
 			}
 
-			blockstate, err := s.Node.ReceivedBlockFromOtherNode(payload.AddrFrom, blockdata)
+			blockstate, err := s.Node.ReceivedBlockFromOtherNode(addrfrom, blockdata, false)
 
 			if err != nil {
 				return err
@@ -390,7 +486,12 @@ func (s *NodeServerRequest) handleBlock() error {
 
 			if blockstate == 2 {
 				// previous block is not in the blockchain. no sense to check next blocks in this list
-				s.S.Transit.CleanBlocks(payload.AddrFrom)
+				s.S.Transit.CleanBlocks(addrfrom)
+
+				if s.S.Sync != nil && s.S.Sync.Start(addrfrom) {
+					// headers after our chain will show where the branch of that node starts
+					break
+				}
 
 				// request from a node blocks down to this first block
 				bs := &structures.BlockShort{}
@@ -400,21 +501,42 @@ func (s *NodeServerRequest) handleBlock() error {
 					return err
 				}
 				// get blocks down stargin from previous for the first in given list
-				s.Node.NodeClient.SendGetBlocks(payload.AddrFrom, bs.PrevBlockHash)
+				s.Node.NodeClient.SendGetBlocks(addrfrom, bs.PrevBlockHash)
 			}
 
-			if s.S.Transit.GetBlocksCount(payload.AddrFrom) == 0 {
+			if s.S.Transit.GetBlocksCount(addrfrom) == 0 {
 				break
 			}
 		}
 	}
+	return nil
+}
+
+// Adds full block received from other node. New block is sent to all other nodes
+func (s *NodeServerRequest) addBlockFromPeer(addrfrom net.NodeAddr, block *structures.Block) error {
+	blockstate, addstate, block, err := s.Node.ReceivedFullBlock(block)
+	s.Logger.Trace.Printf("adding new block %d, %d", blockstate, addstate)
+	// state of this adding we don't check. not interesting in this place
+	if err != nil {
+		if score := blockErrorBanScore(err); score > 0 {
+			s.misbehaving(score, "Invalid block: "+err.Error())
+		}
+		return err
+	}
+
+	if blockstate == 0 {
+		// block was added, now we can send it to all other nodes.
+		s.onCommit(func() {
+			s.Logger.Trace.Printf("send block to all ")
+			s.Node.SendBlockToAll(block, addrfrom)
+		})
+	}
+
 	s.Logger.Trace.Printf("check if try to make new %d , %d ", addstate, blockchain.BCBAddState_addedToParallelTop)
 	if addstate == blockchain.BCBAddState_addedToParallelTop {
 		// maybe some transactiosn become unapproved now. try to make new block from them on top of new chain
 		s.S.TryToMakeNewBlock([]byte{1})
 	}
-	s.Node.CheckAddressKnown(payload.AddrFrom)
-
 	return nil
 }
 
@@ -438,6 +560,10 @@ func (s *NodeServerRequest) handleInv() error {
 
 		s.S.Transit.AddBlocks(payload.AddrFrom, payload.Items)
 
+		// new block is announced alone. Its transactions are usually in our pool, compact block is enough
+		compact := len(payload.Items) == 1 && s.S.Peers != nil &&
+			s.S.Peers.GetPeerVersion(payload.AddrFrom) >= net.CompactBlocksVersion
+
 		for {
 
 			blockdata, err := s.S.Transit.ShiftNextBlock(payload.AddrFrom)
@@ -446,7 +572,7 @@ func (s *NodeServerRequest) handleInv() error {
 				return err
 			}
 
-			blockstate, err := s.Node.ReceivedBlockFromOtherNode(payload.AddrFrom, blockdata)
+			blockstate, err := s.Node.ReceivedBlockFromOtherNode(payload.AddrFrom, blockdata, compact)
 
 			if err != nil {
 				return err
@@ -461,6 +587,11 @@ func (s *NodeServerRequest) handleInv() error {
 				// previous block is not in the blockchain. no sense to check next blocks in this list
 				s.S.Transit.CleanBlocks(payload.AddrFrom)
 
+				if s.S.Sync != nil && s.S.Sync.Start(payload.AddrFrom) {
+					// headers after our chain will show where the branch of that node starts
+					break
+				}
+
 				// request from a node blocks down to this first block
 				bs := &structures.BlockShort{}
 				err := bs.DeserializeBlock(blockdata)
@@ -480,19 +611,27 @@ func (s *NodeServerRequest) handleInv() error {
 	}
 
 	if payload.Type == "tx" {
-		txID := payload.Items[0]
+		if len(payload.Items) > txRelayMaxItems {
+			s.misbehaving(banScoreMalformedData, "Too many transactions in inv")
+			return errors.New(fmt.Sprintf("Too many transactions in inv: %d", len(payload.Items)))
+		}
 
-		s.Logger.Trace.Printf("Check if TX exists %x\n", txID)
+		for _, txID := range payload.Items {
+			// that node knows it. we will not announce it back
+			s.setTransactionKnown(txID)
 
-		tx, err := s.Node.GetTransactionsManager().GetIfExists(txID)
+			s.Logger.Trace.Printf("Check if TX exists %x\n", txID)
 
-		if tx == nil && err == nil {
-			// not exists
-			s.Logger.Trace.Printf("Not exist. Request it\n")
-			s.Node.NodeClient.SendGetData(payload.AddrFrom, "tx", txID)
+			tx, err := s.Node.GetTransactionsManager().GetIfExists(txID)
+
+			if tx == nil && err == nil {
+				// not exists
+				s.Logger.Trace.Printf("Not exist. Request it\n")
+				s.Node.NodeClient.SendGetData(payload.AddrFrom, "tx", txID)
+			}
 		}
 	}
-	s.Node.CheckAddressKnown(payload.AddrFrom)
+	s.checkAddressKnown(payload.AddrFrom)
 
 	return nil
 }
@@ -522,8 +661,12 @@ func (s *NodeServerRequest) handleGetBlocks() error {
 		data = append(data, bdata)
 		s.Logger.Trace.Printf("Block: %x", blocks[i].Hash)
 	}
-	s.Node.CheckAddressKnown(payload.AddrFrom)
-	return s.Node.NodeClient.SendInv(payload.AddrFrom, "block", data)
+
+	s.onCommit(func() {
+		s.checkAddressKnown(payload.AddrFrom)
+		s.Node.NodeClient.SendInv(payload.AddrFrom, "block", data)
+	})
+	return nil
 }
 
 /*
@@ -564,9 +707,106 @@ func (s *NodeServerRequest) handleGetBlocksUpper() error {
 		s.Logger.Trace.Printf("Block: %x", blocks[i].Hash)
 	}
 
-	s.Node.CheckAddressKnown(payload.AddrFrom)
+	s.onCommit(func() {
+		s.checkAddressKnown(payload.AddrFrom)
+		s.Node.NodeClient.SendInv(payload.AddrFrom, "block", data)
+	})
+	return nil
+}
+
+// Returns headers of blocks after a common block. Other node validates them before it requests full blocks
+func (s *NodeServerRequest) handleGetHeaders() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetHeaders
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	if len(payload.Locator) > syncMaxLocatorSize {
+		s.misbehaving(banScoreMalformedData, "Too long locator")
+		return errors.New("Too long locator")
+	}
+
+	headers, err := s.Node.NodeBC.GetBCManager().GetHeadersAfter(payload.Locator, syncMaxHeaders)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComHeaders{}
+	result.Headers = [][]byte{}
+
+	for _, header := range headers {
+		hdata, err := header.Serialize()
 
-	return s.Node.NodeClient.SendInv(payload.AddrFrom, "block", data)
+		if err != nil {
+			return err
+		}
+		result.Headers = append(result.Headers, hdata)
+	}
+
+	s.Logger.Trace.Printf("Return %d headers", len(headers))
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
+}
+
+// Returns full blocks by hashes. Blocks that are not found or pruned are skipped,
+// other node requests them from somewhere else
+func (s *NodeServerRequest) handleGetBodies() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetBodies
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	if len(payload.Hashes) > syncMaxBodiesRequest {
+		payload.Hashes = payload.Hashes[:syncMaxBodiesRequest]
+	}
+
+	result := nodeclient.ComBodies{}
+	result.Blocks = [][]byte{}
+
+	size := 0
+
+	for _, hash := range payload.Hashes {
+		block, err := s.Node.NodeBC.GetBlock(hash)
+
+		if err != nil || block.IsPruned() {
+			continue
+		}
+
+		bs, err := block.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+		if size+len(bs) > net.MaxPayloadSize/2 && len(result.Blocks) > 0 {
+			// response must not be over the limit. other blocks will be requested again
+			break
+		}
+
+		size += len(bs)
+		result.Blocks = append(result.Blocks, bs)
+	}
+
+	s.Logger.Trace.Printf("Return %d blocks of %d requested", len(result.Blocks), len(payload.Hashes))
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
 }
 
 /*
@@ -590,19 +830,47 @@ func (s *NodeServerRequest) handleGetData() error {
 			return err
 		}
 
+		if block.IsPruned() {
+			return errors.New(fmt.Sprintf("Block %x is pruned. Can not return it", payload.ID))
+		}
+
 		bs, err := block.Serialize()
 
 		if err == nil {
-			s.Node.NodeClient.SendBlock(payload.AddrFrom, bs)
+			s.onCommit(func() {
+				s.Node.NodeClient.SendBlock(payload.AddrFrom, bs)
+			})
 		}
 
 	}
 
+	if payload.Type == "cmpctblock" {
+		block, err := s.Node.NodeBC.GetBlock([]byte(payload.ID))
+
+		if err != nil {
+			return err
+		}
+
+		if block.IsPruned() {
+			return errors.New(fmt.Sprintf("Block %x is pruned. Can not return it", payload.ID))
+		}
+
+		cblock, err := makeCompactBlock(block)
+
+		if err == nil {
+			s.onCommit(func() {
+				s.Node.NodeClient.SendCompactBlock(payload.AddrFrom, cblock)
+			})
+		}
+	}
+
 	if payload.Type == "tx" {
 
 		if txe, err := s.Node.GetTransactionsManager().GetIfUnapprovedExists(payload.ID); err == nil && txe != nil {
 
 			s.Logger.Trace.Printf("Return transaction with ID %x to %s\n", payload.ID, payload.AddrFrom.NodeAddrToString())
+
+			s.setTransactionKnown(payload.ID)
 			// exists
 			txser, err := txe.Serialize()
 
@@ -610,12 +878,15 @@ func (s *NodeServerRequest) handleGetData() error {
 				return err
 			}
 
-			s.Node.NodeClient.SendTx(payload.AddrFrom, txser)
-
+			s.onCommit(func() {
+				s.Node.NodeClient.SendTx(payload.AddrFrom, txser)
+			})
 		}
 	}
 
-	s.Node.CheckAddressKnown(payload.AddrFrom)
+	s.onCommit(func() {
+		s.checkAddressKnown(payload.AddrFrom)
+	})
 
 	return nil
 }
@@ -632,6 +903,7 @@ func (s *NodeServerRequest) handleTx() error {
 	err := s.parseRequestData(&payload)
 
 	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
 		return err
 	}
 
@@ -640,17 +912,30 @@ func (s *NodeServerRequest) handleTx() error {
 	err = tx.DeserializeTransaction(txData)
 
 	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
 		return err
 	}
 
-	if txe, err := s.Node.GetTransactionsManager().GetIfExists(tx.ID); err == nil && txe != nil {
+	s.setTransactionKnown(tx.ID)
+
+	exists := false
+
+	// check and adding are done in one write transaction
+	err = s.Node.DBConn.Update(func() error {
+		if txe, err := s.Node.GetTransactionsManager().GetIfExists(tx.ID); err == nil && txe != nil {
+			exists = true
+			return nil
+		}
+		s.Logger.Trace.Printf("Received transaction. It does not exists: %x ", tx.ID)
+		// this will also verify a transaction
+		return s.Node.GetTransactionsManager().ReceivedNewTransaction(&tx)
+	})
+
+	if exists {
 		s.Logger.Trace.Printf("Received transaction. It already exists: %x ", tx.ID)
 		// exists , nothing to do, it was already processed before
 		return nil
 	}
-	s.Logger.Trace.Printf("Received transaction. It does not exists: %x ", tx.ID)
-	// this will also verify a transaction
-	err = s.Node.GetTransactionsManager().ReceivedNewTransaction(&tx)
 
 	if err != nil {
 		// if error is because some input transaction is not found, then request it and after it this TX again
@@ -672,9 +957,19 @@ func (s *NodeServerRequest) handleTx() error {
 					return nil*/
 
 				// TODO in future we can createsomethign more start here. Like, get TX with all previous TXs that are not approved yet
+				return err
 			}
 
 		}
+
+		if _, ok := err.(*transactions.TXNotFoundError); ok {
+			// inputs can be in blocks we don't have yet
+			return err
+		}
+
+		if isInvalidTransactionError(err) {
+			s.misbehaving(banScoreInvalidTransaction, "Invalid transaction: "+err.Error())
+		}
 		return err
 	}
 
@@ -692,7 +987,8 @@ func (s *NodeServerRequest) handleTx() error {
 /*
 * Process version command. Other node sends own address and index of top block.
 * This node checks if index is bogger then request for a rest of blocks. If index is less
-* then sends own version command and that node will request for blocks
+* then sends own version command and that node will request for blocks.
+* Nodes with other genesis block or old protocol version are not accepted
  */
 func (s *NodeServerRequest) handleVersion() error {
 	var payload nodeclient.ComVersion
@@ -703,39 +999,68 @@ func (s *NodeServerRequest) handleVersion() error {
 		return err
 	}
 
-	topHash, myBestHeight, err := s.Node.NodeBC.GetBCManager().GetState()
+	topHash, _, err := s.Node.NodeBC.GetBCManager().GetState()
+
+	if err != nil {
+		return err
+	}
+
+	myState, err := s.Node.GetVersionState()
 
 	if err != nil {
 		return err
 	}
 
-	if payload.AddrFrom.Host == "localhost" {
-		payload.AddrFrom.Host = s.RequestIP
+	payload.AddrFrom = payload.AddrFrom.ResolveWithIP(s.RequestIP)
+
+	claimed := payload.AddrFrom
+
+	if s.Session != nil {
+		// answers go to the session. Address sent by the node is checked before it is used
+		payload.AddrFrom = s.Session.Addr
+	}
+
+	err = checkPeerVersion(payload, myState)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Version of %s is not accepted: %s", payload.AddrFrom.NodeAddrToString(), err.Error()))
 	}
 
-	s.Logger.Trace.Printf("Received version from %s. Their heigh %d, our heigh %d\n",
-		payload.AddrFrom.NodeAddrToString(), payload.BestHeight, myBestHeight)
+	myBestHeight := myState.BestHeight
+
+	s.Logger.Trace.Printf("Received version from %s (%s). Their heigh %d, our heigh %d\n",
+		payload.AddrFrom.NodeAddrToString(), payload.UserAgent, payload.BestHeight, myBestHeight)
 
 	foreignerBestHeight := payload.BestHeight
 
-	if myBestHeight < foreignerBestHeight {
-		s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
+	if myBestHeight < foreignerBestHeight && !canServeBlocks(payload, myBestHeight) {
+		// that node doesn't have full blocks we need
+		s.Logger.Trace.Printf("Node %s doesn't serve blocks after height %d. Services %d, pruned height %d\n",
+			payload.AddrFrom.NodeAddrToString(), myBestHeight, payload.Services, payload.PrunedHeight)
 
+	} else if myBestHeight < foreignerBestHeight {
 		if foreignerBestHeight > s.S.Transit.MaxKnownHeigh {
 			s.S.Transit.MaxKnownHeigh = foreignerBestHeight
 		}
 
-		s.Node.NodeClient.SendGetBlocksUpper(payload.AddrFrom, topHash)
+		if s.S.Sync != nil && s.S.Sync.Start(payload.AddrFrom) {
+			// headers are loaded first and then blocks from all nodes that have them
+			s.Logger.Trace.Printf("Sync blocks with %s\n", payload.AddrFrom.NodeAddrToString())
+		} else {
+			s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
+
+			s.Node.NodeClient.SendGetBlocksUpper(payload.AddrFrom, topHash)
+		}
 
 	} else if myBestHeight > foreignerBestHeight {
 		s.Logger.Trace.Printf("Send my version back to %s\n", payload.AddrFrom.NodeAddrToString())
 
-		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight)
+		s.Node.NodeClient.SendVersion(payload.AddrFrom, myState)
 	} else {
 		s.Logger.Trace.Printf("Teir blockchain is same as my for %s\n", payload.AddrFrom.NodeAddrToString())
 	}
 
-	s.S.Node.CheckAddressKnown(payload.AddrFrom)
+	s.checkAddressKnown(claimed)
 
 	return nil
 }
@@ -759,6 +1084,26 @@ func (s *NodeServerRequest) handleGetNodes() error {
 	return nil
 }
 
+// Returns stats of known nodes. It is used by shownodes
+func (s *NodeServerRequest) handleGetNodesStats() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	stats := s.S.Node.NodeNet.GetNodesStats()
+
+	var err error
+
+	s.Response, err = net.GobEncode(&stats)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Add new node to list of nodes
 func (s *NodeServerRequest) handleAddNode() error {
 	if !s.NodeAuthStrIsGood {
@@ -775,7 +1120,7 @@ func (s *NodeServerRequest) handleAddNode() error {
 		return err
 	}
 
-	s.S.Node.AddNodeToKnown(payload.Node, true)
+	s.S.Node.AddNodeToKnown(payload.Node, net.NodeSourceManual, true)
 
 	s.Response = []byte{}
 
@@ -801,13 +1146,171 @@ func (s *NodeServerRequest) handleRemoveNode() error {
 	s.S.Node.NodeNet.RemoveNodeFromKnown(payload.Node)
 
 	s.Logger.Trace.Printf("Removed node %s\n", payload.Node.NodeAddrToString())
-	s.Logger.Trace.Println(s.S.Node.NodeNet.Nodes)
+	s.Logger.Trace.Println(s.S.Node.NodeNet.GetNodes())
 
 	s.Response = []byte{}
 
 	return nil
 }
 
+// Ban a host. Connections from it are refused
+func (s *NodeServerRequest) handleBanNode() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComBanNode
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.S.Bans.Ban(payload.Host, payload.Duration, "Banned manually")
+
+	if err != nil {
+		return err
+	}
+
+	s.Response = []byte{}
+
+	return nil
+}
+
+// Remove a ban of a host
+func (s *NodeServerRequest) handleUnbanNode() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComBanNode
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.S.Bans.Unban(payload.Host)
+
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Trace.Printf("Unbanned %s\n", payload.Host)
+
+	s.Response = []byte{}
+
+	return nil
+}
+
+// Returns list of banned hosts
+func (s *NodeServerRequest) handleGetBanned() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	bans, err := s.Node.GetBannedNodes()
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(&bans)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Pin identity key of a node. Session with the node is reopened to check the key
+func (s *NodeServerRequest) handlePinNode() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComPinnedNode
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.Node.PinNodeKey(payload.Node, payload.Key)
+
+	if err != nil {
+		return err
+	}
+
+	s.S.Peers.CloseNode(payload.Node)
+
+	s.Response = []byte{}
+
+	return nil
+}
+
+// Remove pinned key of a node
+func (s *NodeServerRequest) handleUnpinNode() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComPinnedNode
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.Node.UnpinNodeKey(payload.Node)
+
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Trace.Printf("Unpinned key of %s\n", payload.Node.NodeAddrToString())
+
+	s.Response = []byte{}
+
+	return nil
+}
+
+// Returns list of pinned keys of nodes
+func (s *NodeServerRequest) handleGetPinned() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	pins, err := s.Node.GetPinnedNodes()
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(&pins)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Return node state, including pending blocks to load
 func (s *NodeServerRequest) handleGetState() error {
 	if !s.NodeAuthStrIsGood {
@@ -816,14 +1319,12 @@ func (s *NodeServerRequest) handleGetState() error {
 
 	s.HasResponse = true
 
-	info, err := s.Node.GetNodeState()
+	info, err := s.S.getNodeState(s.Node)
 
 	if err != nil {
 		return err
 	}
 
-	info.ExpectingBlocksHeight = s.S.Transit.MaxKnownHeigh
-
 	s.Response, err = net.GobEncode(&info)
 
 	if err != nil {
@@ -831,3 +1332,204 @@ func (s *NodeServerRequest) handleGetState() error {
 	}
 	return nil
 }
+
+// Returns blocks of the chain from the top to genesis by pages. It is used by printchain
+func (s *NodeServerRequest) handleGetChainBlocks() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetChainBlocks
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	var bci *blockchain.BlockchainIterator
+
+	if len(payload.StartFrom) > 0 {
+		bci, err = blockchain.NewBlockchainIteratorFrom(s.Node.DBConn.DB(), payload.StartFrom)
+	} else {
+		bci, err = s.Node.GetBlockChainIterator()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComChainBlocks{}
+	result.Blocks = [][]byte{}
+
+	size := 0
+
+	for len(result.Blocks) < payload.Count {
+		block, err := bci.Next()
+
+		if err != nil {
+			return err
+		}
+
+		bs, err := block.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+		if size+len(bs) > net.MaxPayloadSize/2 && len(result.Blocks) > 0 {
+			// response must not be over the limit. other blocks will be requested again
+			break
+		}
+
+		size += len(bs)
+		result.Blocks = append(result.Blocks, bs)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
+}
+
+// Writes blocks of the chain to a file on this node
+func (s *NodeServerRequest) handleExportChain() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComExportChain
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	count, err := s.Node.ExportChain(payload.File, payload.From, payload.To)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(count)
+
+	return err
+}
+
+// Writes the UTXO set to a file on this node
+func (s *NodeServerRequest) handleDumpUTXOSet() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComDumpUTXOSet
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	info := nodeclient.ComUTXOSetInfo{}
+
+	info.TipHash, info.Count, info.Digest, err = s.Node.DumpUnspentOutputs(payload.File)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(info)
+
+	return err
+}
+
+// Copies DB files and wallets to a directory. DB files are copied in read transactions
+func (s *NodeServerRequest) handleBackup() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComBackup
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.Node.Backup(payload.Dest)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response = []byte{}
+
+	return nil
+}
+
+// Checks the DB consistency. Problems are not repaired while the server works
+func (s *NodeServerRequest) handleVerifyChain() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	var payload nodeclient.ComVerifyChain
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComVerifyChainResult{}
+
+	result.Count, result.Problems, err = s.Node.VerifyChain(payload.Depth, false)
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
+}
+
+// Returns transactions from the pool as they are printed. It is used by unapprovedtransactions
+func (s *NodeServerRequest) handleGetUnapproved() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	result := []nodeclient.ComUnapprovedTransaction{}
+
+	_, err := s.Node.GetTransactionsManager().ForEachUnapprovedTransaction(
+		func(txhash, txstr string) error {
+			result = append(result, nodeclient.ComUnapprovedTransaction{Hash: txhash, Info: txstr})
+			return nil
+		})
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(result)
+
+	return err
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Blocks and transactions in JSON API responses. Hashes and keys are hex strings, addresses are in wallet format
+type jsonBlock struct {
+	Hash          string
+	PrevBlockHash string
+	Height        int
+	Timestamp     int64
+	Nonce         int
+	Pruned        bool // full data of the block is removed. It has no transactions
+	Transactions  []jsonTransaction
+}
+
+type jsonTransaction struct {
+	ID       string
+	Time     int64
+	Coinbase bool
+	Pending  bool // transaction is in the pool, not in a block
+	Inputs   []jsonTXInput
+	Outputs  []jsonTXOutput
+}
+
+type jsonTXInput struct {
+	TXID      string
+	Vout      int
+	Address   string
+	PubKey    string
+	Signature string
+}
+
+type jsonTXOutput struct {
+	Value   float64
+	Address string
+}
+
+// Session with other node
+type jsonPeerInfo struct {
+	Addr         string
+	IP           string
+	Outbound     bool
+	Version      int
+	Services     uint64
+	UserAgent    string
+	BestHeight   int
+	PrunedHeight int
+	LastReceived int64 // unix time
+	LastSent     int64
+	PingTime     int64 // milliseconds
+}
+
+func newJSONBlock(block *structures.Block) jsonBlock {
+	b := jsonBlock{}
+	b.Hash = fmt.Sprintf("%x", block.Hash)
+	b.PrevBlockHash = fmt.Sprintf("%x", block.PrevBlockHash)
+	b.Height = block.Height
+	b.Timestamp = block.Timestamp
+	b.Nonce = block.Nonce
+	b.Pruned = block.IsPruned()
+	b.Transactions = []jsonTransaction{}
+
+	for _, tx := range block.Transactions {
+		b.Transactions = append(b.Transactions, newJSONTransaction(tx, false))
+	}
+	return b
+}
+
+func newJSONTransaction(tx *structures.Transaction, pending bool) jsonTransaction {
+	t := jsonTransaction{}
+	t.ID = fmt.Sprintf("%x", tx.ID)
+	t.Time = tx.Time
+	t.Coinbase = tx.IsCoinbase()
+	t.Pending = pending
+	t.Inputs = []jsonTXInput{}
+	t.Outputs = []jsonTXOutput{}
+
+	for _, vin := range tx.Vin {
+		in := jsonTXInput{}
+		in.TXID = fmt.Sprintf("%x", vin.Txid)
+		in.Vout = vin.Vout
+		in.PubKey = fmt.Sprintf("%x", vin.PubKey)
+		in.Signature = fmt.Sprintf("%x", vin.Signature)
+
+		if !t.Coinbase {
+			// coinbase input has any data instead of a key
+			in.Address, _ = utils.PubKeyToAddres(vin.PubKey)
+		}
+		t.Inputs = append(t.Inputs, in)
+	}
+
+	for _, vout := range tx.Vout {
+		out := jsonTXOutput{}
+		out.Value = vout.Value
+		out.Address, _ = utils.PubKeyHashToAddres(vout.PubKeyHash)
+
+		t.Outputs = append(t.Outputs, out)
+	}
+	return t
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Max number of inbound connections if it is not set in config
+const defaultMaxConnections = 125
+
+// Max number of connections opened at same time from one IP
+const maxConnectionsPerIP = 8
+
+// Requests from one IP are limited with a token bucket. Tokens are added with this rate up to the burst size
+const requestsPerSecond = 100
+const requestsBurst = 500
+
+// Buckets that are full again are removed this often
+const bucketsExpireInterval = 1 * time.Minute
+
+// Deadlines of network operations. Body of a request can be big, it has more time to be read
+const requestHeaderTimeout = 10 * time.Second
+const requestReadTimeout = 60 * time.Second
+const responseWriteTimeout = 60 * time.Second
+
+// Counts inbound connections and requests. Connections from loopback addresses are not limited per IP,
+// local clients and other local nodes can do many requests
+type connectionLimits struct {
+	MaxConnections int
+	lock           *sync.Mutex
+	total          int
+	perIP          map[string]int
+	buckets        map[string]*requestsBucket
+	lastExpire     time.Time
+}
+
+type requestsBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Connection that releases its slot in the limits when it is closed
+type limitedConn struct {
+	net.Conn
+	limits    *connectionLimits
+	ip        string
+	closeOnce *sync.Once
+}
+
+func newConnectionLimits(maxConnections int) *connectionLimits {
+	l := &connectionLimits{}
+
+	l.MaxConnections = maxConnections
+
+	if l.MaxConnections < 1 {
+		l.MaxConnections = defaultMaxConnections
+	}
+
+	l.lock = &sync.Mutex{}
+	l.perIP = map[string]int{}
+	l.buckets = map[string]*requestsBucket{}
+	l.lastExpire = time.Now()
+
+	return l
+}
+
+// Registers new inbound connection. Returns nil if the connection must be refused.
+// Returned connection must be closed to release the slot
+func (l *connectionLimits) Accept(conn net.Conn) net.Conn {
+	ip := getConnectionIP(conn)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.total >= l.MaxConnections {
+		return nil
+	}
+
+	if !isLoopbackIP(ip) && l.perIP[ip] >= maxConnectionsPerIP {
+		return nil
+	}
+
+	l.total++
+	l.perIP[ip]++
+
+	return &limitedConn{conn, l, ip, &sync.Once{}}
+}
+
+// Checks if one more request from the IP can be processed now
+func (l *connectionLimits) AllowRequest(ip string) bool {
+	if isLoopbackIP(ip) {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(l.lastExpire) > bucketsExpireInterval {
+		l.expireBuckets(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+
+	if !ok {
+		bucket = &requestsBucket{requestsBurst, now}
+		l.buckets[ip] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * requestsPerSecond
+	bucket.last = now
+
+	if bucket.tokens > requestsBurst {
+		bucket.tokens = requestsBurst
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// Returns number of inbound connections now
+func (l *connectionLimits) GetCount() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.total
+}
+
+// Removes buckets of IPs that did no requests for some time. Full bucket is same as no bucket.
+// Outbound sessions don't hold slots, so their buckets are removed only here
+func (l *connectionLimits) expireBuckets(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*requestsPerSecond >= requestsBurst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastExpire = now
+}
+
+func (l *connectionLimits) release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.total--
+	l.perIP[ip]--
+
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+		// full bucket is same as no bucket
+		if bucket, ok := l.buckets[ip]; ok && bucket.tokens+time.Since(bucket.last).Seconds()*requestsPerSecond >= requestsBurst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+
+	c.closeOnce.Do(func() {
+		c.limits.release(c.ip)
+	})
+
+	return err
+}
+
+func isLoopbackIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	return parsed != nil && parsed.IsLoopback()
+}
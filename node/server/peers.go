@@ -0,0 +1,903 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Ping is sent if nothing was sent to a peer during this time.
+// Session is closed if nothing is received from a peer during 3 intervals
+const peerPingInterval = 30 * time.Second
+const peerResponseTimeout = 30 * time.Second
+const peerHandshakeTimeout = 10 * time.Second
+const peerWriteTimeout = 10 * time.Second
+const peerSendQueueSize = 100
+
+// Requests of a peer processed at same time. Reading of next requests waits for a free slot
+const peerMaxInflightRequests = 4
+
+// Opened connection with other node. Both nodes send requests and responses in it.
+// Requests that wait a response have ID. Response is sent with same ID
+type peerSession struct {
+	Addr     netlib.NodeAddr // address where the peer listens. Host of inbound session is the IP that we see
+	IP       string
+	Outbound bool // we opened this connection
+
+	// received in handshake. Version command can be sent again in the session to update them
+	Version      int
+	Services     uint64
+	UserAgent    string
+	BestHeight   int
+	PrunedHeight int
+
+	LastReceived time.Time
+	LastSent     time.Time
+	PingTime     time.Duration
+
+	conn      net.Conn
+	sendQueue chan []byte
+	inflight  chan struct{} // semaphore of requests processed now
+	pending   map[uint32]chan []byte
+	lock      *sync.Mutex
+	nextID    uint32
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// Keeps sessions with other nodes. It is a transport for the node client
+type peerSessions struct {
+	S        *NodeServer
+	Logger   *utils.LoggerMan
+	lock     *sync.Mutex
+	sessions map[string]*peerSession
+	opening  map[string]chan struct{} // sessions that are opened now. closed channel means opening is done
+	checking map[string]bool          // addresses sent by peers that are checked now
+	stopped  bool
+}
+
+func newPeerSessions(s *NodeServer) *peerSessions {
+	p := &peerSessions{}
+	p.S = s
+	p.Logger = s.Logger
+	p.lock = &sync.Mutex{}
+	p.sessions = map[string]*peerSession{}
+	p.opening = map[string]chan struct{}{}
+	p.checking = map[string]bool{}
+
+	return p
+}
+
+// Sends data to a peer. Doesn't wait for a response. Session is opened if it doesn't exist yet
+func (p *peerSessions) Send(addr netlib.NodeAddr, data []byte) error {
+	sess, helloSent, err := p.getSession(addr, data)
+
+	if err != nil {
+		return err
+	}
+
+	if helloSent {
+		// this data was sent as the first message of new session
+		return nil
+	}
+
+	return sess.send(p.prepareFrame(data, netlib.FrameFlagSession, 0))
+}
+
+// Sends data to a peer and waits for response
+func (p *peerSessions) SendWaitResponse(addr netlib.NodeAddr, data []byte) ([]byte, error) {
+	sess, _, err := p.getSession(addr, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := sess.newRequestID()
+
+	return sess.request(p.prepareFrame(data, netlib.FrameFlagSession, id), id, peerResponseTimeout)
+}
+
+// Closes all sessions. New sessions are not opened after this
+func (p *peerSessions) CloseAll() {
+	p.lock.Lock()
+
+	p.stopped = true
+
+	list := []*peerSession{}
+
+	for _, sess := range p.sessions {
+		list = append(list, sess)
+	}
+
+	p.lock.Unlock()
+
+	for _, sess := range list {
+		sess.close()
+	}
+}
+
+// Closes all sessions with a host. It is used when the host is banned
+func (p *peerSessions) CloseHost(host string) {
+	for _, sess := range p.GetSessions() {
+		if sess.IP == host || netlib.NormalizeHost(sess.Addr.Host) == host {
+			sess.close()
+		}
+	}
+}
+
+// Closes session with a node. It is used when the key of the node is pinned
+func (p *peerSessions) CloseNode(addr netlib.NodeAddr) {
+	p.lock.Lock()
+	sess, ok := p.sessions[getSessionKey(addr)]
+	p.lock.Unlock()
+
+	if ok {
+		sess.close()
+	}
+}
+
+// Returns opened session with a node or nil if there is no session
+func (p *peerSessions) GetSession(addr netlib.NodeAddr) *peerSession {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.sessions[getSessionKey(addr)]
+}
+
+// Returns protocol version of a node. It is 0 if there is no session with the node
+func (p *peerSessions) GetPeerVersion(addr netlib.NodeAddr) int {
+	sess := p.GetSession(addr)
+
+	if sess == nil {
+		return 0
+	}
+
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	return sess.Version
+}
+
+// Returns list of opened sessions
+func (p *peerSessions) GetSessions() []*peerSession {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	list := []*peerSession{}
+
+	for _, sess := range p.sessions {
+		list = append(list, sess)
+	}
+	return list
+}
+
+// Returns existent session or opens new one. If the data is version command then it is used
+// as the first message of new session. Second value is true in this case
+func (p *peerSessions) getSession(addr netlib.NodeAddr, data []byte) (*peerSession, bool, error) {
+	key := getSessionKey(addr)
+
+	if p.S.Bans != nil && p.S.Bans.IsBanned(netlib.NormalizeHost(addr.Host)) {
+		return nil, false, errors.New(fmt.Sprintf("%s is banned", addr.NodeAddrToString()))
+	}
+
+	var opening chan struct{}
+
+	for opening == nil {
+		p.lock.Lock()
+
+		if p.stopped {
+			p.lock.Unlock()
+			return nil, false, errors.New("Node server is stopped")
+		}
+
+		sess, ok := p.sessions[key]
+
+		if ok {
+			p.lock.Unlock()
+			return sess, false, nil
+		}
+
+		wait, busy := p.opening[key]
+
+		if !busy {
+			// we open the session. others wait for us
+			opening = make(chan struct{})
+			p.opening[key] = opening
+		}
+
+		p.lock.Unlock()
+
+		if busy {
+			<-wait
+		}
+	}
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.opening, key)
+		p.lock.Unlock()
+
+		close(opening)
+	}()
+
+	hello := data
+	helloSent := true
+
+	if hello == nil || netlib.GetFrameCommand(hello) != "version" {
+		var err error
+
+		hello, err = p.buildVersionCommand(false, addr)
+
+		if err != nil {
+			return nil, false, err
+		}
+		helloSent = false
+	}
+
+	sess, err := p.openSession(addr, hello)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return sess, helloSent, nil
+}
+
+// Connects to a node and does handshake. We send version command and wait for verack
+func (p *peerSessions) openSession(addr netlib.NodeAddr, hello []byte) (*peerSession, error) {
+	p.Logger.Trace.Printf("Open session with %s", addr.NodeAddrToString())
+
+	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), 1*time.Second)
+
+	if err != nil {
+		p.S.Node.NodeNet.MarkNodeFailure(addr)
+		return nil, errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+	}
+
+	conn, err = p.secureOutbound(conn, addr)
+
+	if err != nil {
+		p.Logger.Trace.Printf("Encrypted session with %s is not opened: %s", addr.NodeAddrToString(), err.Error())
+		return nil, errors.New(fmt.Sprintf("Handshake with %s failed: %s", addr.NodeAddrToString(), err.Error()))
+	}
+
+	conn.SetDeadline(time.Now().Add(peerHandshakeTimeout))
+
+	_, err = conn.Write(p.prepareFrame(hello, netlib.FrameFlagSession, 0))
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	header, request, _, err := p.S.readRequest(conn, peerHandshakeTimeout)
+
+	if err == nil && (header.Command != "verack" || !header.IsSession()) {
+		err = errors.New(fmt.Sprintf("Received %s instead of verack", header.Command))
+	}
+
+	var peerVersion nodeclient.ComVersion
+
+	if err == nil {
+		// verack contains version data of that node
+		peerVersion, err = p.checkVersionMessage(request)
+	}
+
+	if err != nil {
+		conn.Close()
+		p.S.Node.NodeNet.MarkNodeFailure(addr)
+		return nil, errors.New(fmt.Sprintf("Handshake with %s failed: %s", addr.NodeAddrToString(), err.Error()))
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	p.S.Node.NodeNet.MarkNodeSuccess(addr, true)
+
+	sess := p.startSession(conn, addr, true, peerVersion)
+
+	return sess, nil
+}
+
+// First message of a session from other node. It must be version command. We send verack back
+// and then process the version command as usual
+func (p *peerSessions) acceptSession(conn net.Conn, header netlib.FrameHeader, request []byte) {
+	if header.Command != "version" {
+		p.S.sendErrorBack(conn, errors.New("Session must start with version command"))
+		conn.Close()
+		return
+	}
+
+	payload, err := p.checkVersionMessage(request)
+
+	if err != nil {
+		p.Logger.Trace.Printf("Session from %s is refused: %s", getConnectionIP(conn), err.Error())
+		p.S.sendErrorBack(conn, err)
+		conn.Close()
+		return
+	}
+
+	ip := getConnectionIP(conn)
+
+	// the session is known by the IP that we see. Address sent by the node is used only after we connect to it
+	addr := netlib.NodeAddr{Host: ip, Port: payload.AddrFrom.Port}
+
+	// other node can not use address of a node with pinned key
+	err = p.S.CloneNode().CheckNodeKey(addr, netlib.GetConnectionKey(conn), false)
+
+	if err == nil && p.hasOutboundSession(addr) {
+		// we connected to this node. its connection must not replace ours
+		err = errors.New("Session with the node is opened already")
+	}
+
+	if err != nil {
+		p.Logger.Trace.Printf("Session from %s is refused: %s", ip, err.Error())
+		p.S.sendErrorBack(conn, err)
+		conn.Close()
+		return
+	}
+
+	// the node learns own external address from the IP that we see
+	verack, err := p.buildVersionCommand(true, addr)
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+
+	_, err = conn.Write(p.prepareFrame(verack, netlib.FrameFlagSession, 0))
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	conn.SetWriteDeadline(time.Time{})
+
+	p.Logger.Trace.Printf("Accepted session from %s", addr.NodeAddrToString())
+
+	sess := p.startSession(conn, addr, false, payload)
+
+	if sess == nil {
+		return
+	}
+
+	p.S.Node.NodeNet.MarkNodeSuccess(addr, false)
+
+	p.S.processRequest(header.Command, request, "", ip, sess)
+}
+
+// Checks if we opened a session with a node
+func (p *peerSessions) hasOutboundSession(addr netlib.NodeAddr) bool {
+	sess := p.GetSession(addr)
+
+	return sess != nil && sess.Outbound && !sess.isClosed()
+}
+
+// Adds address sent by other node to known nodes. We connect to the address before, the node must answer there.
+// Otherwise any node could add addresses of other hosts or replace addresses of real nodes
+func (p *peerSessions) CheckAddressKnown(addr netlib.NodeAddr) {
+	if p.S.Node.NodeNet.CheckIsKnown(addr) {
+		return
+	}
+
+	key := getSessionKey(addr)
+
+	p.lock.Lock()
+
+	if p.checking[key] || p.stopped {
+		p.lock.Unlock()
+		return
+	}
+	p.checking[key] = true
+
+	p.lock.Unlock()
+
+	go func() {
+		defer func() {
+			p.lock.Lock()
+			delete(p.checking, key)
+			p.lock.Unlock()
+		}()
+
+		node := p.S.CloneNode()
+
+		err := node.NodeClient.SendCheckNode(addr)
+
+		if err != nil {
+			p.Logger.Trace.Printf("Address %s is not added: %s", addr.NodeAddrToString(), err.Error())
+			return
+		}
+
+		node.CheckAddressKnown(addr)
+
+		// the clone has own copy of the nodes list
+		p.S.Node.NodeNet.AddNodeToKnown(addr)
+	}()
+}
+
+// Encrypts connection to a peer if encryption is enabled or the peer has pinned key.
+// Key of the peer is pinned on first encrypted connection
+func (p *peerSessions) secureOutbound(conn net.Conn, addr netlib.NodeAddr) (net.Conn, error) {
+	node := p.S.CloneNode()
+
+	pinned, err := node.GetNodeKey(addr)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !p.S.Encrypt && len(pinned) == 0 {
+		return conn, nil
+	}
+
+	secure, err := netlib.SecureClient(conn, p.S.NodeKey)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	err = node.CheckNodeKey(addr, secure.RemoteKey, true)
+
+	if err != nil {
+		secure.Close()
+		return nil, err
+	}
+
+	return secure, nil
+}
+
+// Registers a session and starts routines to read and write. Inbound session is not registered
+// if there is outbound session with same node, nil is returned then
+func (p *peerSessions) startSession(conn net.Conn, addr netlib.NodeAddr, outbound bool, peerVersion nodeclient.ComVersion) *peerSession {
+	sess := &peerSession{}
+	sess.Addr = addr
+	sess.IP = getConnectionIP(conn)
+	sess.Outbound = outbound
+	sess.conn = conn
+	sess.sendQueue = make(chan []byte, peerSendQueueSize)
+	sess.inflight = make(chan struct{}, peerMaxInflightRequests)
+	sess.pending = map[uint32]chan []byte{}
+	sess.lock = &sync.Mutex{}
+	sess.setVersion(peerVersion)
+	sess.closed = make(chan struct{})
+	sess.closeOnce = &sync.Once{}
+	sess.LastReceived = time.Now()
+	sess.LastSent = time.Now()
+
+	p.lock.Lock()
+
+	key := getSessionKey(addr)
+
+	if p.stopped {
+		p.lock.Unlock()
+		sess.close()
+		return sess
+	}
+
+	if old, ok := p.sessions[key]; ok && old.Outbound && !outbound && !old.isClosed() {
+		// outbound session was opened while this one did handshake. it stays
+		p.lock.Unlock()
+		sess.close()
+		return nil
+	}
+
+	// if there was other session with same node, it stays until it is closed. new messages go to this one
+	p.sessions[key] = sess
+
+	p.lock.Unlock()
+
+	go p.readLoop(sess)
+	go p.writeLoop(sess)
+
+	p.S.learnExternalHost(peerVersion.AddrYou, addr, outbound)
+
+	if p.S.TxRelay != nil {
+		// learn transactions that were announced before the session
+		p.S.TxRelay.RequestMempool(addr)
+	}
+
+	return sess
+}
+
+// Reads messages from a peer. Responses are passed to waiting requests, requests are processed in separate routines
+func (p *peerSessions) readLoop(sess *peerSession) {
+	defer p.closeSession(sess)
+
+	for {
+		header, request, authstring, err := p.S.readRequest(sess.conn, 3*peerPingInterval)
+
+		if err != nil {
+			p.Logger.Trace.Printf("Session with %s is closed: %s", sess.Addr.NodeAddrToString(), err.Error())
+			return
+		}
+
+		sess.lock.Lock()
+		sess.LastReceived = time.Now()
+		sess.lock.Unlock()
+
+		if header.IsResponse() {
+			sess.deliverResponse(header.ID, request)
+			continue
+		}
+
+		if authstring != "" && !p.S.isManagementRequest(sess.conn, authstring) {
+			// management commands are not accepted from other nodes
+			authstring = ""
+		}
+
+		if p.S.Limits != nil && !p.S.Limits.AllowRequest(sess.IP) {
+			p.Logger.Trace.Printf("Too many requests from %s. %s is skipped", sess.Addr.NodeAddrToString(), header.Command)
+
+			if header.ID > 0 {
+				sess.send(p.buildResponseFrame(header, p.S.buildErrorResponse(errors.New("Too many requests"))))
+			}
+			continue
+		}
+
+		if header.Command == "version" {
+			// peer must not change network or protocol during a session
+			peerVersion, err := p.checkVersionMessage(request)
+
+			if err != nil {
+				p.Logger.Trace.Printf("Session with %s is closed: %s", sess.Addr.NodeAddrToString(), err.Error())
+				return
+			}
+
+			sess.setVersion(peerVersion)
+		}
+
+		if header.Command == "ping" {
+			if header.ID > 0 {
+				sess.send(p.buildResponseFrame(header, []byte{1}))
+			}
+			continue
+		}
+
+		// while all slots are busy nothing is read from the peer. Flood of requests slows only this session
+		select {
+		case sess.inflight <- struct{}{}:
+		case <-sess.closed:
+			return
+		}
+
+		go p.handleRequest(sess, header, request, authstring)
+	}
+}
+
+// Processes a request from a peer. Response is sent only if the peer waits for it
+func (p *peerSessions) handleRequest(sess *peerSession, header netlib.FrameHeader, request []byte, authstring string) {
+	defer func() { <-sess.inflight }()
+
+	response := p.S.processRequest(header.Command, request, authstring, sess.IP, sess)
+
+	if response == nil || header.ID == 0 {
+		return
+	}
+
+	frame := p.buildResponseFrame(header, response)
+
+	if frame == nil {
+		frame = p.buildResponseFrame(header, p.S.buildErrorResponse(errors.New("Response is too big")))
+	}
+
+	sess.send(frame)
+}
+
+// Writes messages from the queue to a peer. Sends pings when there is nothing to send
+func (p *peerSessions) writeLoop(sess *peerSession) {
+	defer p.closeSession(sess)
+
+	ticker := time.NewTicker(peerPingInterval / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sess.closed:
+			return
+
+		case data := <-sess.sendQueue:
+			sess.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+
+			_, err := sess.conn.Write(data)
+
+			if err != nil {
+				p.Logger.Trace.Printf("Sending to %s failed: %s", sess.Addr.NodeAddrToString(), err.Error())
+				return
+			}
+
+			sess.lock.Lock()
+			sess.LastSent = time.Now()
+			sess.lock.Unlock()
+
+		case <-ticker.C:
+			sess.lock.Lock()
+			idle := time.Since(sess.LastSent) > peerPingInterval
+			sess.lock.Unlock()
+
+			if idle {
+				go p.ping(sess)
+			}
+		}
+	}
+}
+
+// Sends ping and saves the time of response
+func (p *peerSessions) ping(sess *peerSession) {
+	id := sess.newRequestID()
+
+	data, err := netlib.BuildSessionFrame("ping", netlib.FrameFlagSession, id, []byte{}, []byte{})
+
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+
+	_, err = sess.request(data, id, peerResponseTimeout)
+
+	if err != nil {
+		p.Logger.Trace.Printf("Ping to %s failed: %s", sess.Addr.NodeAddrToString(), err.Error())
+		return
+	}
+
+	sess.lock.Lock()
+	sess.PingTime = time.Since(start)
+	sess.lock.Unlock()
+}
+
+// Closes the session and removes it from the list
+func (p *peerSessions) closeSession(sess *peerSession) {
+	sess.close()
+
+	p.lock.Lock()
+
+	key := getSessionKey(sess.Addr)
+
+	current := p.sessions[key] == sess
+
+	if current {
+		delete(p.sessions, key)
+	}
+
+	p.lock.Unlock()
+
+	if current && p.S.TxRelay != nil {
+		// the node can come back with empty pool
+		p.S.TxRelay.Forget(sess.Addr)
+	}
+}
+
+// Version command is the first message in a session. Verack with same data is the answer to it.
+// Peer is the address of the receiver as we see it
+func (p *peerSessions) buildVersionCommand(verack bool, peer netlib.NodeAddr) ([]byte, error) {
+	node := p.S.CloneNode()
+
+	state, err := node.GetVersionState()
+
+	if err != nil {
+		return nil, err
+	}
+
+	state.AddrYou = peer
+
+	if verack {
+		return node.NodeClient.BuildVerackCommand(state)
+	}
+	return node.NodeClient.BuildVersionCommand(state)
+}
+
+// Parses version data received from a peer and checks if the peer can be accepted
+func (p *peerSessions) checkVersionMessage(request []byte) (nodeclient.ComVersion, error) {
+	var payload nodeclient.ComVersion
+
+	err := gob.NewDecoder(bytes.NewReader(request)).Decode(&payload)
+
+	if err != nil {
+		return payload, errors.New("Parse version: " + err.Error())
+	}
+
+	state, err := p.S.CloneNode().GetVersionState()
+
+	if err != nil {
+		return payload, err
+	}
+
+	return payload, checkPeerVersion(payload, state)
+}
+
+// Copies built request and sets session flags and ID in it
+func (p *peerSessions) prepareFrame(data []byte, flags uint8, id uint32) []byte {
+	frame := utils.CopyBytes(data)
+
+	netlib.SetFrameSessionData(frame, flags, id)
+
+	return frame
+}
+
+func (p *peerSessions) buildResponseFrame(header netlib.FrameHeader, response []byte) []byte {
+	frame, err := netlib.BuildSessionFrame(header.Command, netlib.FrameFlagSession|netlib.FrameFlagResponse, header.ID, response, []byte{})
+
+	if err != nil {
+		p.Logger.Error.Println("Building response frame error: ", err.Error())
+		return nil
+	}
+	return frame
+}
+
+// Checks version data of other node. It must use supported protocol and have same genesis block
+func checkPeerVersion(peer nodeclient.ComVersion, my nodeclient.ComVersion) error {
+	if peer.Version < netlib.MinNodeVersion {
+		return errors.New(fmt.Sprintf("Protocol version %d is not supported", peer.Version))
+	}
+
+	if !bytes.Equal(peer.GenesisHash, my.GenesisHash) {
+		return errors.New(fmt.Sprintf("Genesis block %x is different from our %x", peer.GenesisHash, my.GenesisHash))
+	}
+	return nil
+}
+
+// Returns true if the node has full data of blocks after the height
+func canServeBlocks(peer nodeclient.ComVersion, height int) bool {
+	if peer.Services&netlib.ServiceFullNode > 0 {
+		return true
+	}
+	return peer.Services&netlib.ServicePruned > 0 && peer.PrunedHeight <= height
+}
+
+// Same node can be known as localhost and 127.0.0.1. It must have one session
+func getSessionKey(addr netlib.NodeAddr) string {
+	return addr.Normalized().NodeAddrToString()
+}
+
+func (sess *peerSession) setVersion(version nodeclient.ComVersion) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	sess.Version = version.Version
+	sess.Services = version.Services
+	sess.UserAgent = version.UserAgent
+	sess.BestHeight = version.BestHeight
+	sess.PrunedHeight = version.PrunedHeight
+}
+
+// Checks if the peer returns headers and full blocks after the height
+func (sess *peerSession) canServeSync(height int) bool {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if sess.Version < netlib.HeadersSyncVersion {
+		return false
+	}
+	return canServeBlocks(nodeclient.ComVersion{Services: sess.Services, PrunedHeight: sess.PrunedHeight}, height)
+}
+
+func (sess *peerSession) getBestHeight() int {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	return sess.BestHeight
+}
+
+// Returns state of the session for API
+func (sess *peerSession) getInfo() jsonPeerInfo {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	info := jsonPeerInfo{}
+	info.Addr = sess.Addr.NodeAddrToString()
+	info.IP = sess.IP
+	info.Outbound = sess.Outbound
+	info.Version = sess.Version
+	info.Services = sess.Services
+	info.UserAgent = sess.UserAgent
+	info.BestHeight = sess.BestHeight
+	info.PrunedHeight = sess.PrunedHeight
+	info.LastReceived = sess.LastReceived.Unix()
+	info.LastSent = sess.LastSent.Unix()
+	info.PingTime = sess.PingTime.Nanoseconds() / int64(time.Millisecond)
+
+	return info
+}
+
+func (sess *peerSession) newRequestID() uint32 {
+	return atomic.AddUint32(&sess.nextID, 1)
+}
+
+// Puts data to the send queue. Returns error if the queue is full, the peer doesn't read our messages
+func (sess *peerSession) send(data []byte) error {
+	if data == nil {
+		return nil
+	}
+
+	select {
+	case <-sess.closed:
+		return errors.New(fmt.Sprintf("Session with %s is closed", sess.Addr.NodeAddrToString()))
+	default:
+	}
+
+	select {
+	case sess.sendQueue <- data:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Send queue of %s is full", sess.Addr.NodeAddrToString()))
+	}
+}
+
+// Sends request and waits for a response with same ID
+func (sess *peerSession) request(data []byte, id uint32, timeout time.Duration) ([]byte, error) {
+	ch := make(chan []byte, 1)
+
+	sess.lock.Lock()
+	sess.pending[id] = ch
+	sess.lock.Unlock()
+
+	defer func() {
+		sess.lock.Lock()
+		delete(sess.pending, id)
+		sess.lock.Unlock()
+	}()
+
+	err := sess.send(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-timer.C:
+		return nil, errors.New(fmt.Sprintf("No response from %s", sess.Addr.NodeAddrToString()))
+	case <-sess.closed:
+		return nil, errors.New(fmt.Sprintf("Session with %s is closed", sess.Addr.NodeAddrToString()))
+	}
+}
+
+// Passes a response to the request that waits for it
+func (sess *peerSession) deliverResponse(id uint32, response []byte) {
+	sess.lock.Lock()
+	ch, ok := sess.pending[id]
+	sess.lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// same ID could be sent twice by a wrong peer. don't block reading because of this
+	select {
+	case ch <- response:
+	default:
+	}
+}
+
+func (sess *peerSession) close() {
+	sess.closeOnce.Do(func() {
+		close(sess.closed)
+		sess.conn.Close()
+	})
+}
+
+func (sess *peerSession) isClosed() bool {
+	select {
+	case <-sess.closed:
+		return true
+	default:
+	}
+	return false
+}
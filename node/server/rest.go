@@ -0,0 +1,398 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/lib/wallet"
+	"github.com/gelembjuk/democoin/node/nodemanager"
+)
+
+// Blocks deeper than this are not expected to be replaced. Responses about them are cached longer
+const restConfirmedDepth = 6
+
+// Cache times of responses in seconds
+const restCacheImmutable = 86400 // block by hash never changes, if it is not pruned later
+const restCacheConfirmed = 3600
+const restCacheRecent = 10
+
+// Read-only HTTP API without auth. Responses are JSON with Cache-Control and ETag headers
+type restServer struct {
+	S      *NodeServer
+	Logger *utils.LoggerMan
+	server *http.Server
+}
+
+// Error with HTTP status
+type restError struct {
+	Status  int
+	Message string
+}
+
+func (e *restError) Error() string {
+	return e.Message
+}
+
+// Response data and how long it can be cached
+type restResult struct {
+	data   interface{}
+	maxAge int
+}
+
+type restTip struct {
+	Hash   string
+	Height int
+}
+
+type restUnspent struct {
+	TXID     string
+	Vout     int
+	Value    float64
+	From     string
+	Coinbase bool
+}
+
+type restHistory struct {
+	TXID     string
+	Incoming bool
+	Address  string // sender for incoming, receiver for outgoing
+	Value    float64
+}
+
+func newRESTServer(s *NodeServer) *restServer {
+	r := &restServer{}
+	r.S = s
+	r.Logger = s.Logger
+
+	return r
+}
+
+func (r *restServer) Start() error {
+	host := r.S.RESTHost
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	ln, err := net.Listen(netlib.Protocol, net.JoinHostPort(host, strconv.Itoa(r.S.RESTPort)))
+
+	if err != nil {
+		return err
+	}
+
+	r.server = &http.Server{Handler: r, ReadTimeout: 30 * time.Second, WriteTimeout: 60 * time.Second}
+
+	r.Logger.Trace.Printf("REST server listens on %s", ln.Addr().String())
+
+	go r.server.Serve(ln)
+
+	return nil
+}
+
+func (r *restServer) Stop() {
+	r.server.Close()
+}
+
+func (r *restServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		r.writeError(w, &restError{http.StatusMethodNotAllowed, "Only GET requests are accepted"})
+		return
+	}
+
+	result, err := r.execute(strings.Trim(req.URL.Path, "/"))
+
+	if err != nil {
+		r.writeError(w, err)
+		return
+	}
+
+	body, err := json.Marshal(result.data)
+
+	if err != nil {
+		r.writeError(w, err)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	etag := fmt.Sprintf(`"%x"`, hash[:16])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", result.maxAge))
+	w.Header().Set("ETag", etag)
+
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(body)
+}
+
+func (r *restServer) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	if rerr, ok := err.(*restError); ok {
+		status = rerr.Status
+	}
+
+	body, _ := json.Marshal(map[string]string{"Error": err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Finds a handler for the path and runs it with own node object. All reads see same state of the DB
+func (r *restServer) execute(path string) (*restResult, error) {
+	parts := strings.Split(path, "/")
+
+	var handler func(node *nodemanager.Node) (*restResult, error)
+
+	switch {
+	case len(parts) == 2 && parts[0] == "block":
+		handler = func(node *nodemanager.Node) (*restResult, error) {
+			return r.getBlock(node, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "block" && parts[1] == "height":
+		handler = func(node *nodemanager.Node) (*restResult, error) {
+			return r.getBlockAtHeight(node, parts[2])
+		}
+	case len(parts) == 2 && parts[0] == "tx":
+		handler = func(node *nodemanager.Node) (*restResult, error) {
+			return r.getTransaction(node, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "utxo":
+		handler = func(node *nodemanager.Node) (*restResult, error) {
+			return r.getUnspent(node, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "history":
+		handler = func(node *nodemanager.Node) (*restResult, error) {
+			return r.getHistory(node, parts[1])
+		}
+	case path == "chain/tip":
+		handler = r.getTip
+	default:
+		return nil, &restError{http.StatusNotFound, "Unknown path"}
+	}
+
+	sessid := utils.RandString(5)
+
+	r.Logger.Trace.Printf("REST %s, sess %s", path, sessid)
+
+	node := r.S.CloneNode()
+	node.SessionID = sessid
+
+	err := node.DBConn.OpenConnection("REST "+path, sessid)
+
+	if err != nil {
+		return nil, errors.New("Blockchain open Error: " + err.Error())
+	}
+
+	defer node.DBConn.CloseConnection()
+
+	var result *restResult
+
+	err = node.DBConn.View(func() error {
+		var err error
+		result, err = handler(node)
+		return err
+	})
+
+	if err != nil {
+		r.Logger.Trace.Printf("REST %s error: %s", path, err.Error())
+	}
+	return result, err
+}
+
+func parseRESTHash(str string) ([]byte, error) {
+	hash, err := hex.DecodeString(str)
+
+	if err != nil || len(hash) == 0 {
+		return nil, &restError{http.StatusBadRequest, "Hash must be a hex string"}
+	}
+	return hash, nil
+}
+
+func checkRESTAddress(address string) error {
+	if !(wallet.Wallet{}).ValidateAddress(address) {
+		return &restError{http.StatusBadRequest, "Address is not valid"}
+	}
+	return nil
+}
+
+// Cache time of data about a block at the height. Recent blocks can be replaced by other branch
+func (r *restServer) getCacheTime(node *nodemanager.Node, height int) int {
+	bestHeight, err := node.NodeBC.GetBestHeight()
+
+	if err == nil && bestHeight-height >= restConfirmedDepth {
+		return restCacheConfirmed
+	}
+	return restCacheRecent
+}
+
+func (r *restServer) getBlock(node *nodemanager.Node, hashStr string) (*restResult, error) {
+	hash, err := parseRESTHash(hashStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := node.NodeBC.CheckBlockExists(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, &restError{http.StatusNotFound, "Block is not found"}
+	}
+
+	block, err := node.NodeBC.GetBlock(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := restCacheImmutable
+
+	// pruning node will drop transactions of this block later
+	if !block.IsPruned() && node.PruneKeep > 0 {
+		maxAge = restCacheConfirmed
+	}
+	return &restResult{newJSONBlock(block), maxAge}, nil
+}
+
+// Block of the primary chain
+func (r *restServer) getBlockAtHeight(node *nodemanager.Node, heightStr string) (*restResult, error) {
+	height, err := strconv.Atoi(heightStr)
+
+	if err != nil || height < 0 {
+		return nil, &restError{http.StatusBadRequest, "Height must be a number"}
+	}
+
+	bestHeight, err := node.NodeBC.GetBestHeight()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if height > bestHeight {
+		return nil, &restError{http.StatusNotFound, "Block is not found"}
+	}
+
+	block, err := node.NodeBC.GetBCManager().GetBlockAtHeight(height)
+
+	if err != nil {
+		return nil, &restError{http.StatusNotFound, err.Error()}
+	}
+	return &restResult{newJSONBlock(block), r.getCacheTime(node, height)}, nil
+}
+
+// Transaction from the pool or from the primary chain
+func (r *restServer) getTransaction(node *nodemanager.Node, txIDStr string) (*restResult, error) {
+	txID, err := parseRESTHash(txIDStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := node.GetTransactionsManager().GetIfUnapprovedExists(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx != nil {
+		return &restResult{newJSONTransaction(tx, true), restCacheRecent}, nil
+	}
+
+	tx, err = node.GetTransactionsManager().GetIfExists(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return nil, &restError{http.StatusNotFound, "Transaction is not found"}
+	}
+	return &restResult{newJSONTransaction(tx, false), restCacheRecent}, nil
+}
+
+func (r *restServer) getUnspent(node *nodemanager.Node, address string) (*restResult, error) {
+	err := checkRESTAddress(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []restUnspent{}
+
+	err = node.GetTransactionsManager().ForEachUnspentOutput(address,
+		func(fromaddr string, value float64, txID []byte, output int, isbase bool) error {
+			list = append(list, restUnspent{fmt.Sprintf("%x", txID), output, value, fromaddr, isbase})
+			return nil
+		})
+
+	if err != nil {
+		return nil, err
+	}
+	return &restResult{list, restCacheRecent}, nil
+}
+
+func (r *restServer) getHistory(node *nodemanager.Node, address string) (*restResult, error) {
+	err := checkRESTAddress(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// pruned blocks have no transactions. history from them would be not complete
+	prunedHash, err := node.NodeBC.GetBCManager().GetLastPrunedHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prunedHash) > 0 {
+		return nil, &restError{http.StatusNotImplemented, "History is not available on pruned node. Old blocks have no transactions"}
+	}
+
+	history, err := node.NodeBC.GetAddressHistory(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []restHistory{}
+
+	for _, rec := range history {
+		list = append(list, restHistory{fmt.Sprintf("%x", rec.TXID), rec.IOType, rec.Address, rec.Value})
+	}
+	return &restResult{list, restCacheRecent}, nil
+}
+
+func (r *restServer) getTip(node *nodemanager.Node) (*restResult, error) {
+	hash, height, err := node.NodeBC.GetBCManager().GetState()
+
+	if err != nil {
+		return nil, err
+	}
+	return &restResult{restTip{fmt.Sprintf("%x", hash), height}, restCacheRecent}, nil
+}
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/lib/wallet"
+	"github.com/gelembjuk/democoin/node/nodemanager"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Max size of a request body. Raw transactions are much smaller
+const rpcMaxRequestSize = 1024 * 1024
+
+// Error codes of JSON-RPC 2.0
+const rpcErrorParse = -32700
+const rpcErrorInvalidRequest = -32600
+const rpcErrorMethodNotFound = -32601
+const rpcErrorInvalidParams = -32602
+const rpcErrorInternal = -32603
+const rpcErrorServer = -32000 // a method failed
+
+type rpcRequest struct {
+	JSONRPC string
+	Method  string
+	Params  json.RawMessage
+	ID      json.RawMessage
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Method gets a node object with opened DB connection and positional params of the request
+type rpcMethod func(node *nodemanager.Node, params []json.RawMessage) (interface{}, error)
+
+// JSON-RPC 2.0 over HTTP. Requests must have basic auth with configured credentials or
+// with the node auth string as a password. The auth string is accepted only on loopback listener,
+// HTTP is not encrypted
+type rpcServer struct {
+	S        *NodeServer
+	Logger   *utils.LoggerMan
+	methods  map[string]rpcMethod
+	server   *http.Server
+	loopback bool // listens on loopback address
+}
+
+// Checks RPC credentials before the node server starts. A node with RPC user and empty password must not start
+func checkRPCConfig(s *NodeServer) error {
+	if s.RPCPort > 0 && s.RPCUser != "" && s.RPCPassword == "" {
+		return errors.New("RPC password must be set for the RPC user")
+	}
+	return nil
+}
+
+func newRPCServer(s *NodeServer) *rpcServer {
+	r := &rpcServer{}
+	r.S = s
+	r.Logger = s.Logger
+
+	r.methods = map[string]rpcMethod{
+		"getbalance":         r.getBalance,
+		"getblock":           r.getBlock,
+		"gettransaction":     r.getTransaction,
+		"send":               r.send,
+		"sendrawtransaction": r.sendRawTransaction,
+		"getunapproved":      r.getUnapproved,
+		"getpeerinfo":        r.getPeerInfo,
+		"addnode":            r.addNode,
+		"getstate":           r.getState,
+	}
+	return r
+}
+
+func (r *rpcServer) Start() error {
+	if r.S.RPCUser == "" && r.S.NodeAuthStr == "" {
+		return errors.New("RPC credentials are not set")
+	}
+
+	host := r.S.RPCHost
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	ln, err := net.Listen(netlib.Protocol, net.JoinHostPort(host, strconv.Itoa(r.S.RPCPort)))
+
+	if err != nil {
+		return err
+	}
+
+	tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+
+	r.loopback = ok && tcpAddr.IP.IsLoopback()
+
+	if !r.loopback && r.S.RPCUser == "" {
+		ln.Close()
+		return errors.New("RPC user must be set to listen on not loopback host")
+	}
+
+	r.server = &http.Server{Handler: r, ReadTimeout: 30 * time.Second, WriteTimeout: 60 * time.Second}
+
+	r.Logger.Trace.Printf("RPC server listens on %s", ln.Addr().String())
+
+	go r.server.Serve(ln)
+
+	return nil
+}
+
+func (r *rpcServer) Stop() {
+	r.server.Close()
+}
+
+func (r *rpcServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.checkAuth(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="node"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, rpcMaxRequestSize))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var result interface{}
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		// batch. Responses are returned in same order, notifications have no response
+		var requests []json.RawMessage
+
+		err = json.Unmarshal(body, &requests)
+
+		if err != nil || len(requests) == 0 {
+			result = r.errorResponse(nil, rpcErrorInvalidRequest, "Invalid batch")
+		} else {
+			responses := []*rpcResponse{}
+
+			for _, request := range requests {
+				if response := r.processRequest(request); response != nil {
+					responses = append(responses, response)
+				}
+			}
+			if len(responses) > 0 {
+				result = responses
+			}
+		}
+	} else if response := r.processRequest(body); response != nil {
+		result = response
+	}
+
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Configured credentials or any user with the node auth string as a password if the server is on loopback.
+// Empty password never matches
+func (r *rpcServer) checkAuth(req *http.Request) bool {
+	user, password, ok := req.BasicAuth()
+
+	if !ok || password == "" {
+		return false
+	}
+
+	if r.S.RPCUser != "" && r.S.RPCPassword != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(r.S.RPCUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(r.S.RPCPassword)) == 1 {
+		return true
+	}
+
+	return r.loopback && r.S.NodeAuthStr != "" && subtle.ConstantTimeCompare([]byte(password), []byte(r.S.NodeAuthStr)) == 1
+}
+
+// Executes one request. Returns nil for notifications (requests without ID)
+func (r *rpcServer) processRequest(data []byte) *rpcResponse {
+	request := rpcRequest{}
+
+	err := json.Unmarshal(data, &request)
+
+	if err != nil {
+		return r.errorResponse(nil, rpcErrorParse, "Parse error: "+err.Error())
+	}
+
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return r.errorResponse(request.ID, rpcErrorInvalidRequest, "Invalid request")
+	}
+
+	method, ok := r.methods[request.Method]
+
+	if !ok {
+		err = &rpcError{rpcErrorMethodNotFound, "Method not found"}
+	}
+
+	params := []json.RawMessage{}
+
+	if err == nil && len(request.Params) > 0 && string(request.Params) != "null" {
+		if json.Unmarshal(request.Params, &params) != nil {
+			err = &rpcError{rpcErrorInvalidParams, "Params must be an array"}
+		}
+	}
+
+	var result interface{}
+
+	if err == nil {
+		result, err = r.execute(request.Method, method, params)
+	}
+
+	if len(request.ID) == 0 {
+		// notification. The client doesn't wait for any response
+		return nil
+	}
+
+	if err != nil {
+		if rerr, ok := err.(*rpcError); ok {
+			return r.errorResponse(request.ID, rerr.Code, rerr.Message)
+		}
+		return r.errorResponse(request.ID, rpcErrorServer, err.Error())
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: request.ID}
+}
+
+// Runs a method with own node object and DB connection as any network request
+func (r *rpcServer) execute(name string, method rpcMethod, params []json.RawMessage) (interface{}, error) {
+	sessid := utils.RandString(5)
+
+	r.Logger.Trace.Printf("RPC %s, sess %s", name, sessid)
+
+	node := r.S.CloneNode()
+	node.SessionID = sessid
+
+	err := node.DBConn.OpenConnection("RPC "+name, sessid)
+
+	if err != nil {
+		return nil, &rpcError{rpcErrorInternal, "Blockchain open Error: " + err.Error()}
+	}
+
+	defer node.DBConn.CloseConnection()
+
+	var result interface{}
+
+	run := func() error {
+		var err error
+		result, err = method(node, params)
+		return err
+	}
+
+	if r.isWriteMethod(name) {
+		// the checks and the changes are done in one write transaction, same as for network commands
+		err = node.DBConn.Update(run)
+	} else if r.isNetworkMethod(name) {
+		// other nodes are requested. DB users must not wait for them
+		err = run()
+	} else {
+		err = node.DBConn.View(run)
+	}
+
+	if err != nil {
+		r.Logger.Trace.Printf("RPC %s error: %s", name, err.Error())
+	}
+	return result, err
+}
+
+// Methods that change the DB
+func (r *rpcServer) isWriteMethod(name string) bool {
+	switch name {
+	case "send", "sendrawtransaction":
+		return true
+	}
+	return false
+}
+
+// Methods that send requests to other nodes and don't use the blockchain DB
+func (r *rpcServer) isNetworkMethod(name string) bool {
+	return name == "addnode"
+}
+
+func (r *rpcServer) errorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{code, message}, ID: id}
+}
+
+// Parses positional params to values. All values are required
+func parseRPCParams(params []json.RawMessage, values ...interface{}) error {
+	if len(params) != len(values) {
+		return &rpcError{rpcErrorInvalidParams, fmt.Sprintf("Expected %d params, got %d", len(values), len(params))}
+	}
+
+	for i, value := range values {
+		err := json.Unmarshal(params[i], value)
+
+		if err != nil {
+			return &rpcError{rpcErrorInvalidParams, fmt.Sprintf("Param %d: %s", i+1, err.Error())}
+		}
+	}
+	return nil
+}
+
+// Parses a param that is a hex string
+func parseRPCHexParam(params []json.RawMessage) ([]byte, error) {
+	var str string
+
+	err := parseRPCParams(params, &str)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(str)
+
+	if err != nil || len(data) == 0 {
+		return nil, &rpcError{rpcErrorInvalidParams, "Param must be a hex string"}
+	}
+	return data, nil
+}
+
+// Params: address
+func (r *rpcServer) getBalance(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	var address string
+
+	err := parseRPCParams(params, &address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !(wallet.Wallet{}).ValidateAddress(address) {
+		return nil, &rpcError{rpcErrorInvalidParams, "Address is not valid"}
+	}
+
+	return node.GetTransactionsManager().GetAddressBalance(address)
+}
+
+// Params: block hash
+func (r *rpcServer) getBlock(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	hash, err := parseRPCHexParam(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := node.NodeBC.CheckBlockExists(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("Block %x is not found", hash))
+	}
+
+	block, err := node.NodeBC.GetBlock(hash)
+
+	if err != nil {
+		return nil, err
+	}
+	return newJSONBlock(block), nil
+}
+
+// Params: transaction ID. Transactions in blocks of the primary chain and in the pool are found
+func (r *rpcServer) getTransaction(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	txID, err := parseRPCHexParam(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := node.GetTransactionsManager().GetIfUnapprovedExists(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx != nil {
+		return newJSONTransaction(tx, true), nil
+	}
+
+	tx, err = node.GetTransactionsManager().GetIfExists(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return nil, errors.New(fmt.Sprintf("Transaction %x is not found", txID))
+	}
+	return newJSONTransaction(tx, false), nil
+}
+
+// Params: from address, to address, amount. From must be a wallet in the data directory of the node
+func (r *rpcServer) send(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	var from, to string
+	var amount float64
+
+	err := parseRPCParams(params, &from, &to, &amount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, &rpcError{rpcErrorInvalidParams, "Amount must be positive"}
+	}
+
+	wallets := wallet.Wallets{}
+	wallets.DataDir = r.S.DataDir
+	wallets.Logger = r.Logger
+
+	err = wallets.LoadFromFile()
+
+	if err != nil {
+		return nil, errors.New("Wallets are not loaded: " + err.Error())
+	}
+
+	walletobj, err := wallets.GetWallet(from)
+
+	if err != nil {
+		return nil, err
+	}
+
+	txID, err := node.Send(walletobj.GetPublicKey(), walletobj.GetPrivateKey(), to, amount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the transaction is sent to other nodes already
+	r.S.TryToMakeNewBlock([]byte{0})
+
+	return fmt.Sprintf("%x", txID), nil
+}
+
+// Params: serialized signed transaction in hex
+func (r *rpcServer) sendRawTransaction(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	data, err := parseRPCHexParam(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx := structures.Transaction{}
+
+	err = tx.DeserializeTransaction(data)
+
+	if err != nil {
+		return nil, &rpcError{rpcErrorInvalidParams, "Transaction is not parsed: " + err.Error()}
+	}
+
+	err = node.GetTransactionsManager().ReceivedNewTransaction(&tx)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Transaction accepting error: %s", err.Error()))
+	}
+
+	// the transaction is sent to other nodes if a block is not made
+	r.S.TryToMakeNewBlock(tx.ID)
+
+	return fmt.Sprintf("%x", tx.ID), nil
+}
+
+// Transactions in the pool
+func (r *rpcServer) getUnapproved(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	txs, err := node.GetTransactionsManager().GetUnapprovedTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []jsonTransaction{}
+
+	for _, tx := range txs {
+		list = append(list, newJSONTransaction(tx, true))
+	}
+	return list, nil
+}
+
+// Opened sessions with other nodes
+func (r *rpcServer) getPeerInfo(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	list := []jsonPeerInfo{}
+
+	if r.S.Peers == nil {
+		return list, nil
+	}
+
+	for _, sess := range r.S.Peers.GetSessions() {
+		list = append(list, sess.getInfo())
+	}
+	return list, nil
+}
+
+// Params: node address host:port
+func (r *rpcServer) addNode(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	var address string
+
+	err := parseRPCParams(params, &address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addr := netlib.NodeAddr{}
+
+	err = addr.LoadFromString(address)
+
+	if err == nil {
+		err = node.NodeClient.CheckNodeAddress(addr)
+	}
+
+	if err != nil {
+		return nil, &rpcError{rpcErrorInvalidParams, "Wrong node address: " + err.Error()}
+	}
+
+	r.S.Node.AddNodeToKnown(addr, netlib.NodeSourceManual, true)
+
+	return true, nil
+}
+
+func (r *rpcServer) getState(node *nodemanager.Node, params []json.RawMessage) (interface{}, error) {
+	return r.S.getNodeState(node)
+}
@@ -2,12 +2,12 @@ package server
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	netlib "github.com/gelembjuk/democoin/lib/net"
@@ -16,11 +16,27 @@ import (
 	"github.com/gelembjuk/democoin/node/nodemanager"
 )
 
+// Own host is learned from last reports of nodes we connected to. A host is used when most of them
+// agree and there are enough of different nodes
+const externalHostReports = 10
+const externalHostMinVotes = 3
+
+// Our host as a peer sees it
+type externalHostReport struct {
+	peer string
+	host string
+}
+
 type NodeServer struct {
 	DataDir string
 	Node    *nodemanager.Node
 
-	NodeAddress netlib.NodeAddr
+	NodeAddress netlib.NodeAddr // address that other nodes use to connect. Host can be empty or local
+	BindHost    string          // host to listen on. All interfaces if empty
+
+	externalHost    string // own host as other nodes see it. Used if NodeAddress has no external host
+	externalReports []externalHostReport
+	externalLock    sync.Mutex
 
 	Transit nodeTransit
 
@@ -31,6 +47,27 @@ type NodeServer struct {
 	BlockBilderChan     chan []byte
 
 	NodeAuthStr string
+
+	MaxConnections int // max number of inbound connections. Default is used if 0
+
+	Encrypt bool            // connections to other nodes are encrypted
+	NodeKey *netlib.NodeKey // identity key of this node
+
+	LanDiscovery bool // nodes of same chain in local network are found with multicast
+
+	RPCPort     int    // JSON-RPC is not started if 0
+	RPCHost     string // localhost if empty
+	RPCUser     string // RPC requests are accepted with these credentials or with the node auth string
+	RPCPassword string
+
+	RESTPort int    // read-only REST API is not started if 0
+	RESTHost string // localhost if empty
+
+	Peers   *peerSessions
+	Bans    *peerBans
+	Limits  *connectionLimits
+	Sync    *blockSync
+	TxRelay *txRelay
 }
 
 func (s *NodeServer) GetClient() *nodeclient.NodeClient {
@@ -39,14 +76,19 @@ func (s *NodeServer) GetClient() *nodeclient.NodeClient {
 }
 
 // handle received data. It can be one way command or a request for some data
+// If it is first message of a peer session then the connection stays opened and is used for next messages
 
-func (s *NodeServer) handleConnection(conn net.Conn) {
-	starttime := time.Now().UnixNano()
-	sessid := utils.RandString(5)
+func (s *NodeServer) handleConnection(rawconn net.Conn) {
+	// encrypted connection starts with the handshake. Plain connections are accepted too
+	conn, err := netlib.SecureServer(rawconn, s.NodeKey)
 
-	//s.Logger.Trace.Printf("New command. Start reading %s", sessid)
+	if err != nil {
+		s.Logger.Trace.Printf("Handshake with %s failed: %s", getConnectionIP(rawconn), err.Error())
+		rawconn.Close()
+		return
+	}
 
-	command, request, authstring, err := s.readRequest(conn)
+	header, request, authstring, err := s.readRequest(conn, requestHeaderTimeout)
 
 	if err != nil {
 		s.sendErrorBack(conn, errors.New("Network Data Reading Error: "+err.Error()))
@@ -54,6 +96,57 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		return
 	}
 
+	ip := getConnectionIP(conn)
+
+	if s.Bans != nil && s.Bans.IsBanned(ip) && !s.isManagementRequest(conn, authstring) {
+		// only local management commands are accepted from a banned address
+		s.Logger.Trace.Printf("Connection from banned %s is refused", ip)
+		conn.Close()
+		return
+	}
+
+	if s.Limits != nil && !s.Limits.AllowRequest(ip) {
+		s.sendErrorBack(conn, errors.New("Too many requests"))
+		conn.Close()
+		return
+	}
+
+	if authstring != "" && !s.isManagementRequest(conn, authstring) {
+		// auth string is sent only in encrypted connection. Other side must have key of this node
+		s.sendErrorBack(conn, errors.New("Management commands require encrypted connection with the node key"))
+		conn.Close()
+		return
+	}
+
+	if header.IsSession() {
+		s.Peers.acceptSession(conn, header, request)
+		return
+	}
+
+	response := s.processRequest(header.Command, request, authstring, ip, nil)
+
+	if response != nil {
+		s.Logger.Trace.Printf("Responding %d bytes\n", len(response))
+
+		conn.SetWriteDeadline(time.Now().Add(responseWriteTimeout))
+
+		_, err := conn.Write(response)
+
+		if err != nil {
+			s.Logger.Error.Println("Sending response error: ", err.Error())
+		}
+	}
+
+	conn.Close()
+}
+
+// Executes a command received from network. Returns data to send back or nil if there is no response
+// First byte of the response is bool flag to indicate if request was success
+// Session is nil if the request is received in separate connection
+func (s *NodeServer) processRequest(command string, request []byte, authstring string, requestIP string, sess *peerSession) []byte {
+	starttime := time.Now().UnixNano()
+	sessid := utils.RandString(5)
+
 	s.Logger.Trace.Printf("Received %s command, %s, old sess %s", command, sessid, s.Node.SessionID)
 
 	requestobj := NodeServerRequest{}
@@ -61,141 +154,327 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	requestobj.Node.SessionID = sessid
 	requestobj.Logger = s.Logger
 	requestobj.Request = request[:]
-	requestobj.NodeAuthStrIsGood = (s.NodeAuthStr == authstring && len(authstring) > 0)
+	requestobj.NodeAuthStrIsGood = s.isNodeAuthString(authstring)
 	requestobj.S = s
 	requestobj.S.Node.SessionID = sessid
 	requestobj.SessID = sessid
+	requestobj.RequestIP = requestIP
+	requestobj.Session = sess
 
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		requestobj.RequestIP = addr.IP.String()
-	}
 	request = nil
 
 	// open blockchain. and close in the end ofthis function
-	err = requestobj.Node.DBConn.OpenConnection("HandleCommand "+command, sessid)
+	// DB file stays opened while server works. This only prepares objects to access it
+	err := requestobj.Node.DBConn.OpenConnection("HandleCommand "+command, sessid)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Blockchain open Error: "+err.Error()))
-		conn.Close()
-		return
+		return s.buildErrorResponse(errors.New("Blockchain open Error: " + err.Error()))
 	}
 
 	//s.Logger.Trace.Printf("Nodes Network State: %d , %s", len(requestobj.Node.NodeNet.Nodes), requestobj.Node.NodeNet.Nodes)
 
 	var rerr error
 
+	if s.isReadCommand(command) {
+		// all reads of a request see same state of the DB. Read requests don't wait for each other
+		rerr = requestobj.Node.DBConn.View(func() error {
+			return s.executeCommand(command, &requestobj)
+		})
+	} else {
+		// a handler starts write transaction only to check and save changes. Other nodes are requested outside of it,
+		// writers must not wait for a slow node
+		rerr = s.executeCommand(command, &requestobj)
+	}
+
+	requestobj.Node.DBConn.CloseConnection()
+
+	if rerr == nil {
+		for _, f := range requestobj.afterCommit {
+			f()
+		}
+	}
+
+	duration := time.Since(time.Unix(0, starttime))
+	ms := duration.Nanoseconds() / int64(time.Millisecond)
+	s.Logger.Trace.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
+
+	if rerr != nil {
+		s.Logger.Error.Println("Network Command Handle Error: ", rerr.Error())
+		s.Logger.Trace.Println("Network Command Handle Error: ", rerr.Error())
+
+		if requestobj.HasResponse {
+			// return error to the client
+			// first byte is bool false to indicate there was error
+			return s.buildErrorResponse(rerr)
+		}
+		return nil
+	}
+
+	if requestobj.HasResponse && requestobj.Response != nil {
+		// send this response back
+		// first byte is bool true to indicate request was success
+		return append([]byte{1}, requestobj.Response...)
+	}
+	return nil
+}
+
+// Executes network command
+func (s *NodeServer) executeCommand(command string, requestobj *NodeServerRequest) error {
 	switch command {
 	case "addr":
-		rerr = requestobj.handleAddr()
+		return requestobj.handleAddr()
 	case "viod":
 		// do nothing
 		s.Logger.Trace.Println("Void command reveived")
+		return nil
 	case "block":
-		rerr = requestobj.handleBlock()
+		return requestobj.handleBlock()
+	case "cmpctblock":
+		return requestobj.handleCompactBlock()
+	case "getblocktxn":
+		return requestobj.handleGetBlockTxs()
 	case "inv":
-		rerr = requestobj.handleInv()
+		return requestobj.handleInv()
+	case "mempool":
+		return requestobj.handleMempool()
 	case "getblocks":
-		rerr = requestobj.handleGetBlocks()
+		return requestobj.handleGetBlocks()
 
 	case "getblocksup":
-		rerr = requestobj.handleGetBlocksUpper()
+		return requestobj.handleGetBlocksUpper()
 
 	case "getdata":
-		rerr = requestobj.handleGetData()
+		return requestobj.handleGetData()
+
+	case "getheaders":
+		return requestobj.handleGetHeaders()
+
+	case "getbodies":
+		return requestobj.handleGetBodies()
 
 	case "getunspent":
-		rerr = requestobj.handleGetUnspent()
+		return requestobj.handleGetUnspent()
 
 	case "gethistory":
-		rerr = requestobj.handleGetHistory()
+		return requestobj.handleGetHistory()
 
 	case "getbalance":
-		rerr = requestobj.handleGetBalance()
+		return requestobj.handleGetBalance()
 
 	case "getfblocks":
-		rerr = requestobj.handleGetFirstBlocks()
+		return requestobj.handleGetFirstBlocks()
 
 	case "tx":
-		rerr = requestobj.handleTx()
+		return requestobj.handleTx()
 
 	case "txfull":
-		rerr = requestobj.handleTxFull()
+		return requestobj.handleTxFull()
 
 	case "txdata":
-		rerr = requestobj.handleTxData()
+		return requestobj.handleTxData()
 
 	case "txrequest":
-		rerr = requestobj.handleTxRequest()
+		return requestobj.handleTxRequest()
 
 	case "getnodes":
-		rerr = requestobj.handleGetNodes()
+		return requestobj.handleGetNodes()
+
+	case "getnodestats":
+		return requestobj.handleGetNodesStats()
 
 	case "addnode":
-		rerr = requestobj.handleAddNode()
+		return requestobj.handleAddNode()
 
 	case "removenode":
-		rerr = requestobj.handleRemoveNode()
+		return requestobj.handleRemoveNode()
 
 	case "getstate":
-		rerr = requestobj.handleGetState()
+		return requestobj.handleGetState()
+
+	case "bannode":
+		return requestobj.handleBanNode()
+
+	case "unbannode":
+		return requestobj.handleUnbanNode()
+
+	case "getbanned":
+		return requestobj.handleGetBanned()
+
+	case "pinnode":
+		return requestobj.handlePinNode()
+
+	case "unpinnode":
+		return requestobj.handleUnpinNode()
+
+	case "getpinned":
+		return requestobj.handleGetPinned()
+
+	case "chainblocks":
+		return requestobj.handleGetChainBlocks()
+
+	case "exportchain":
+		return requestobj.handleExportChain()
+
+	case "dumputxoset":
+		return requestobj.handleDumpUTXOSet()
+
+	case "backup":
+		return requestobj.handleBackup()
+
+	case "verifychain":
+		return requestobj.handleVerifyChain()
+
+	case "unapproved":
+		return requestobj.handleGetUnapproved()
 
 	case "version":
-		rerr = requestobj.handleVersion()
+		return requestobj.handleVersion()
 	default:
-		rerr = errors.New("Unknown command!")
+		return errors.New("Unknown command!")
 	}
+}
 
-	requestobj.Node.DBConn.CloseConnection()
+// Commands that only read data from the DB
+func (s *NodeServer) isReadCommand(command string) bool {
+	switch command {
+	case "getblocks", "getblocksup", "getdata", "getheaders", "getbodies", "getblocktxn", "mempool", "getunspent", "gethistory",
+		"getbalance", "getfblocks", "getnodes", "getstate", "getbanned", "getpinned", "chainblocks", "exportchain",
+		"dumputxoset", "backup", "verifychain", "unapproved":
+		return true
+	}
+	return false
+}
 
-	if rerr != nil {
-		s.Logger.Error.Println("Network Command Handle Error: ", rerr.Error())
-		s.Logger.Trace.Println("Network Command Handle Error: ", rerr.Error())
+// Checks if a request is from local management client
+func (s *NodeServer) isNodeAuthString(authstring string) bool {
+	return s.NodeAuthStr == authstring && len(authstring) > 0
+}
 
-		if requestobj.HasResponse {
-			// return error to the client
-			// first byte is bool false to indicate there was error
-			s.sendErrorBack(conn, rerr)
-		}
+// Checks if a request is from local management client. It must be sent in encrypted connection
+// and the client must have key of this node, it has access to the data directory
+func (s *NodeServer) isManagementRequest(conn net.Conn, authstring string) bool {
+	if !s.isNodeAuthString(authstring) || s.NodeKey == nil {
+		return false
 	}
+	return bytes.Equal(netlib.GetConnectionKey(conn), s.NodeKey.PublicKey)
+}
 
-	if requestobj.HasResponse && requestobj.Response != nil && rerr == nil {
-		// send this response back
-		// first byte is bool true to indicate request was success
-		dataresponse := append([]byte{1}, requestobj.Response...)
+// response error to a client
+func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
+	dataresponse := s.buildErrorResponse(err)
 
-		s.Logger.Trace.Printf("Responding %d bytes\n", len(dataresponse))
+	if dataresponse == nil {
+		return
+	}
 
-		_, err := conn.Write(dataresponse)
+	s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
 
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
-		}
-	}
-	duration := time.Since(time.Unix(0, starttime))
-	ms := duration.Nanoseconds() / int64(time.Millisecond)
-	s.Logger.Trace.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
+	conn.SetWriteDeadline(time.Now().Add(responseWriteTimeout))
 
-	conn.Close()
+	_, err = conn.Write(dataresponse)
+
+	if err != nil {
+		s.Logger.Error.Println("Sending response error: ", err.Error())
+	}
 }
 
-// response error to a client
-func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
+// Error response. First byte is false and then error message
+func (s *NodeServer) buildErrorResponse(err error) []byte {
 	s.Logger.Error.Println("Sending back error message: ", err.Error())
 	s.Logger.Trace.Println("Sending back error message: ", err.Error())
 
 	payload, err := netlib.GobEncode(err.Error())
 
-	if err == nil {
-		dataresponse := append([]byte{0}, payload...)
+	if err != nil {
+		return nil
+	}
 
-		s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
+	return append([]byte{0}, payload...)
+}
 
-		_, err = conn.Write(dataresponse)
+// Returns IP address of other side of a connection
+func getConnectionIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
 
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
+// Returns address of this node that is sent to other nodes. If own host is not set or it is local
+// then the host learned from other nodes is used
+func (s *NodeServer) GetAdvertisedAddress() netlib.NodeAddr {
+	addr := s.NodeAddress
+
+	if addr.HasExternalHost() {
+		return addr
+	}
+
+	s.externalLock.Lock()
+	defer s.externalLock.Unlock()
+
+	if s.externalHost != "" {
+		addr.Host = s.externalHost
+	}
+	return addr
+}
+
+// Remembers our address as a peer sees it. Only nodes we connected to are asked, other side of inbound
+// connection can tell anything. Every peer has one vote, the host is changed when most of recent reports agree.
+// Configured external host is not replaced
+func (s *NodeServer) learnExternalHost(addr netlib.NodeAddr, from netlib.NodeAddr, outbound bool) {
+	if !outbound || s.NodeAddress.HasExternalHost() || !addr.HasExternalHost() {
+		return
+	}
+
+	host := netlib.NormalizeHost(addr.Host)
+	peer := netlib.NormalizeHost(from.Host)
+
+	s.externalLock.Lock()
+	defer s.externalLock.Unlock()
+
+	reports := []externalHostReport{}
+
+	for _, report := range s.externalReports {
+		if report.peer != peer {
+			reports = append(reports, report)
+		}
+	}
+
+	reports = append(reports, externalHostReport{peer, host})
+
+	if len(reports) > externalHostReports {
+		reports = reports[len(reports)-externalHostReports:]
+	}
+
+	s.externalReports = reports
+
+	votes := 0
+
+	for _, report := range reports {
+		if report.host == host {
+			votes++
 		}
 	}
+
+	s.Logger.Trace.Printf("Node %s sees our address as %s. %d of %d agree", from.NodeAddrToString(), host, votes, len(reports))
+
+	if s.externalHost == host || votes < externalHostMinVotes || votes*2 <= len(reports) {
+		return
+	}
+
+	s.externalHost = host
+}
+
+// Address to connect to own server from this machine
+func (s *NodeServer) getLocalAddress() netlib.NodeAddr {
+	addr := netlib.NodeAddr{Host: s.BindHost, Port: s.NodeAddress.Port}
+
+	ip := net.ParseIP(netlib.NormalizeHost(addr.Host))
+
+	if addr.Host == "" || ip != nil && ip.IsUnspecified() {
+		addr.Host = "localhost"
+	}
+	return addr
 }
 
 // Starts a server for node. It listens TPC port and communicates with other nodes and lite clients
@@ -203,7 +482,33 @@ func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
 func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	s.Logger.Trace.Println("Prepare server to start ", s.NodeAddress.NodeAddrToString())
 
-	ln, err := net.Listen(netlib.Protocol, ":"+strconv.Itoa(s.NodeAddress.Port))
+	// identity key is sent in encrypted connections. Other nodes pin it
+	nodeKey, err := netlib.LoadNodeKey(s.DataDir)
+
+	if err != nil {
+		serverStartResult <- err.Error()
+
+		close(s.StopMainConfirmChan)
+		s.Logger.Trace.Println("Fail to load node key ", err.Error())
+		return err
+	}
+
+	s.NodeKey = nodeKey
+	s.Node.NodeClient.NodeKey = nodeKey
+	s.Node.NodeClient.Encrypt = s.Encrypt
+
+	s.Logger.Trace.Println("Node key ", netlib.KeyFingerprint(nodeKey.PublicKey))
+
+	err = checkRPCConfig(s)
+
+	if err != nil {
+		serverStartResult <- err.Error()
+
+		close(s.StopMainConfirmChan)
+		return err
+	}
+
+	ln, err := net.Listen(netlib.Protocol, net.JoinHostPort(s.BindHost, strconv.Itoa(s.NodeAddress.Port)))
 
 	if err != nil {
 		serverStartResult <- err.Error()
@@ -214,9 +519,93 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	}
 	defer ln.Close()
 
+	// keep DB opened while the server works. Requests use it without reopening the file
+	s.Node.DBConn.CloseConnection()
+
+	err = s.Node.DBConn.OpenPersistentConnection("node server")
+
+	if err != nil {
+		serverStartResult <- err.Error()
+
+		close(s.StopMainConfirmChan)
+		s.Logger.Trace.Println("Fail to open DB ", err.Error())
+		return err
+	}
+	defer s.Node.DBConn.ClosePersistentConnection()
+
 	// client will use the address to include it in requests
 	s.Node.NodeClient.SetNodeAddress(s.NodeAddress)
 
+	// messages to other nodes are sent in opened sessions
+	s.Peers = newPeerSessions(s)
+	s.Node.NodeClient.Transport = s.Peers
+
+	defer s.Peers.CloseAll()
+
+	s.Sync = newBlockSync(s)
+
+	s.TxRelay = newTxRelay(s)
+	s.Node.TxAnnouncer = s.TxRelay
+	s.TxRelay.Start()
+
+	defer s.TxRelay.Stop()
+
+	s.Limits = newConnectionLimits(s.MaxConnections)
+
+	s.Bans = newPeerBans(s)
+
+	err = s.Bans.Load()
+
+	if err != nil {
+		s.Logger.Error.Println("Can not load list of banned nodes: ", err.Error())
+	}
+
+	s.Bans.Start()
+
+	defer s.Bans.Stop()
+
+	if s.LanDiscovery {
+		discovery := newLanDiscovery(s)
+
+		err = discovery.Start()
+
+		if err != nil {
+			s.Logger.Error.Println("Can not start LAN discovery: ", err.Error())
+		} else {
+			defer discovery.Stop()
+		}
+	}
+
+	if s.RPCPort > 0 {
+		rpc := newRPCServer(s)
+
+		err = rpc.Start()
+
+		if err != nil {
+			s.Logger.Error.Println("Can not start RPC server: ", err.Error())
+		} else {
+			defer rpc.Stop()
+		}
+	}
+
+	if s.RESTPort > 0 {
+		rest := newRESTServer(s)
+
+		err = rest.Start()
+
+		if err != nil {
+			s.Logger.Error.Println("Can not start REST server: ", err.Error())
+		} else {
+			defer rest.Stop()
+		}
+	}
+
+	stale := s.Node.NodeNet.RemoveStaleNodes()
+
+	if len(stale) > 0 {
+		s.Logger.Trace.Printf("Removed %d stale nodes", len(stale))
+	}
+
 	s.Node.SendVersionToNodes([]netlib.NodeAddr{})
 
 	s.Logger.Trace.Println("Start block bilding routine")
@@ -265,7 +654,15 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 			break
 		}
 
-		go s.handleConnection(conn)
+		limitedconn := s.Limits.Accept(conn)
+
+		if limitedconn == nil {
+			s.Logger.Trace.Printf("Connection from %s is refused. Too many connections", getConnectionIP(conn))
+			conn.Close()
+			continue
+		}
+
+		go s.handleConnection(limitedconn)
 	}
 	return nil
 }
@@ -319,6 +716,26 @@ func (s *NodeServer) BlockBuilder() {
 	}
 }
 
+// Returns node state with progress of loading blocks from other nodes
+func (s *NodeServer) getNodeState(node *nodemanager.Node) (nodeclient.ComGetNodeState, error) {
+	info, err := node.GetNodeState()
+
+	if err != nil {
+		return info, err
+	}
+
+	info.ExpectingBlocksHeight = s.Transit.MaxKnownHeigh
+
+	if s.Sync != nil {
+		info.Syncing, info.SyncHeadersHeight, info.SyncPeers, info.SyncBlocksInFlight = s.Sync.GetProgress()
+
+		if info.SyncHeadersHeight > info.ExpectingBlocksHeight {
+			info.ExpectingBlocksHeight = info.SyncHeadersHeight
+		}
+	}
+	return info, nil
+}
+
 /*
 * Creates clone of a node object. We use this in case if we need separate object
 * for a routine. This prevents conflicts of pointers in different routines
@@ -331,95 +748,118 @@ func (s *NodeServer) CloneNode() *nodemanager.Node {
 	node.DataDir = s.DataDir
 	node.Logger = s.Logger
 	node.MinterAddress = orignode.MinterAddress
+	node.PruneKeep = orignode.PruneKeep
+	node.BanTime = orignode.BanTime
 	// clone DB object
 	ndb := orignode.DBConn.Clone()
 	node.DBConn = &ndb
 
 	node.Init()
 
-	node.NodeClient.SetNodeAddress(s.NodeAddress)
+	node.NodeClient.SetNodeAddress(s.GetAdvertisedAddress())
+	node.NodeClient.NodeKey = s.NodeKey
+	node.NodeClient.Encrypt = s.Encrypt
+
+	if s.Peers != nil {
+		node.NodeClient.Transport = s.Peers
+	}
+
+	if s.TxRelay != nil {
+		node.TxAnnouncer = s.TxRelay
+	}
 
-	node.InitNodes(orignode.NodeNet.Nodes, true) // set list of nodes and skip loading default if this is empty list
+	node.InitNodes(orignode.NodeNet.GetNodes(), true) // set list of nodes and skip loading default if this is empty list
+	node.NodeNet.ShareStats(&orignode.NodeNet)
 
 	return &node
 }
 
 // Reads and parses request from network data
-func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error) {
-	// 1. Read command
-	commandbuffer, err := s.readFromConnection(conn, netlib.CommandLength)
-
-	if err != nil {
-		return "", nil, "", err
-	}
+// Frame header is checked first. Data are not read if the header is wrong or sizes are over limits
+// Header must be received during headerTimeout. Peer sessions wait for next message longer
+func (s *NodeServer) readRequest(conn net.Conn, headerTimeout time.Duration) (netlib.FrameHeader, []byte, string, error) {
+	// 1. Read frame header
+	header := netlib.FrameHeader{}
 
-	command := netlib.BytesToCommand(commandbuffer)
+	conn.SetReadDeadline(time.Now().Add(headerTimeout))
 
-	// 2. Get length of command data
+	defer conn.SetReadDeadline(time.Time{})
 
-	lengthbuffer, err := s.readFromConnection(conn, 4)
+	headerbuffer, err := s.readFromConnection(conn, netlib.FrameHeaderLength)
 
 	if err != nil {
-		return "", nil, "", err
+		return header, nil, "", err
 	}
 
-	var datalength uint32
-	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &datalength)
-
-	// 3. Get length of extra data
-	lengthbuffer, err = s.readFromConnection(conn, 4)
+	header, err = netlib.ParseFrameHeader(headerbuffer)
 
 	if err != nil {
-		return "", nil, "", err
+		return header, nil, "", err
 	}
 
-	var extradatalength uint32
-	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &extradatalength)
-
-	// 4. read command data by length
-	//s.Logger.Trace.Printf("Before read data %d bytes", datalength)
+	// data can be big. it has own deadline
+	conn.SetReadDeadline(time.Now().Add(requestReadTimeout))
 
+	// 2. read command data by length
 	databuffer := []byte{}
 
-	if datalength > 0 {
-		databuffer, err = s.readFromConnection(conn, int(datalength))
+	if header.PayloadLength > 0 {
+		databuffer, err = s.readFromConnection(conn, int(header.PayloadLength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", datalength, err.Error()))
+			return header, nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", header.PayloadLength, err.Error()))
 		}
 	}
 
-	// 5. read extra data by length
+	// 3. read extra data by length
+	extradatabuffer := []byte{}
 
-	authstr := ""
-
-	if extradatalength > 0 {
-		extradatabuffer, err := s.readFromConnection(conn, int(extradatalength))
+	if header.ExtraLength > 0 {
+		extradatabuffer, err = s.readFromConnection(conn, int(header.ExtraLength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", extradatalength, err.Error()))
+			return header, nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", header.ExtraLength, err.Error()))
 		}
+	}
 
-		authstr = netlib.BytesToCommand(extradatabuffer)
+	// 4. all data must be same as was sent
+	err = header.VerifyChecksum(databuffer, extradatabuffer)
+
+	if err != nil {
+		return header, nil, "", err
 	}
 
-	return command, databuffer, authstr, nil
+	return header, databuffer, netlib.BytesToCommand(extradatabuffer), nil
 }
 
-// Read given amount of bytes from connection
+// Size of a piece of data read from connection at once
+const readChunkSize = 64 * 1024
+
+// Read given amount of bytes from connection. Data is read by chunks, so memory is used only for received data
 func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte, error) {
 	buff := new(bytes.Buffer)
 
 	pauses := 0
 
+	chunkSize := readChunkSize
+
+	if countofbytes < chunkSize {
+		chunkSize = countofbytes
+	}
+
+	tmpbuffer := make([]byte, chunkSize)
+
 	for {
+		chunk := tmpbuffer
 
-		tmpbuffer := make([]byte, countofbytes-buff.Len())
+		if countofbytes-buff.Len() < len(chunk) {
+			chunk = tmpbuffer[:countofbytes-buff.Len()]
+		}
 
-		read, err := conn.Read(tmpbuffer)
+		read, err := conn.Read(chunk)
 
 		if read > 0 {
-			buff.Write(tmpbuffer[:read])
+			buff.Write(chunk[:read])
 
 			if buff.Len() == countofbytes {
 				break
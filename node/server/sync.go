@@ -0,0 +1,598 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/node/blockchain"
+	"github.com/gelembjuk/democoin/node/consensus"
+	"github.com/gelembjuk/democoin/node/nodemanager"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Max number of headers in one response
+const syncMaxHeaders = 2000
+
+// Locator has 10 hashes from the top and then one hash for every doubled step. This is enough for any chain
+const syncMaxLocatorSize = 64
+
+// Headers are kept in memory till blocks for them are added. Next headers are requested after this
+const syncMaxPendingHeaders = 20000
+
+// Max number of full blocks in one request
+const syncMaxBodiesRequest = 16
+
+// Blocks are requested only this far from the next block to add. Blocks are added in order,
+// so a slow node must not make us keep too many received blocks in memory
+const syncWindowSize = 256
+
+// Requests to one node at same time
+const syncRequestsPerPeer = 2
+
+// Node is not used in the sync after this number of failed requests
+const syncMaxPeerFailures = 3
+
+// Loads missed blocks from other nodes. Headers are loaded first from one node and checked as a chain.
+// Then full blocks are requested from all nodes that have them and are added in order
+type blockSync struct {
+	S      *NodeServer
+	Logger *utils.LoggerMan
+	lock   *sync.Mutex
+
+	running bool
+	next    *netlib.NodeAddr // node that asked to sync while other sync was running
+
+	// progress. it is shown in node state
+	headersHeight int
+	peers         int
+	inFlight      int
+}
+
+// Block in the sync. Full data is kept only till the block is added
+type syncBlock struct {
+	header    *structures.BlockHeader
+	data      []byte
+	from      netlib.NodeAddr
+	requested bool
+}
+
+// Result of a request of full blocks
+type syncResult struct {
+	addr   netlib.NodeAddr
+	hashes [][]byte
+	blocks [][]byte
+	err    error
+}
+
+func newBlockSync(s *NodeServer) *blockSync {
+	y := &blockSync{}
+	y.S = s
+	y.Logger = s.Logger
+	y.lock = &sync.Mutex{}
+
+	return y
+}
+
+// Starts sync with a node that has more blocks. Returns false if the node has old protocol,
+// blocks must be requested with getblocksup in this case
+func (y *blockSync) Start(addr netlib.NodeAddr) bool {
+	sess := y.S.Peers.GetSession(addr)
+
+	if sess == nil || !sess.canServeSync(0) {
+		return false
+	}
+
+	y.lock.Lock()
+	defer y.lock.Unlock()
+
+	if y.running {
+		// headers from this node will be checked when current sync is done
+		y.next = &addr
+		return true
+	}
+
+	y.running = true
+
+	go y.run(addr)
+
+	return true
+}
+
+// Returns progress of the sync. It is false if there is no sync now
+func (y *blockSync) GetProgress() (bool, int, int, int) {
+	y.lock.Lock()
+	defer y.lock.Unlock()
+
+	return y.running, y.headersHeight, y.peers, y.inFlight
+}
+
+func (y *blockSync) run(source netlib.NodeAddr) {
+	for {
+		y.Logger.Trace.Printf("Sync blocks with %s", source.NodeAddrToString())
+
+		err := y.syncWith(source)
+
+		if err != nil {
+			y.Logger.Trace.Printf("Sync with %s failed: %s", source.NodeAddrToString(), err.Error())
+		}
+
+		y.lock.Lock()
+
+		if y.next == nil {
+			y.running = false
+			y.headersHeight = 0
+			y.peers = 0
+			y.inFlight = 0
+			y.lock.Unlock()
+
+			y.Logger.Trace.Printf("Sync is complete")
+
+			if err == nil && y.S.TxRelay != nil {
+				// now transactions of the node can be checked
+				y.S.TxRelay.RequestMempool(source)
+			}
+			return
+		}
+
+		source = *y.next
+		y.next = nil
+
+		y.lock.Unlock()
+	}
+}
+
+// Loads headers and then blocks for them till the node has no more headers
+func (y *blockSync) syncWith(source netlib.NodeAddr) error {
+	node := y.S.CloneNode()
+
+	var lastBlock *structures.Block
+
+	for {
+		headers, complete, err := y.loadHeaders(node, source)
+
+		if err != nil {
+			return err
+		}
+
+		if len(headers) > 0 {
+			block, err := y.loadBlocks(node, source, headers)
+
+			if block != nil {
+				lastBlock = block
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if complete {
+			break
+		}
+	}
+
+	if lastBlock != nil {
+		// nodes that are connected to us don't know about new blocks yet
+		node.SendBlockToAll(lastBlock, source)
+	}
+	return nil
+}
+
+// Requests headers after our top block and checks they are a chain with correct PoW.
+// Second value is false if the node has more headers, they are requested after blocks for these are added
+func (y *blockSync) loadHeaders(node *nodemanager.Node, source netlib.NodeAddr) ([]*structures.BlockHeader, bool, error) {
+	var locator [][]byte
+
+	err := y.withDB(node, func() error {
+		var err error
+		locator, err = node.NodeBC.GetBCManager().GetBlockLocator()
+		return err
+	})
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	headers := []*structures.BlockHeader{}
+
+	for {
+		list, err := y.requestHeaders(node, source, locator)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if len(list) == 0 {
+			return headers, true, nil
+		}
+
+		err = y.checkHeaders(node, source, headers, list)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		headers = append(headers, list...)
+
+		y.lock.Lock()
+		y.headersHeight = list[len(list)-1].Height
+		y.lock.Unlock()
+
+		y.Logger.Trace.Printf("Received %d headers from %s. Last height %d", len(list), source.NodeAddrToString(), list[len(list)-1].Height)
+
+		if len(list) < syncMaxHeaders {
+			return headers, true, nil
+		}
+
+		if len(headers) >= syncMaxPendingHeaders {
+			return headers, false, nil
+		}
+
+		locator = [][]byte{list[len(list)-1].Hash}
+	}
+}
+
+// Requests headers from a node and parses them
+func (y *blockSync) requestHeaders(node *nodemanager.Node, source netlib.NodeAddr, locator [][]byte) ([]*structures.BlockHeader, error) {
+	data, err := node.NodeClient.SendGetHeaders(source, locator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > syncMaxHeaders {
+		y.misbehaving(source, banScoreMalformedData, "Too many headers")
+		return nil, errors.New(fmt.Sprintf("Too many headers from %s", source.NodeAddrToString()))
+	}
+
+	list := []*structures.BlockHeader{}
+
+	for _, hdata := range data {
+		header := &structures.BlockHeader{}
+
+		err := header.DeserializeHeader(hdata)
+
+		if err != nil {
+			y.misbehaving(source, banScoreMalformedData, err.Error())
+			return nil, err
+		}
+		list = append(list, header)
+	}
+	return list, nil
+}
+
+// Checks that new headers continue previous headers or a block from our DB.
+// Every header must have correct PoW
+func (y *blockSync) checkHeaders(node *nodemanager.Node, source netlib.NodeAddr, headers []*structures.BlockHeader, list []*structures.BlockHeader) error {
+	var prevHash []byte
+	var prevHeight int
+
+	if len(headers) > 0 {
+		prevHash = headers[len(headers)-1].Hash
+		prevHeight = headers[len(headers)-1].Height
+	} else {
+		err := y.withDB(node, func() error {
+			block, err := node.NodeBC.GetBlock(list[0].PrevBlockHash)
+
+			if err != nil {
+				return errors.New(fmt.Sprintf("Headers don't connect to our chain: %s", err.Error()))
+			}
+
+			prevHash = block.Hash
+			prevHeight = block.Height
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, header := range list {
+		if !bytes.Equal(header.PrevBlockHash, prevHash) || header.Height != prevHeight+1 {
+			y.misbehaving(source, banScoreInvalidBlock, "Headers are not a chain")
+			return errors.New(fmt.Sprintf("Header %x doesn't follow %x", header.Hash, prevHash))
+		}
+
+		valid, err := consensus.ValidateHeader(header)
+
+		if err != nil {
+			return err
+		}
+
+		if !valid {
+			y.misbehaving(source, banScoreInvalidPoW, "Wrong PoW of a header")
+			return errors.New(fmt.Sprintf("Header %x has wrong PoW", header.Hash))
+		}
+
+		prevHash = header.Hash
+		prevHeight = header.Height
+	}
+	return nil
+}
+
+// Loads full blocks for headers from all nodes that have them. Every node gets few requests at same time.
+// Blocks that were not returned are requested again from other node. Blocks are added in order of headers.
+// Returns last added block
+func (y *blockSync) loadBlocks(node *nodemanager.Node, source netlib.NodeAddr, headers []*structures.BlockHeader) (*structures.Block, error) {
+	blocks := []*syncBlock{}
+	index := map[string]int{}
+
+	for i, header := range headers {
+		blocks = append(blocks, &syncBlock{header: header})
+		index[hex.EncodeToString(header.Hash)] = i
+	}
+
+	// blocks before the first header are in our DB
+	height := headers[0].Height - 1
+
+	failures := map[string]int{}
+	requests := map[string]int{}
+	pending := 0
+
+	results := make(chan syncResult)
+	done := make(chan struct{})
+
+	// requests that are still running when we return must not wait for us
+	defer close(done)
+
+	var lastBlock *structures.Block
+
+	next := 0
+
+	for next < len(blocks) {
+		peers := y.getPeers(source, failures, height)
+
+		for _, addr := range peers {
+			key := getSessionKey(addr)
+
+			for requests[key] < syncRequestsPerPeer {
+				hashes := y.pickBlocks(blocks, next)
+
+				if len(hashes) == 0 {
+					break
+				}
+
+				requests[key]++
+				pending++
+
+				go func(addr netlib.NodeAddr, hashes [][]byte) {
+					data, err := node.NodeClient.SendGetBodies(addr, hashes)
+
+					select {
+					case results <- syncResult{addr, hashes, data, err}:
+					case <-done:
+					}
+				}(addr, hashes)
+			}
+		}
+
+		y.setProgress(len(peers), blocks)
+
+		if pending == 0 {
+			return lastBlock, errors.New("No nodes to load blocks from")
+		}
+
+		result := <-results
+
+		pending--
+		requests[getSessionKey(result.addr)]--
+
+		y.receiveBlocks(result, blocks, index, failures)
+
+		// add all blocks that are ready in order. next blocks will be requested on next loop
+		for next < len(blocks) && blocks[next].data != nil {
+			block, err := y.addBlock(node, blocks[next])
+
+			if err != nil {
+				return lastBlock, err
+			}
+
+			if block != nil {
+				lastBlock = block
+			}
+
+			blocks[next].data = nil
+			next++
+		}
+	}
+
+	y.setProgress(0, nil)
+
+	return lastBlock, nil
+}
+
+// Returns hashes of blocks to request next. Only blocks in the window after the next block to add are requested
+func (y *blockSync) pickBlocks(blocks []*syncBlock, next int) [][]byte {
+	hashes := [][]byte{}
+
+	for i := next; i < len(blocks) && i < next+syncWindowSize; i++ {
+		if blocks[i].requested || blocks[i].data != nil {
+			continue
+		}
+
+		blocks[i].requested = true
+		hashes = append(hashes, blocks[i].header.Hash)
+
+		if len(hashes) >= syncMaxBodiesRequest {
+			break
+		}
+	}
+	return hashes
+}
+
+// Checks received blocks are same as headers. Blocks that were not received will be requested again
+func (y *blockSync) receiveBlocks(result syncResult, blocks []*syncBlock, index map[string]int, failures map[string]int) {
+	key := getSessionKey(result.addr)
+
+	defer func() {
+		for _, hash := range result.hashes {
+			if i, ok := index[hex.EncodeToString(hash)]; ok && blocks[i].data == nil {
+				blocks[i].requested = false
+			}
+		}
+	}()
+
+	if result.err != nil {
+		y.Logger.Trace.Printf("Blocks request to %s failed: %s", result.addr.NodeAddrToString(), result.err.Error())
+		failures[key]++
+		return
+	}
+
+	if len(result.blocks) == 0 {
+		// node doesn't have these blocks
+		failures[key]++
+		return
+	}
+
+	for _, data := range result.blocks {
+		block := &structures.Block{}
+
+		err := block.DeserializeBlock(data)
+
+		if err != nil {
+			y.misbehaving(result.addr, banScoreMalformedData, err.Error())
+			failures[key] = syncMaxPeerFailures
+			return
+		}
+
+		i, ok := index[hex.EncodeToString(block.Hash)]
+
+		if !ok || blocks[i].data != nil {
+			// we didn't request it or it is received from other node already
+			continue
+		}
+
+		header, err := block.GetHeader()
+
+		if err != nil || !sameHeader(header, blocks[i].header) {
+			y.misbehaving(result.addr, banScoreInvalidBlock, "Block is different from the header")
+			failures[key] = syncMaxPeerFailures
+			return
+		}
+
+		blocks[i].data = data
+		blocks[i].from = result.addr
+	}
+}
+
+// Adds a block to the DB. Returns nil block if it was in the DB already
+func (y *blockSync) addBlock(node *nodemanager.Node, sb *syncBlock) (*structures.Block, error) {
+	var block *structures.Block
+	var blockstate int
+	var addstate uint
+
+	err := y.withDB(node, func() error {
+		// check of the block state and adding are done in one write transaction
+		return node.DBConn.Update(func() error {
+			var err error
+			blockstate, addstate, block, err = node.ReceivedFullBlockFromOtherNode(sb.data)
+			return err
+		})
+	})
+
+	if err != nil {
+		if score := blockErrorBanScore(err); score > 0 {
+			y.misbehaving(sb.from, score, "Invalid block: "+err.Error())
+		}
+		return nil, err
+	}
+
+	if addstate == blockchain.BCBAddState_addedToParallelTop {
+		// maybe some transactiosn become unapproved now. try to make new block from them on top of new chain
+		y.S.TryToMakeNewBlock([]byte{1})
+	}
+
+	if blockstate != 0 {
+		return nil, nil
+	}
+	return block, nil
+}
+
+// Returns nodes to load blocks from. These are nodes with new protocol that have full blocks after our height
+// and have more blocks than we have. The node that sent headers is used always
+func (y *blockSync) getPeers(source netlib.NodeAddr, failures map[string]int, height int) []netlib.NodeAddr {
+	peers := []netlib.NodeAddr{}
+	used := map[string]bool{}
+
+	if failures[getSessionKey(source)] < syncMaxPeerFailures {
+		peers = append(peers, source)
+		used[getSessionKey(source)] = true
+	}
+
+	for _, sess := range y.S.Peers.GetSessions() {
+		key := getSessionKey(sess.Addr)
+
+		if used[key] || failures[key] >= syncMaxPeerFailures {
+			continue
+		}
+
+		if !sess.canServeSync(height) || sess.getBestHeight() <= height {
+			continue
+		}
+
+		peers = append(peers, sess.Addr)
+		used[key] = true
+	}
+	return peers
+}
+
+func (y *blockSync) setProgress(peers int, blocks []*syncBlock) {
+	inFlight := 0
+
+	for _, b := range blocks {
+		if b.requested && b.data == nil {
+			inFlight++
+		}
+	}
+
+	y.lock.Lock()
+	defer y.lock.Unlock()
+
+	y.peers = peers
+	y.inFlight = inFlight
+}
+
+// Increases ban score of a node. Sessions know IP of nodes, it is used if there is a session
+func (y *blockSync) misbehaving(addr netlib.NodeAddr, score int, reason string) {
+	if y.S.Bans == nil {
+		return
+	}
+
+	host := netlib.NormalizeHost(addr.Host)
+
+	if sess := y.S.Peers.GetSession(addr); sess != nil {
+		host = sess.IP
+	}
+
+	y.S.Bans.Misbehaving(host, score, reason)
+}
+
+// Opens DB connection for a routine of the sync
+func (y *blockSync) withDB(node *nodemanager.Node, f func() error) error {
+	err := node.DBConn.OpenConnection("Sync", utils.RandString(5))
+
+	if err != nil {
+		return err
+	}
+
+	defer node.DBConn.CloseConnection()
+
+	return f()
+}
+
+// Checks that header of a received block is same as the header received before
+func sameHeader(a *structures.BlockHeader, b *structures.BlockHeader) bool {
+	return a.Timestamp == b.Timestamp &&
+		a.Nonce == b.Nonce &&
+		a.Height == b.Height &&
+		bytes.Equal(a.Hash, b.Hash) &&
+		bytes.Equal(a.PrevBlockHash, b.PrevBlockHash) &&
+		bytes.Equal(a.TxHash, b.TxHash)
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	netlib "github.com/gelembjuk/democoin/lib/net"
+	"github.com/gelembjuk/democoin/lib/nodeclient"
+	"github.com/gelembjuk/democoin/lib/utils"
+)
+
+// Average time between announcements of transactions to one node. Real time is random,
+// so other nodes can not find where a transaction was created by time of announcements
+const txRelayInterval = 2 * time.Second
+
+// How often queues are checked
+const txRelayTick = 100 * time.Millisecond
+
+// Max number of transactions in one inv. It is also the limit for received inv
+const txRelayMaxItems = 1000
+
+// Number of transactions remembered as known for one node
+const txRelayKnownLimit = 5000
+
+// Max number of transactions IDs in response to mempool request
+const mempoolMaxItems = 50000
+
+// Announces transactions to other nodes in batches. Every node has own queue that is sent on randomized timer.
+// Transactions that a node knows (it announced or sent them to us, or we announced them) are not announced to it again
+type txRelay struct {
+	S      *NodeServer
+	Logger *utils.LoggerMan
+	lock   *sync.Mutex
+	peers  map[string]*txRelayPeer
+	stop   chan struct{}
+}
+
+type txRelayPeer struct {
+	addr       netlib.NodeAddr
+	queue      [][]byte
+	known      map[string]bool
+	knownOrder []string // oldest first. oldest are forgotten when the limit is reached
+	nextSend   time.Time
+	mempool    bool // transactions of the node pool were requested
+}
+
+func newTxRelay(s *NodeServer) *txRelay {
+	r := &txRelay{}
+	r.S = s
+	r.Logger = s.Logger
+	r.lock = &sync.Mutex{}
+	r.peers = map[string]*txRelayPeer{}
+	r.stop = make(chan struct{})
+
+	return r
+}
+
+// Starts the routine that sends queues
+func (r *txRelay) Start() {
+	go r.loop()
+}
+
+func (r *txRelay) Stop() {
+	close(r.stop)
+}
+
+// Adds a transaction to queues of nodes that don't know it yet
+func (r *txRelay) AnnounceTransaction(txID []byte, nodes []netlib.NodeAddr) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	id := hex.EncodeToString(txID)
+
+	for _, addr := range nodes {
+		peer := r.getPeer(addr)
+
+		if peer.known[id] {
+			continue
+		}
+
+		peer.addKnown(id)
+		peer.queue = append(peer.queue, txID)
+
+		if len(peer.queue) == 1 {
+			peer.nextSend = time.Now().Add(randomRelayInterval())
+		}
+	}
+}
+
+// Remembers that a node knows a transaction. It is not announced to the node.
+// Only nodes with opened session are remembered, data of a node is forgotten when its session is closed
+func (r *txRelay) SetKnown(addr netlib.NodeAddr, txID []byte) {
+	if r.S.Peers == nil || r.S.Peers.GetSession(addr) == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.getPeer(addr).addKnown(hex.EncodeToString(txID))
+}
+
+// Removes all data about a node. It is done when a session with the node is closed,
+// the node can be restarted with empty pool
+func (r *txRelay) Forget(addr netlib.NodeAddr) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.peers, getSessionKey(addr))
+}
+
+// Requests IDs of transactions in the pool of other node and then missed transactions.
+// It is done once in a session. It is skipped if the node has more blocks, it is done after sync
+func (r *txRelay) RequestMempool(addr netlib.NodeAddr) {
+	sess := r.S.Peers.GetSession(addr)
+
+	if sess == nil {
+		return
+	}
+
+	sess.lock.Lock()
+	version := sess.Version
+	bestHeight := sess.BestHeight
+	sess.lock.Unlock()
+
+	if version < netlib.MempoolVersion {
+		return
+	}
+
+	r.lock.Lock()
+
+	peer := r.getPeer(addr)
+
+	if peer.mempool {
+		r.lock.Unlock()
+		return
+	}
+	peer.mempool = true
+
+	r.lock.Unlock()
+
+	go func() {
+		err := r.loadMempool(addr, bestHeight)
+
+		if err != nil {
+			r.Logger.Trace.Printf("Mempool of %s is not loaded: %s", addr.NodeAddrToString(), err.Error())
+
+			// can be requested again
+			r.lock.Lock()
+			r.getPeer(addr).mempool = false
+			r.lock.Unlock()
+		}
+	}()
+}
+
+func (r *txRelay) loadMempool(addr netlib.NodeAddr, bestHeight int) error {
+	node := r.S.CloneNode()
+
+	err := node.DBConn.OpenConnection("Mempool", utils.RandString(5))
+
+	if err != nil {
+		return err
+	}
+
+	_, height, err := node.NodeBC.GetBCManager().GetState()
+
+	node.DBConn.CloseConnection()
+
+	if err != nil {
+		return err
+	}
+
+	if height < bestHeight {
+		return errors.New(fmt.Sprintf("The node has more blocks: %d, our height %d", bestHeight, height))
+	}
+
+	txids, err := node.NodeClient.SendMempool(addr)
+
+	if err != nil {
+		return err
+	}
+
+	if len(txids) > mempoolMaxItems {
+		return errors.New(fmt.Sprintf("Too many transactions in mempool: %d", len(txids)))
+	}
+
+	missed := [][]byte{}
+
+	err = node.DBConn.OpenConnection("Mempool", utils.RandString(5))
+
+	if err != nil {
+		return err
+	}
+
+	// all checks see same state of the DB
+	err = node.DBConn.View(func() error {
+		for _, txID := range txids {
+			r.SetKnown(addr, txID)
+
+			tx, err := node.GetTransactionsManager().GetIfExists(txID)
+
+			if err != nil {
+				return err
+			}
+
+			if tx == nil {
+				missed = append(missed, txID)
+			}
+		}
+		return nil
+	})
+
+	node.DBConn.CloseConnection()
+
+	if err != nil {
+		return err
+	}
+
+	r.Logger.Trace.Printf("Mempool of %s has %d transactions, %d are missed", addr.NodeAddrToString(), len(txids), len(missed))
+
+	// received transactions are checked as any new transaction
+	for _, txID := range missed {
+		node.NodeClient.SendGetData(addr, "tx", txID)
+	}
+
+	return nil
+}
+
+// Returns IDs of transactions in our pool. Other node requests missed ones with getdata
+func (s *NodeServerRequest) handleMempool() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComMempool
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		s.misbehaving(banScoreMalformedData, err.Error())
+		return err
+	}
+
+	txs, err := s.Node.GetTransactionsManager().GetUnapprovedTransactions()
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComMempoolData{}
+	result.Transactions = [][]byte{}
+
+	for _, tx := range txs {
+		if len(result.Transactions) >= mempoolMaxItems {
+			break
+		}
+		result.Transactions = append(result.Transactions, tx.ID)
+
+		// the node will know all of them
+		s.setTransactionKnown(tx.ID)
+	}
+
+	s.Logger.Trace.Printf("Return %d transactions IDs from the pool", len(result.Transactions))
+
+	s.Response, err = netlib.GobEncode(result)
+
+	return err
+}
+
+func (r *txRelay) loop() {
+	ticker := time.NewTicker(txRelayTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+// Sends queues that are due. Data are sent without the lock, sending can open new session
+func (r *txRelay) flush() {
+	now := time.Now()
+
+	type batch struct {
+		addr  netlib.NodeAddr
+		items [][]byte
+	}
+
+	batches := []batch{}
+
+	r.lock.Lock()
+
+	for _, peer := range r.peers {
+		if len(peer.queue) == 0 || peer.nextSend.After(now) {
+			continue
+		}
+
+		items := peer.queue
+
+		if len(items) > txRelayMaxItems {
+			items = items[:txRelayMaxItems]
+		}
+
+		peer.queue = peer.queue[len(items):]
+		peer.nextSend = now.Add(randomRelayInterval())
+
+		batches = append(batches, batch{peer.addr, items})
+	}
+
+	r.lock.Unlock()
+
+	for _, b := range batches {
+		r.Logger.Trace.Printf("Announce %d transactions to %s", len(b.items), b.addr.NodeAddrToString())
+
+		err := r.S.Node.NodeClient.SendInv(b.addr, "tx", b.items)
+
+		if err != nil {
+			r.Logger.Trace.Printf("Announce to %s failed: %s", b.addr.NodeAddrToString(), err.Error())
+		}
+	}
+}
+
+func (r *txRelay) getPeer(addr netlib.NodeAddr) *txRelayPeer {
+	key := getSessionKey(addr)
+
+	peer, ok := r.peers[key]
+
+	if !ok {
+		peer = &txRelayPeer{}
+		peer.addr = addr
+		peer.queue = [][]byte{}
+		peer.known = map[string]bool{}
+		peer.knownOrder = []string{}
+
+		r.peers[key] = peer
+	}
+	return peer
+}
+
+func (p *txRelayPeer) addKnown(id string) {
+	if p.known[id] {
+		return
+	}
+
+	p.known[id] = true
+	p.knownOrder = append(p.knownOrder, id)
+
+	if len(p.knownOrder) > txRelayKnownLimit {
+		delete(p.known, p.knownOrder[0])
+		p.knownOrder = p.knownOrder[1:]
+	}
+}
+
+// Random interval with average txRelayInterval
+func randomRelayInterval() time.Duration {
+	return time.Duration(rand.Int63n(int64(2 * txRelayInterval)))
+}
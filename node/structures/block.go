@@ -2,12 +2,18 @@ package structures
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/gelembjuk/democoin/lib/utils"
 )
 
+// Length of short IDs of transactions in compact blocks
+const ShortTransactionIDLength = 6
+
 // Block represents a block in the blockchain
 type Block struct {
 	Timestamp     int64
@@ -16,6 +22,7 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	TxHash        []byte // hash of transactions of pruned block. Full block has transactions to calculate it
 }
 
 // short info about a block. to exchange over network
@@ -25,6 +32,17 @@ type BlockShort struct {
 	Height        int
 }
 
+// header of a block. It has hash of transactions instead of them, PoW of a block can be checked with it.
+// Nodes exchange headers during sync before full blocks are loaded
+type BlockHeader struct {
+	Timestamp     int64
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+	TxHash        []byte
+}
+
 // simpler representation of a block. transactions are presented as strings
 type BlockSimpler struct {
 	Timestamp     int64
@@ -98,6 +116,81 @@ func (b *Block) GetShortCopy() *BlockShort {
 	return &bs
 }
 
+// Returns header of a block. Pruned block has the hash of transactions saved when it was pruned
+func (b *Block) GetHeader() (*BlockHeader, error) {
+	txshash := b.TxHash
+
+	if !b.IsPruned() {
+		var err error
+		txshash, err = b.HashTransactions()
+
+		if err != nil {
+			return nil, err
+		}
+	} else if len(txshash) == 0 {
+		return nil, errors.New(fmt.Sprintf("Pruned block %x has no hash of transactions", b.Hash))
+	}
+
+	h := BlockHeader{}
+	h.Timestamp = b.Timestamp
+	h.PrevBlockHash = b.PrevBlockHash[:]
+	h.Hash = b.Hash[:]
+	h.Nonce = b.Nonce
+	h.Height = b.Height
+	h.TxHash = txshash
+
+	return &h, nil
+}
+
+// Returns pruned block with data of the header. PoW of such block can be checked
+func (h *BlockHeader) GetPrunedBlock() *Block {
+	b := Block{}
+	b.Timestamp = h.Timestamp
+	b.Transactions = []*Transaction{}
+	b.PrevBlockHash = h.PrevBlockHash[:]
+	b.Hash = h.Hash[:]
+	b.Nonce = h.Nonce
+	b.Height = h.Height
+	b.TxHash = h.TxHash[:]
+
+	return &b
+}
+
+// Serialise BlockHeader to bytes
+func (h *BlockHeader) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+
+	err := encoder.Encode(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize BlockHeader from bytes
+func (h *BlockHeader) DeserializeHeader(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(h)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Short ID of a transaction in a compact block. Hash of the block is a salt, so transactions with
+// same short ID can not be prepared before the block is made
+func ShortTransactionID(blockHash []byte, txID []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write(blockHash)
+	hasher.Write(txID)
+
+	return hasher.Sum(nil)[:ShortTransactionIDLength]
+}
+
 // Returns simpler copy of a block. This is the version for easy print
 // TODO . not sure we really need this
 func (b *Block) GetSimpler() *BlockSimpler {
@@ -114,6 +207,24 @@ func (b *Block) GetSimpler() *BlockSimpler {
 	return &Block
 }
 
+// Pruned block has only header. Transactions are deleted to save space
+// Every full block has at least coinbase transaction
+func (b *Block) IsPruned() bool {
+	return len(b.Transactions) == 0
+}
+
+// Returns copy of a block without transactions. This is how pruned block is stored
+// Hash of transactions is kept to check PoW of the block
+func (b *Block) GetHeaderCopy() (*Block, error) {
+	h, err := b.GetHeader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h.GetPrunedBlock(), nil
+}
+
 // Creates copy of a block
 func (b *Block) Copy() *Block {
 	bc := Block{}
@@ -135,6 +246,11 @@ func (b *Block) Copy() *Block {
 	bc.Nonce = b.Nonce
 	bc.Height = b.Height
 
+	if len(b.TxHash) > 0 {
+		bc.TxHash = make([]byte, len(b.TxHash))
+		copy(bc.TxHash, b.TxHash)
+	}
+
 	for _, t := range b.Transactions {
 		tc, _ := t.Copy()
 		bc.Transactions = append(bc.Transactions, &tc)
@@ -7,6 +7,7 @@ import (
 )
 
 const TXVerifyErrorNoInput = "noinput"
+const TXVerifyErrorInvalid = "invalid" // signatures or amounts are wrong
 const TXNotFoundErrorUnspent = "inunspent"
 
 type TXVerifyError struct {
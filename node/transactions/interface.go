@@ -9,6 +9,7 @@ import (
 
 type UnApprovedTransactionCallbackInterface func(txhash, txstr string) error
 type UnspentTransactionOutputCallbackInterface func(fromaddr string, value float64, txID []byte, output int, isbase bool) error
+type UnspentTransactionCallbackInterface func(txID []byte, outputs []structures.TXOutputIndependent) error
 
 type TransactionsManagerInterface interface {
 	GetAddressBalance(address string) (wallet.WalletBalance, error)
@@ -17,12 +18,18 @@ type TransactionsManagerInterface interface {
 	GetUnapprovedTransactionsForNewBlock(number int) ([]*structures.Transaction, error)
 	GetIfExists(txid []byte) (*structures.Transaction, error)
 	GetIfUnapprovedExists(txid []byte) (*structures.Transaction, error)
+	GetUnapprovedTransactions() ([]*structures.Transaction, error)
 
 	VerifyTransaction(tx *structures.Transaction, prevtxs []*structures.Transaction, tip []byte) (bool, error)
 
 	ForEachUnspentOutput(address string, callback UnspentTransactionOutputCallbackInterface) error
 	ForEachUnapprovedTransaction(callback UnApprovedTransactionCallbackInterface) (int, error)
 
+	// to make and load snapshots of the UTXO set
+	GetUnspentTransactionsCount() (int, error)
+	ForEachUnspentTransaction(callback UnspentTransactionCallbackInterface) error
+	AddUnspentTransaction(txID []byte, outputs []structures.TXOutputIndependent) error
+
 	// Create transaction methods
 	CreateTransaction(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount float64) (*structures.Transaction, error)
 	ReceivedNewTransaction(tx *structures.Transaction) error
@@ -37,6 +44,11 @@ type TransactionsManagerInterface interface {
 	BlockAddedToPrimaryChain(block *structures.Block) error
 	// block was in primary chain and now is not
 	BlockRemovedFromPrimaryChain(block *structures.Block) error
+	// block body is going to be deleted. only header is kept
+	BlockPruned(block *structures.Block) error
+
+	// check index and unspent outputs records for a block of primary chain. optionally fix problems
+	VerifyBlockCaches(block *structures.Block, topHash []byte, repair bool) ([]string, error)
 
 	CancelTransaction(txID []byte) error
 	ReindexData() (map[string]int, error)
@@ -93,6 +93,16 @@ func (n *txManager) GetUnapprovedCount() (int, error) {
 	return n.getUnapprovedTransactionsManager().GetCount()
 }
 
+// return all transactions from pool. Oldest first
+func (n *txManager) GetUnapprovedTransactions() ([]*structures.Transaction, error) {
+	count, err := n.GetUnapprovedCount()
+
+	if err != nil || count == 0 {
+		return []*structures.Transaction{}, err
+	}
+	return n.getUnapprovedTransactionsManager().GetTransactions(count)
+}
+
 // return count of unspent outputs
 func (n *txManager) GetUnspentCount() (int, error) {
 	return n.getUnspentOutputsManager().CountUnspentOutputs()
@@ -202,7 +212,8 @@ func (n *txManager) VerifyTransaction(tx *structures.Transaction, prevtxs []*str
 	err = tx.Verify(inputTXs)
 
 	if err != nil {
-		return false, err
+		// transaction breaks the rules
+		return false, NewTXVerifyError(err.Error(), TXVerifyErrorInvalid, tx.ID)
 	}
 
 	return true, nil
@@ -224,13 +235,17 @@ func (n *txManager) CleanUnapprovedCache() error {
 }
 
 // to execute when new block added . the block must not be on top
+// Errors are returned, so a caller can cancel a DB transaction where the block is added
 func (n *txManager) BlockAdded(block *structures.Block, ontopofchain bool) error {
 	// update caches
-	n.getIndexManager().BlockAdded(block)
+	err := n.getIndexManager().BlockAdded(block)
+
+	if err != nil {
+		return err
+	}
 
 	if ontopofchain {
-		n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
-		n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
+		return n.BlockAddedToPrimaryChain(block)
 	}
 
 	return nil
@@ -238,24 +253,39 @@ func (n *txManager) BlockAdded(block *structures.Block, ontopofchain bool) error
 
 // Block was removed from the top of primary blockchain branch
 func (n *txManager) BlockRemoved(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
-	n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
-	n.getIndexManager().BlockRemoved(block)
-	return nil
+	err := n.BlockRemovedFromPrimaryChain(block)
+
+	if err != nil {
+		return err
+	}
+
+	return n.getIndexManager().BlockRemoved(block)
 }
 
 // block is now added to primary chain. it existed in DB before
 func (n *txManager) BlockAddedToPrimaryChain(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
-	n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
-	return nil
+	err := n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
+
+	if err != nil {
+		return err
+	}
+
+	return n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
 }
 
 // block is removed from primary chain. it continued to be in DB on side branch
 func (n *txManager) BlockRemovedFromPrimaryChain(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
-	n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
-	return nil
+	if block.IsPruned() {
+		return errors.New(fmt.Sprintf("Block %x is pruned and can not be removed from primary chain", block.Hash))
+	}
+
+	err := n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
+
+	if err != nil {
+		return err
+	}
+
+	return n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
 }
 
 // Send amount of money if a node is not running.
@@ -525,7 +555,8 @@ func (n *txManager) verifyTransactionQuick(tx *structures.Transaction) (bool, er
 	err = tx.Verify(inputTXs)
 
 	if err != nil {
-		return false, err
+		// transaction breaks the rules
+		return false, NewTXVerifyError(err.Error(), TXVerifyErrorInvalid, tx.ID)
 	}
 	return true, nil
 }
@@ -575,6 +606,23 @@ func (n *txManager) getInputTransactionsState(tx *structures.Transaction,
 		if txBockHash == nil {
 			//n.Logger.Trace.Printf("Not found TX")
 			prevTX = nil
+
+			if len(txBockHashes) == 0 {
+				// the TX can be in pruned block. then only unspent outputs are known
+				prevTX, err = n.getUnspentOutputsManager().getTransactionFromUnspent(vin.Txid)
+
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if prevTX != nil {
+					if vin.Vout >= len(prevTX.Vout) || prevTX.Vout[vin.Vout].PubKeyHash == nil {
+						return nil, nil, errors.New("Transaction input was already spent before")
+					}
+					prevTXs[vind] = prevTX
+					continue
+				}
+			}
 		} else {
 
 			// if block is in this chain
@@ -0,0 +1,170 @@
+package transactions
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gelembjuk/democoin/node/blockchain"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Block body is going to be deleted. Transactions of the block are removed from index.
+// Unspent outputs of the block stay in the UTXO set
+func (n *txManager) BlockPruned(block *structures.Block) error {
+	return n.getIndexManager().BlockPruned(block)
+}
+
+// Remove links of block transactions to the block and records where outputs of these transactions were spent
+func (ti *transactionsIndex) BlockPruned(block *structures.Block) error {
+	txdb, err := ti.DB.GetTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		hashes, err := ti.GetTranactionBlocks(tx.ID)
+
+		if err != nil {
+			return err
+		}
+
+		newHashes := [][]byte{}
+
+		for _, hash := range hashes {
+			if bytes.Compare(hash, block.Hash) != 0 {
+				newHashes = append(newHashes, hash)
+			}
+		}
+
+		if len(newHashes) > 0 {
+			blocksHashes, err := ti.SerializeHashes(newHashes)
+
+			if err != nil {
+				return err
+			}
+
+			err = txdb.PutTXToBlockLink(tx.ID, blocksHashes)
+
+			if err != nil {
+				return err
+			}
+		} else {
+			err = txdb.DeleteTXToBlockLink(tx.ID)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		err = txdb.DeleteTXSpentData(tx.ID)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks if a TX was in a pruned block. Such TX is not linked to any block but the chain has pruned blocks
+func (ti *transactionsIndex) isTransactionPruned(txID []byte) (bool, error) {
+	hashes, err := ti.GetTranactionBlocks(txID)
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(hashes) > 0 {
+		return false, nil
+	}
+
+	bcdb, err := ti.DB.GetBlockchainObject()
+
+	if err != nil {
+		return false, err
+	}
+
+	prunedHash, err := bcdb.GetLastPrunedHash()
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(prunedHash) > 0, nil
+}
+
+// Builds TX from its unspent outputs. It is used when a block with the TX is pruned.
+// Such TX has only ID and unspent outputs, it is enough to verify and sign inputs that use it.
+// Returns nil if there are no unspent outputs of this TX
+func (u unspentTransactions) getTransactionFromUnspent(txID []byte) (*structures.Transaction, error) {
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	txData, err := uodb.GetDataForTransaction(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if txData == nil {
+		return nil, nil
+	}
+
+	outs, err := u.deserializeOutputs(txData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx := structures.Transaction{}
+	tx.ID = txID[:]
+	tx.Vout = []structures.TXOutput{}
+
+	for _, out := range outs {
+		for len(tx.Vout) <= out.OIndex {
+			tx.Vout = append(tx.Vout, structures.TXOutput{})
+		}
+		tx.Vout[out.OIndex] = structures.TXOutput{Value: out.Value, PubKeyHash: out.DestPubKeyHash}
+	}
+
+	return &tx, nil
+}
+
+// Returns TX from a block. If the block is pruned then TX is built from unspent outputs
+func (u unspentTransactions) getTransactionFromBlock(txID []byte, blockHash []byte) (*structures.Transaction, error) {
+	bcMan, err := blockchain.NewBlockchainManager(u.DB, u.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := bcMan.GetBlock(blockHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !block.IsPruned() {
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, txID) == 0 {
+				return tx, nil
+			}
+		}
+		return nil, errors.New("Transaction is not found")
+	}
+
+	tx, err := u.getTransactionFromUnspent(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return nil, errors.New("Transaction is not found in unspent outputs of pruned block")
+	}
+
+	return tx, nil
+}
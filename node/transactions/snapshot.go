@@ -0,0 +1,51 @@
+package transactions
+
+import (
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Returns number of transactions in the UTXO set
+func (n *txManager) GetUnspentTransactionsCount() (int, error) {
+	return n.getUnspentOutputsManager().CountTransactions()
+}
+
+// Executes callback for every transaction in the UTXO set. Transactions are ordered by ID
+func (n *txManager) ForEachUnspentTransaction(callback UnspentTransactionCallbackInterface) error {
+	u := n.getUnspentOutputsManager()
+
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return err
+	}
+
+	return uodb.ForEach(func(txID, txData []byte) error {
+		outs, err := u.deserializeOutputs(txData)
+
+		if err != nil {
+			return err
+		}
+
+		return callback(utils.CopyBytes(txID), outs)
+	})
+}
+
+// Adds transaction outputs to the UTXO set. It is used to load the set from a snapshot
+func (n *txManager) AddUnspentTransaction(txID []byte, outputs []structures.TXOutputIndependent) error {
+	u := n.getUnspentOutputsManager()
+
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return err
+	}
+
+	txData, err := u.serializeOutputs(outputs)
+
+	if err != nil {
+		return err
+	}
+
+	return uodb.PutDataForTransaction(txID, txData)
+}
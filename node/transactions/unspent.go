@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"sort"
 
@@ -580,6 +581,15 @@ func (u unspentTransactions) UpdateOnBlockCancel(block *structures.Block) error
 			}
 
 			if txi == nil {
+				pruned, err := u.newTransactionIndex().isTransactionPruned(vin.Txid)
+
+				if err != nil {
+					return err
+				}
+
+				if pruned {
+					return errors.New(fmt.Sprintf("Block %x can not be canceled. It spends outputs of pruned block", block.Hash))
+				}
 				// TX is not found in current BC . no sense to add it to unspent
 				u.Logger.Trace.Printf("tx not found in current BC") //REM
 				break
@@ -653,11 +663,6 @@ func (u unspentTransactions) GetNewTransactionInputs(PubKey []byte, to string, a
 		return localError(err)
 	}
 
-	bcMan, err := blockchain.NewBlockchainManager(u.DB, u.Logger)
-
-	if err != nil {
-		return localError(err)
-	}
 	// here we don't calculate is total amount is good or no.
 	// later we will add unconfirmed transactions if no enough funds
 
@@ -669,7 +674,7 @@ func (u unspentTransactions) GetNewTransactionInputs(PubKey []byte, to string, a
 		input := structures.TXInput{out.TXID, out.OIndex, nil, PubKey}
 		inputs = append(inputs, input)
 
-		prevTX, err := bcMan.GetTransactionFromBlock(out.TXID, out.BlockHash)
+		prevTX, err := u.getTransactionFromBlock(out.TXID, out.BlockHash)
 
 		if err != nil {
 			return localError(err)
@@ -726,12 +731,6 @@ func (u unspentTransactions) VerifyTransactionsOutputsAreNotSpent(txilist []stru
 		return localError(err)
 	}
 
-	bcMan, err := blockchain.NewBlockchainManager(u.DB, u.Logger)
-
-	if err != nil {
-		return localError(err)
-	}
-
 	for txiInd, txi := range txilist {
 		txdata, err := uodb.GetDataForTransaction(txi.Txid)
 
@@ -767,7 +766,7 @@ func (u unspentTransactions) VerifyTransactionsOutputsAreNotSpent(txilist []stru
 			inputTX[txiInd] = nil
 		} else {
 			// find this TX and get full info about it
-			prevTX, err := bcMan.GetTransactionFromBlock(txi.Txid, blockHash)
+			prevTX, err := u.getTransactionFromBlock(txi.Txid, blockHash)
 
 			if err != nil {
 				return localError(err)
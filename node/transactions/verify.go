@@ -0,0 +1,351 @@
+package transactions
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/gelembjuk/democoin/lib/utils"
+	"github.com/gelembjuk/democoin/node/structures"
+)
+
+// Checks caches (transactions index and unspent outputs) for a block of primary chain.
+// The top hash is used to find spendings of outputs made by later blocks
+// Returns list of problems. If repair is true then problems are fixed
+func (n *txManager) VerifyBlockCaches(block *structures.Block, topHash []byte, repair bool) ([]string, error) {
+	problems, err := n.getIndexManager().VerifyBlock(block, repair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uproblems, err := n.getUnspentOutputsManager().VerifyBlock(block, topHash, repair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(problems, uproblems...), nil
+}
+
+// Checks records of transactions index for transactions of a block.
+// Every TX must be linked to the block and every input must be in spent outputs records.
+// Links to blocks that don't exist anymore are reported too.
+// Returns list of problems. If repair is true then problems are fixed
+func (ti *transactionsIndex) VerifyBlock(block *structures.Block, repair bool) ([]string, error) {
+	problems := []string{}
+
+	txdb, err := ti.DB.GetTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	bcdb, err := ti.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		hashes, err := ti.GetTranactionBlocks(tx.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		goodHashes := [][]byte{}
+		linked := false
+		changed := false
+
+		for _, hash := range hashes {
+			if bytes.Compare(hash, block.Hash) == 0 {
+				linked = true
+			}
+
+			exists, err := bcdb.CheckBlockExists(hash)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !exists {
+				problems = append(problems, fmt.Sprintf("TX %x is linked to missed block %x", tx.ID, hash))
+				changed = true
+				continue
+			}
+			goodHashes = append(goodHashes, hash)
+		}
+
+		if !linked {
+			problems = append(problems, fmt.Sprintf("TX %x is not linked to block %x", tx.ID, block.Hash))
+			goodHashes = append(goodHashes, block.Hash[:])
+			changed = true
+		}
+
+		if repair && changed {
+			hashesData, err := ti.SerializeHashes(goodHashes)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = txdb.PutTXToBlockLink(tx.ID, hashesData)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for inInd, vin := range tx.Vin {
+			to, err := txdb.GetTXSpentOutputs(vin.Txid)
+
+			if err != nil {
+				return nil, err
+			}
+
+			outs := []TransactionsIndexSpentOutputs{}
+
+			if to != nil {
+				outs, err = ti.DeserializeOutputs(to)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			found := false
+
+			for _, o := range outs {
+				if o.OutInd == vin.Vout && o.InInd == inInd &&
+					bytes.Compare(o.TXWhereUsed, tx.ID) == 0 &&
+					bytes.Compare(o.BlockHash, block.Hash) == 0 {
+					found = true
+					break
+				}
+			}
+
+			if found {
+				continue
+			}
+
+			pruned, err := ti.isTransactionPruned(vin.Txid)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if pruned {
+				// records of spent outputs of pruned transactions are not kept
+				continue
+			}
+
+			problems = append(problems,
+				fmt.Sprintf("Output %d of TX %x is not marked as spent in TX %x block %x", vin.Vout, vin.Txid, tx.ID, block.Hash))
+
+			if !repair {
+				continue
+			}
+
+			outs = append(outs, TransactionsIndexSpentOutputs{vin.Vout, tx.ID[:], inInd, block.Hash[:]})
+
+			to, err = ti.SerializeOutputs(outs)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = txdb.PutTXSpentOutputs(vin.Txid, to)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Checks unspent outputs records for transactions of a block in primary chain.
+// Outputs of block transactions must be in the set if they are not spent till the top.
+// Outputs used by inputs of block transactions must not be in the set.
+// Returns list of problems. If repair is true then problems are fixed
+func (u unspentTransactions) VerifyBlock(block *structures.Block, topHash []byte, repair bool) ([]string, error) {
+	problems := []string{}
+
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		spending, err := u.newTransactionIndex().GetTranactionOutputsSpent(tx.ID, block.Hash, topHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sender := []byte{}
+
+		if !tx.IsCoinbase() {
+			sender, _ = utils.HashPubKey(tx.Vin[len(tx.Vin)-1].PubKey)
+		}
+
+		expected := []structures.TXOutputIndependent{}
+
+		for outInd, out := range tx.Vout {
+			spent := false
+
+			for _, so := range spending {
+				if so.OutInd == outInd {
+					spent = true
+					break
+				}
+			}
+
+			if !spent {
+				no := structures.TXOutputIndependent{}
+				no.LoadFromSimple(out, tx.ID, outInd, sender, tx.IsCoinbase(), block.Hash)
+
+				expected = append(expected, no)
+			}
+		}
+
+		actual, err := u.getOutputsIndexes(tx.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expectedIndexes := []int{}
+
+		for _, o := range expected {
+			expectedIndexes = append(expectedIndexes, o.OIndex)
+		}
+
+		if fmt.Sprint(expectedIndexes) == fmt.Sprint(actual) {
+			continue
+		}
+
+		problems = append(problems,
+			fmt.Sprintf("Unspent outputs of TX %x are %v, expected %v", tx.ID, actual, expectedIndexes))
+
+		if !repair {
+			continue
+		}
+
+		if len(expected) == 0 {
+			err = uodb.DeleteDataForTransaction(tx.ID)
+		} else {
+			var txData []byte
+			txData, err = u.serializeOutputs(expected)
+
+			if err == nil {
+				err = uodb.PutDataForTransaction(tx.ID, txData)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// inputs of block transactions are spent. they must not be in the set
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			outsBytes, err := uodb.GetDataForTransaction(vin.Txid)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if outsBytes == nil {
+				continue
+			}
+
+			outs, err := u.deserializeOutputs(outsBytes)
+
+			if err != nil {
+				return nil, err
+			}
+
+			updatedOuts := []structures.TXOutputIndependent{}
+
+			for _, out := range outs {
+				if out.OIndex != vin.Vout {
+					updatedOuts = append(updatedOuts, out)
+				}
+			}
+
+			if len(updatedOuts) == len(outs) {
+				continue
+			}
+
+			problems = append(problems,
+				fmt.Sprintf("Output %d of TX %x is spent in TX %x but is in unspent outputs", vin.Vout, vin.Txid, tx.ID))
+
+			if !repair {
+				continue
+			}
+
+			if len(updatedOuts) == 0 {
+				err = uodb.DeleteDataForTransaction(vin.Txid)
+			} else {
+				var txData []byte
+				txData, err = u.serializeOutputs(updatedOuts)
+
+				if err == nil {
+					err = uodb.PutDataForTransaction(vin.Txid, txData)
+				}
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Returns sorted list of indexes of unspent outputs of a TX
+func (u unspentTransactions) getOutputsIndexes(txID []byte) ([]int, error) {
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	outsBytes, err := uodb.GetDataForTransaction(txID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []int{}
+
+	if outsBytes == nil {
+		return indexes, nil
+	}
+
+	outs, err := u.deserializeOutputs(outsBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range outs {
+		indexes = append(indexes, o.OIndex)
+	}
+
+	sort.Ints(indexes)
+
+	return indexes, nil
+}
@@ -28,6 +28,7 @@ func GetAppInput() (wallet.AppInput, error) {
 	cmd.StringVar(&input.NodeHost, "nodehost", "", "Node Server Host")
 	cmd.Float64Var(&input.Amount, "amount", 0, "Amount money to send")
 	cmd.StringVar(&input.LogDest, "logdest", "file", "Destination of logs. file or stdout")
+	cmd.BoolVar(&input.Encrypt, "encrypt", false, "Encrypt connections to the node")
 
 	datadirPtr := cmd.String("datadir", "", "Location of data files, config")
 
@@ -78,6 +79,9 @@ func GetAppInput() (wallet.AppInput, error) {
 		if input.Address == "" && config.Address != "" {
 			input.Address = config.Address
 		}
+		if !input.Encrypt && config.Encrypt {
+			input.Encrypt = true
+		}
 	}
 
 	return input, nil
@@ -122,6 +126,7 @@ func updateConfig(datadir string, c wallet.AppInput) error {
 	if c.Command == "setnode" {
 		config.NodeHost = c.NodeHost
 		config.NodePort = c.NodePort
+		config.Encrypt = c.Encrypt
 	}
 
 	// convert back to JSON and save to config file
@@ -154,5 +159,5 @@ func printUsage() {
 	fmt.Println("  listaddresses\n\t- Lists all addresses from the wallet file")
 	fmt.Println("  listbalances\n\t- Lists all addresses from the wallet file and show balance for each")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT\n\t- Send AMOUNT of coins from FROM address to TO. ")
-	fmt.Println("  setnode -nodehost HOST -nodeport PORT\n\t- Saves a node host and port to configfile. ")
+	fmt.Println("  setnode -nodehost HOST -nodeport PORT [-encrypt]\n\t- Saves a node host and port to configfile. With -encrypt connections to the node are encrypted")
 }